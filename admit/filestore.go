@@ -0,0 +1,92 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package admit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/flock"
+)
+
+type fileStore struct {
+	path   string
+	lock   *flock.T
+	config SharedConfig
+}
+
+// NewFileStore returns a TokenStore that keeps its state in the file at the
+// given path, which is created if it does not exist. Access to the file is
+// serialized with flock(2) on path+".lock", so the store may be shared by
+// processes on the same machine, or on file systems that support flock.
+// All processes sharing the store should use the same config.
+func NewFileStore(path string, config SharedConfig) TokenStore {
+	return &fileStore{path: path, lock: flock.New(path + ".lock"), config: config}
+}
+
+func (f *fileStore) Acquire(ctx context.Context, tokens int) (lease Lease, ok bool, err error) {
+	err = f.update(ctx, func(s *sharedState, now time.Time) error {
+		lease, ok = s.acquire(f.config, tokens, now)
+		return nil
+	})
+	return
+}
+
+func (f *fileStore) Renew(ctx context.Context, id string) error {
+	return f.update(ctx, func(s *sharedState, now time.Time) error {
+		return s.renew(f.config, id, now)
+	})
+}
+
+func (f *fileStore) Release(ctx context.Context, id string, ok bool) error {
+	return f.update(ctx, func(s *sharedState, now time.Time) error {
+		s.release(f.config, id, ok, now)
+		return nil
+	})
+}
+
+// update locks the state file, applies fn to its state, and writes the
+// state back if fn succeeds.
+func (f *fileStore) update(ctx context.Context, fn func(*sharedState, time.Time) error) (err error) {
+	if err = f.lock.Lock(ctx); err != nil {
+		return errors.E(err, "locking", f.path)
+	}
+	defer func() {
+		if uerr := f.lock.Unlock(); err == nil && uerr != nil {
+			err = errors.E(uerr, "unlocking", f.path)
+		}
+	}()
+	state := newSharedState(f.config)
+	b, err := ioutil.ReadFile(f.path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return errors.E(err, "reading", f.path)
+	case len(b) > 0:
+		if err = json.Unmarshal(b, state); err != nil {
+			return errors.E(errors.Integrity, err, "decoding", f.path)
+		}
+	}
+	if err = fn(state, time.Now()); err != nil {
+		return err
+	}
+	if b, err = json.Marshal(state); err != nil {
+		return errors.E(err, "encoding", f.path)
+	}
+	// Write to a temporary file and rename it, so that the state file is
+	// never partially written.
+	tmp := f.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0666); err != nil {
+		return errors.E(err, "writing", tmp)
+	}
+	if err = os.Rename(tmp, f.path); err != nil {
+		return errors.E(err, "renaming", tmp)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package admit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/errors"
+)
+
+// LeaseServer is an http.Handler that serves a TokenStore to clients
+// created by NewHTTPStore. Its state is kept in memory.
+//
+// It serves the following endpoints, each of which requires a POST:
+//
+//	acquire?tokens=N     acquires N tokens, responding with a JSON lease
+//	renew?id=ID          renews the lease ID, or responds 404 if it expired
+//	release?id=ID&ok=B   releases the lease ID
+type LeaseServer struct {
+	config SharedConfig
+	// now is used for faking time in tests.
+	now func() time.Time
+
+	mu    sync.Mutex
+	state *sharedState
+}
+
+// NewLeaseServer returns a new LeaseServer that regulates capacity as
+// configured by config.
+func NewLeaseServer(config SharedConfig) *LeaseServer {
+	return &LeaseServer{config: config, now: time.Now, state: newSharedState(config)}
+}
+
+// httpLease is the response to an acquire request.
+type httpLease struct {
+	OK  bool
+	ID  string
+	TTL time.Duration
+}
+
+// ServeHTTP implements http.Handler.
+func (s *LeaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch path.Base(r.URL.Path) {
+	case "acquire":
+		tokens, err := strconv.Atoi(query.Get("tokens"))
+		if err != nil || tokens < 0 {
+			http.Error(w, "invalid tokens", http.StatusBadRequest)
+			return
+		}
+		lease, ok := s.state.acquire(s.config, tokens, s.now())
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(httpLease{OK: ok, ID: lease.ID, TTL: lease.TTL})
+	case "renew":
+		if err := s.state.renew(s.config, query.Get("id"), s.now()); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+	case "release":
+		ok, err := strconv.ParseBool(query.Get("ok"))
+		if err != nil {
+			http.Error(w, "invalid ok", http.StatusBadRequest)
+			return
+		}
+		s.state.release(s.config, query.Get("id"), ok, s.now())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type httpStore struct {
+	client *http.Client
+	url    string
+}
+
+// NewHTTPStore returns a TokenStore served by the LeaseServer at the given
+// URL. If client is nil, http.DefaultClient is used.
+func NewHTTPStore(client *http.Client, url string) TokenStore {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpStore{client: client, url: strings.TrimSuffix(url, "/")}
+}
+
+func (h *httpStore) Acquire(ctx context.Context, tokens int) (Lease, bool, error) {
+	var resp httpLease
+	err := h.post(ctx, "acquire", url.Values{"tokens": {strconv.Itoa(tokens)}}, &resp)
+	if err != nil || !resp.OK {
+		return Lease{}, false, err
+	}
+	return Lease{ID: resp.ID, TTL: resp.TTL}, true, nil
+}
+
+func (h *httpStore) Renew(ctx context.Context, id string) error {
+	return h.post(ctx, "renew", url.Values{"id": {id}}, nil)
+}
+
+func (h *httpStore) Release(ctx context.Context, id string, ok bool) error {
+	return h.post(ctx, "release", url.Values{"id": {id}, "ok": {strconv.FormatBool(ok)}}, nil)
+}
+
+// post makes a request to the given endpoint, and decodes the JSON response
+// into resp, if it is not nil.
+func (h *httpStore) post(ctx context.Context, endpoint string, query url.Values, resp interface{}) error {
+	u := h.url + "/" + endpoint + "?" + query.Encode()
+	req, err := http.NewRequest(http.MethodPost, u, nil)
+	if err != nil {
+		return errors.E(errors.Invalid, err, "admit: creating request")
+	}
+	r, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.E(errors.Net, err, "admit:", endpoint)
+	}
+	defer r.Body.Close() // nolint: errcheck
+	switch {
+	case r.StatusCode == http.StatusNotFound && endpoint == "renew":
+		return errors.E(errors.NotExist, "admit: lease", query.Get("id"), "expired")
+	case r.StatusCode != http.StatusOK:
+		b, _ := ioutil.ReadAll(r.Body)
+		return errors.E(fmt.Sprintf("admit: %s: %s: %s", endpoint, r.Status, b))
+	case resp != nil:
+		if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+			return errors.E(errors.Integrity, err, "admit: decoding", endpoint, "response")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package admit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/base/retry"
+)
+
+// DefaultLeaseTTL is the lease TTL used when SharedConfig.LeaseTTL is zero.
+const DefaultLeaseTTL = 30 * time.Second
+
+// SharedConfig configures capacity that is shared by multiple processes
+// through a TokenStore. The shared limit is regulated by the
+// additive increase/multiplicative decrease algorithm, as with AIMD.
+type SharedConfig struct {
+	// Min and Max bound the shared limit, which starts at Min. If Max is
+	// zero, the limit is unbounded.
+	Min, Max int
+	// DecFactor is the factor by which the limit is reduced upon congestion.
+	DecFactor float32
+	// LeaseTTL is the time after which tokens that are not renewed are
+	// reclaimed, for example because the process that held them died.
+	LeaseTTL time.Duration
+}
+
+func (c SharedConfig) leaseTTL() time.Duration {
+	if c.LeaseTTL <= 0 {
+		return DefaultLeaseTTL
+	}
+	return c.LeaseTTL
+}
+
+// Lease is a grant of tokens by a TokenStore.
+type Lease struct {
+	// ID identifies the lease in calls to Renew and Release.
+	ID string
+	// TTL is the time after which the lease expires unless renewed.
+	TTL time.Duration
+}
+
+// TokenStore stores the state of capacity shared by multiple processes:
+// the shared limit, and the leases of tokens held against it. Leases that
+// are not renewed expire, so that the tokens of processes that die are
+// reclaimed.
+type TokenStore interface {
+	// Acquire leases the given number of tokens, if they are available. It
+	// returns ok == false, and no error, if they are not.
+	Acquire(ctx context.Context, tokens int) (lease Lease, ok bool, err error)
+	// Renew extends the lease with the given ID by its TTL. It returns an
+	// error of kind errors.NotExist if the lease has expired.
+	Renew(ctx context.Context, id string) error
+	// Release releases the lease with the given ID, reporting whether its
+	// use was within the capacity limits.
+	Release(ctx context.Context, id string, ok bool) error
+}
+
+// sharedState is the state kept by a TokenStore. Its methods implement the
+// admission policy, so that TokenStore implementations only need to
+// provide storage and atomicity.
+type sharedState struct {
+	Limit  int
+	Leases map[string]sharedLease
+}
+
+type sharedLease struct {
+	Tokens  int
+	Expires time.Time
+}
+
+func newSharedState(config SharedConfig) *sharedState {
+	return &sharedState{Limit: config.Min, Leases: make(map[string]sharedLease)}
+}
+
+// expire removes the leases that expired before now, and returns the number
+// of tokens used by the remaining leases.
+func (s *sharedState) expire(now time.Time) (used int) {
+	for id, l := range s.Leases {
+		if l.Expires.Before(now) {
+			delete(s.Leases, id)
+			continue
+		}
+		used += l.Tokens
+	}
+	return
+}
+
+func (s *sharedState) acquire(config SharedConfig, tokens int, now time.Time) (Lease, bool) {
+	if s.Leases == nil {
+		s.Leases = make(map[string]sharedLease)
+	}
+	used := s.expire(now)
+	if tokens > s.Limit-used && (tokens <= s.Limit || used > 0) {
+		return Lease{}, false
+	}
+	ttl := config.leaseTTL()
+	id := newLeaseID()
+	s.Leases[id] = sharedLease{Tokens: tokens, Expires: now.Add(ttl)}
+	return Lease{ID: id, TTL: ttl}, true
+}
+
+func (s *sharedState) renew(config SharedConfig, id string, now time.Time) error {
+	s.expire(now)
+	l, ok := s.Leases[id]
+	if !ok {
+		return errors.E(errors.NotExist, "lease "+id+" expired")
+	}
+	l.Expires = now.Add(config.leaseTTL())
+	s.Leases[id] = l
+	return nil
+}
+
+func (s *sharedState) release(config SharedConfig, id string, ok bool, now time.Time) {
+	used := s.expire(now)
+	if _, held := s.Leases[id]; !held {
+		// The lease expired; its tokens were already reclaimed.
+		return
+	}
+	switch {
+	case !ok:
+		s.Limit = max(config.Min, adjust(s.Limit, -config.DecFactor))
+	case used >= s.Limit && (config.Max == 0 || s.Limit < config.Max):
+		s.Limit++
+	}
+	delete(s.Leases, id)
+}
+
+func newLeaseID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+type shared struct {
+	store TokenStore
+	poll  retry.Policy
+
+	mu sync.Mutex
+	// leases contains the held leases, keyed by their number of tokens.
+	// Policy.Release does not identify the acquisition it releases, so
+	// any lease with the released number of tokens is released.
+	leases map[int][]*heldLease
+}
+
+type heldLease struct {
+	Lease
+	// cancel stops the renewal of the lease, aborting any in-flight
+	// call to TokenStore.Renew.
+	cancel context.CancelFunc
+}
+
+// Shared returns a Policy whose capacity is coordinated with other
+// processes through the given store. Acquire polls the store, with jittered
+// exponential backoff, until the requested tokens are available. While
+// tokens are held, their lease is renewed in the background; if the process
+// dies, the tokens are reclaimed when the lease expires.
+//
+// Calls to renew and release a lease are bounded by the lease's TTL, after
+// which the store reclaims the tokens regardless, so an unresponsive store
+// cannot block Release indefinitely.
+//
+// Errors from the store are returned by Acquire; since Release cannot
+// return errors, they are logged.
+func Shared(store TokenStore) Policy {
+	return &shared{
+		store:  store,
+		poll:   retry.Jitter(retry.Backoff(10*time.Millisecond, time.Second, 1.5), 0.25),
+		leases: make(map[int][]*heldLease),
+	}
+}
+
+// Acquire acquires a number of tokens from the shared store.
+// Returns on success, or if the context was canceled.
+func (s *shared) Acquire(ctx context.Context, need int) error {
+	for retries := 0; ; retries++ {
+		lease, ok, err := s.store.Acquire(ctx, need)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if lease.TTL <= 0 {
+				_ = s.store.Release(ctx, lease.ID, true)
+				return errors.E(errors.Invalid, fmt.Sprintf("admit: lease %s granted with non-positive TTL %v", lease.ID, lease.TTL))
+			}
+			s.hold(lease, need)
+			return nil
+		}
+		if err := retry.Wait(ctx, s.poll, retries); err != nil {
+			return err
+		}
+	}
+}
+
+// Release releases a number of tokens to the shared store,
+// reporting whether the request was within the capacity limits.
+func (s *shared) Release(tokens int, ok bool) {
+	s.mu.Lock()
+	held := s.leases[tokens]
+	if len(held) == 0 {
+		s.mu.Unlock()
+		panic("admit: release of tokens that are not held")
+	}
+	h := held[len(held)-1]
+	s.leases[tokens] = held[:len(held)-1]
+	s.mu.Unlock()
+
+	h.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), h.TTL)
+	defer cancel()
+	if err := s.store.Release(ctx, h.ID, ok); err != nil {
+		log.Error.Printf("admit: releasing lease %s: %v", h.ID, err)
+	}
+}
+
+// hold records lease, and renews it until it is released.
+// Each renewal is bounded by the renewal interval, so that a stuck call
+// does not delay the next one past the lease's expiry.
+func (s *shared) hold(lease Lease, tokens int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &heldLease{Lease: lease, cancel: cancel}
+	s.mu.Lock()
+	s.leases[tokens] = append(s.leases[tokens], h)
+	s.mu.Unlock()
+	interval := lease.TTL / 3
+	if interval <= 0 {
+		interval = lease.TTL
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			rctx, rcancel := context.WithTimeout(ctx, interval)
+			err := s.store.Renew(rctx, lease.ID)
+			rcancel()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Error.Printf("admit: renewing lease %s: %v", lease.ID, err)
+				if errors.Is(errors.NotExist, err) {
+					return
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,206 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package admit
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/traverse"
+)
+
+func TestSharedState(t *testing.T) {
+	config := SharedConfig{Min: 2, Max: 3, DecFactor: 0.5, LeaseTTL: time.Minute}
+	s := newSharedState(config)
+	now := time.Now()
+	a, ok := s.acquire(config, 1, now)
+	if !ok {
+		t.Fatal("acquire failed")
+	}
+	b, ok := s.acquire(config, 1, now)
+	if !ok {
+		t.Fatal("acquire failed")
+	}
+	if _, ok = s.acquire(config, 1, now); ok {
+		t.Fatal("acquired over limit")
+	}
+	// Releasing at the limit increases it.
+	s.release(config, a.ID, true, now)
+	if got, want := s.Limit, 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Congestion decreases it, down to Min.
+	s.release(config, b.ID, false, now)
+	if got, want := s.Limit, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// A request larger than the limit is admitted when no tokens are held.
+	big, ok := s.acquire(config, 5, now)
+	if !ok {
+		t.Fatal("acquire failed")
+	}
+	// Leases expire unless renewed.
+	now = now.Add(time.Minute / 2)
+	if err := s.renew(config, big.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute / 2)
+	if _, ok = s.acquire(config, 1, now); ok {
+		t.Fatal("renewed lease expired")
+	}
+	now = now.Add(time.Minute)
+	if err := s.renew(config, big.ID, now); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v, want NotExist", err)
+	}
+	if _, ok = s.acquire(config, 1, now); !ok {
+		t.Fatal("expired lease was not reclaimed")
+	}
+}
+
+// testShared runs processes concurrently using policies sharing the store
+// returned by newStore, and checks that they do not together exceed the
+// limit.
+func testShared(t *testing.T, newStore func() TokenStore) {
+	const (
+		nproc = 4
+		limit = 3
+	)
+	var used, maxUsed int32
+	err := traverse.Each(nproc, func(int) error {
+		p := Shared(newStore())
+		return traverse.Each(10, func(int) error {
+			return Do(context.Background(), p, 1, func() (bool, error) {
+				n := atomic.AddInt32(&used, 1)
+				for {
+					m := atomic.LoadInt32(&maxUsed)
+					if n <= m || atomic.CompareAndSwapInt32(&maxUsed, m, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&used, -1)
+				// Report congestion, so that the limit stays at Min.
+				return false, nil
+			})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxUsed > limit {
+		t.Errorf("used %d tokens concurrently, limit %d", maxUsed, limit)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "tokens")
+	config := SharedConfig{Min: 3, Max: 3, DecFactor: 0.5}
+	testShared(t, func() TokenStore { return NewFileStore(path, config) })
+}
+
+func TestHTTPStore(t *testing.T) {
+	srv := httptest.NewServer(NewLeaseServer(SharedConfig{Min: 3, Max: 3, DecFactor: 0.5}))
+	defer srv.Close()
+	testShared(t, func() TokenStore { return NewHTTPStore(srv.Client(), srv.URL) })
+}
+
+func TestHTTPStoreLeaseExpiry(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		now = time.Now()
+	)
+	server := NewLeaseServer(SharedConfig{Min: 1, LeaseTTL: time.Minute})
+	server.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+	store := NewHTTPStore(srv.Client(), srv.URL)
+	ctx := context.Background()
+
+	// A worker acquires the only token, and then dies.
+	lease, ok, err := store.Acquire(ctx, 1)
+	if err != nil || !ok {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+	if lease.TTL != time.Minute {
+		t.Errorf("got %v, want %v", lease.TTL, time.Minute)
+	}
+	if _, ok, _ = store.Acquire(ctx, 1); ok {
+		t.Fatal("acquired over limit")
+	}
+	mu.Lock()
+	now = now.Add(2 * time.Minute)
+	mu.Unlock()
+	if err = store.Renew(ctx, lease.ID); !errors.Is(errors.NotExist, err) {
+		t.Errorf("got %v, want NotExist", err)
+	}
+	p := Shared(store)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err = p.Acquire(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	p.Release(1, true)
+}
+
+// stuckStore is a TokenStore whose Renew and Release block until their
+// context is done, as with an unreachable lease server.
+type stuckStore struct {
+	ttl time.Duration
+}
+
+func (s stuckStore) Acquire(ctx context.Context, tokens int) (Lease, bool, error) {
+	return Lease{ID: "stuck", TTL: s.ttl}, true, nil
+}
+
+func (stuckStore) Renew(ctx context.Context, id string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (stuckStore) Release(ctx context.Context, id string, ok bool) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSharedStuckStore(t *testing.T) {
+	const ttl = 30 * time.Millisecond
+	p := Shared(stuckStore{ttl: ttl})
+	if err := p.Acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	// Let a renewal get stuck.
+	time.Sleep(ttl / 2)
+	start := time.Now()
+	p.Release(1, true)
+	if elapsed := time.Since(start); elapsed > 10*ttl {
+		t.Errorf("release took %v, want at most about %v", elapsed, ttl)
+	}
+}
+
+func TestSharedInvalidTTL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	p := Shared(stuckStore{ttl: 0})
+	if err := p.Acquire(ctx, 1); !errors.Is(errors.Invalid, err) {
+		t.Errorf("got %v, want Invalid", err)
+	}
+}
@@ -4,4 +4,7 @@
 
 // Package bitset provides support for treating a []uintptr as a bitset.  It's
 // essentially a less-abstracted variant of github.com/willf/bitset.
+//
+// RankIndex adds rank and select queries over a []uintptr bitset, and Runs is
+// a run-length compressed bitset for sparse or highly clustered bit patterns.
 package bitset
@@ -0,0 +1,114 @@
+// Copyright 2022 GRAIL, Inc.  All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package bitset
+
+import (
+	"math/bits"
+	"sort"
+)
+
+const (
+	// wordsPerRankBlock is the number of words covered by each entry of the
+	// rank directory.  With 8 words (512 bits) per entry, the directory adds
+	// 12.5% to the size of the bitset.
+	wordsPerRankBlock = 8
+	// selectSampleRate is the number of set bits between consecutive entries
+	// of the select directory.
+	selectSampleRate = 4096
+)
+
+// RankIndex supports popcount-based rank and select queries over a []uintptr
+// bitset.  The bitset must not be modified after the index is built.
+type RankIndex struct {
+	data []uintptr
+	// ranks[i] is the number of set bits in data[:i*wordsPerRankBlock].
+	ranks []int
+	// samples[i] is the index of the rank block containing the
+	// (i*selectSampleRate)th set bit.
+	samples []int
+	count   int
+}
+
+// NewRankIndex builds a RankIndex over the given bitset.
+func NewRankIndex(data []uintptr) *RankIndex {
+	nBlock := (len(data) + wordsPerRankBlock - 1) / wordsPerRankBlock
+	r := &RankIndex{
+		data:  data,
+		ranks: make([]int, nBlock+1),
+	}
+	count := 0
+	for blockIdx := 0; blockIdx < nBlock; blockIdx++ {
+		r.ranks[blockIdx] = count
+		limit := (blockIdx + 1) * wordsPerRankBlock
+		if limit > len(data) {
+			limit = len(data)
+		}
+		blockCount := 0
+		for _, word := range data[blockIdx*wordsPerRankBlock : limit] {
+			blockCount += bits.OnesCount64(uint64(word))
+		}
+		// Record every sample that falls within this block.
+		for len(r.samples)*selectSampleRate < count+blockCount {
+			r.samples = append(r.samples, blockIdx)
+		}
+		count += blockCount
+	}
+	r.ranks[nBlock] = count
+	r.count = count
+	return r
+}
+
+// Count returns the total number of set bits.
+func (r *RankIndex) Count() int { return r.count }
+
+// Rank returns the number of set bits at positions [0, bitIdx).  bitIdx must
+// be in [0, len(data)*BitsPerWord].
+func (r *RankIndex) Rank(bitIdx int) int {
+	wordIdx := int(uint(bitIdx) / BitsPerWord)
+	blockIdx := wordIdx / wordsPerRankBlock
+	rank := r.ranks[blockIdx]
+	for _, word := range r.data[blockIdx*wordsPerRankBlock : wordIdx] {
+		rank += bits.OnesCount64(uint64(word))
+	}
+	if bitOffset := uint(bitIdx) % BitsPerWord; bitOffset != 0 {
+		rank += bits.OnesCount64(uint64(r.data[wordIdx]) & (1<<bitOffset - 1))
+	}
+	return rank
+}
+
+// Select returns the position of the set bit with the given rank, i.e. the
+// smallest bitIdx such that Rank(bitIdx+1) == rank+1.  It returns -1 if rank
+// is negative or at least Count().
+func (r *RankIndex) Select(rank int) int {
+	if rank < 0 || rank >= r.count {
+		return -1
+	}
+	// The sampled directory bounds the range of blocks to binary-search.
+	sampleIdx := rank / selectSampleRate
+	lo, hi := r.samples[sampleIdx], len(r.ranks)-1
+	if sampleIdx+1 < len(r.samples) {
+		hi = r.samples[sampleIdx+1] + 1
+	}
+	// Find the last block whose starting rank is <= rank.
+	blockIdx := lo + sort.Search(hi-lo, func(i int) bool { return r.ranks[lo+i] > rank }) - 1
+	remaining := rank - r.ranks[blockIdx]
+	for wordIdx := blockIdx * wordsPerRankBlock; ; wordIdx++ {
+		word := uint64(r.data[wordIdx])
+		n := bits.OnesCount64(word)
+		if remaining < n {
+			return wordIdx*BitsPerWord + selectInWord(word, remaining)
+		}
+		remaining -= n
+	}
+}
+
+// selectInWord returns the position of the set bit with the given rank in
+// word, which must have more than rank set bits.
+func selectInWord(word uint64, rank int) int {
+	for ; rank > 0; rank-- {
+		word &= word - 1
+	}
+	return bits.TrailingZeros64(word)
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 GRAIL, Inc.  All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package bitset_test
+
+import (
+	"math/rand"
+	"testing"
+
+	gbitset "github.com/grailbio/base/bitset"
+	"github.com/grailbio/testutil/expect"
+)
+
+func TestRankSelect(t *testing.T) {
+	rand.Seed(1)
+	for _, nWord := range []int{0, 1, 7, 8, 9, 1000, 3000} {
+		for _, density := range []float64{0, 0.001, 0.5, 1} {
+			nBits := nWord * gbitset.BitsPerWord
+			bs := gbitset.NewClearBits(nBits)
+			var positions []int
+			for i := 0; i < nBits; i++ {
+				if rand.Float64() < density {
+					gbitset.Set(bs, i)
+					positions = append(positions, i)
+				}
+			}
+			r := gbitset.NewRankIndex(bs)
+			expect.EQ(t, r.Count(), len(positions))
+			rank := 0
+			for i := 0; i <= nBits; i++ {
+				if r.Rank(i) != rank {
+					t.Fatalf("nWord %d, density %v: Rank(%d) = %d, want %d", nWord, density, i, r.Rank(i), rank)
+				}
+				if i < nBits && gbitset.Test(bs, i) {
+					rank++
+				}
+			}
+			for k, pos := range positions {
+				if got := r.Select(k); got != pos {
+					t.Fatalf("nWord %d, density %v: Select(%d) = %d, want %d", nWord, density, k, got, pos)
+				}
+			}
+			expect.EQ(t, r.Select(-1), -1)
+			expect.EQ(t, r.Select(len(positions)), -1)
+		}
+	}
+}
+
+func BenchmarkSelect(b *testing.B) {
+	const nBits = 1 << 24
+	bs := gbitset.NewClearBits(nBits)
+	for i := 0; i < nBits/4; i++ {
+		gbitset.Set(bs, rand.Intn(nBits))
+	}
+	r := gbitset.NewRankIndex(bs)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = r.Select(i % r.Count())
+	}
+}
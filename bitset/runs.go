@@ -0,0 +1,232 @@
+// Copyright 2022 GRAIL, Inc.  All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package bitset
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Runs is a run-length compressed bitset: the set bits are stored as a sorted
+// list of disjoint, non-adjacent half-open intervals [start, limit).  Its size
+// is proportional to the number of runs rather than the number of bits, which
+// makes it well suited to masks with long stretches of set or clear bits,
+// e.g. coverage masks over a genome.
+//
+// The zero value is an empty bitset.  Runs is thread compatible.
+type Runs struct {
+	// bounds holds the runs as [start0, limit0, start1, limit1, ...].
+	bounds []int
+}
+
+// RunsFromBits creates a Runs with the same set bits as the first nBit bits
+// of a []uintptr bitset.
+func RunsFromBits(data []uintptr, nBit int) *Runs {
+	r := &Runs{}
+	start := -1
+	for wordIdx := 0; wordIdx*BitsPerWord < nBit; wordIdx++ {
+		word := uint64(data[wordIdx])
+		bitIdxOffset := wordIdx * BitsPerWord
+		if limit := nBit - bitIdxOffset; limit < BitsPerWord {
+			word &= 1<<uint(limit) - 1
+		}
+		// Consume alternating stretches of set and clear bits.
+		pos := 0
+		for pos < BitsPerWord {
+			if start < 0 {
+				n := bits.TrailingZeros64(word >> uint(pos))
+				if pos+n >= BitsPerWord {
+					break
+				}
+				pos += n
+				start = bitIdxOffset + pos
+			} else {
+				n := bits.TrailingZeros64(^word >> uint(pos))
+				if pos+n >= BitsPerWord {
+					break
+				}
+				pos += n
+				r.bounds = append(r.bounds, start, bitIdxOffset+pos)
+				start = -1
+			}
+		}
+	}
+	if start >= 0 {
+		r.bounds = append(r.bounds, start, nBit)
+	}
+	return r
+}
+
+// Bits returns a []uintptr bitset with capacity for at least nBit bits,
+// containing the set bits of r.  Bits at positions >= nBit are dropped.
+func (r *Runs) Bits(nBit int) []uintptr {
+	data := NewClearBits(nBit)
+	for i := 0; i < len(r.bounds); i += 2 {
+		start, limit := r.bounds[i], r.bounds[i+1]
+		if start >= nBit {
+			break
+		}
+		if limit > nBit {
+			limit = nBit
+		}
+		SetInterval(data, start, limit)
+	}
+	return data
+}
+
+// NumRuns returns the number of runs of set bits.
+func (r *Runs) NumRuns() int { return len(r.bounds) / 2 }
+
+// Count returns the number of set bits.
+func (r *Runs) Count() int {
+	n := 0
+	for i := 0; i < len(r.bounds); i += 2 {
+		n += r.bounds[i+1] - r.bounds[i]
+	}
+	return n
+}
+
+// search returns the index of the first run whose limit is > bitIdx.
+func (r *Runs) search(bitIdx int) int {
+	return sort.Search(len(r.bounds)/2, func(i int) bool { return r.bounds[2*i+1] > bitIdx })
+}
+
+// Test returns true iff the given bit is set.
+func (r *Runs) Test(bitIdx int) bool {
+	i := r.search(bitIdx)
+	return i < len(r.bounds)/2 && r.bounds[2*i] <= bitIdx
+}
+
+// SetInterval sets the bits at all positions in [startIdx, limitIdx).
+// Setting intervals in increasing order takes amortized constant time.
+func (r *Runs) SetInterval(startIdx, limitIdx int) {
+	if startIdx >= limitIdx {
+		return
+	}
+	if n := len(r.bounds); n == 0 || r.bounds[n-1] < startIdx {
+		r.bounds = append(r.bounds, startIdx, limitIdx)
+		return
+	}
+	// Find the runs that overlap or abut [startIdx, limitIdx), and replace
+	// them with their union.
+	lo := sort.Search(len(r.bounds)/2, func(i int) bool { return r.bounds[2*i+1] >= startIdx })
+	hi := sort.Search(len(r.bounds)/2, func(i int) bool { return r.bounds[2*i] > limitIdx })
+	if lo < hi {
+		if r.bounds[2*lo] < startIdx {
+			startIdx = r.bounds[2*lo]
+		}
+		if r.bounds[2*hi-1] > limitIdx {
+			limitIdx = r.bounds[2*hi-1]
+		}
+	}
+	r.replace(lo, hi, startIdx, limitIdx)
+}
+
+// ClearInterval clears the bits at all positions in [startIdx, limitIdx).
+func (r *Runs) ClearInterval(startIdx, limitIdx int) {
+	if startIdx >= limitIdx {
+		return
+	}
+	// Find the runs that overlap [startIdx, limitIdx), and replace them with
+	// the parts that lie outside of it.
+	lo := r.search(startIdx)
+	hi := sort.Search(len(r.bounds)/2, func(i int) bool { return r.bounds[2*i] >= limitIdx })
+	if lo >= hi {
+		return
+	}
+	var keep []int
+	if r.bounds[2*lo] < startIdx {
+		keep = append(keep, r.bounds[2*lo], startIdx)
+	}
+	if r.bounds[2*hi-1] > limitIdx {
+		keep = append(keep, limitIdx, r.bounds[2*hi-1])
+	}
+	r.bounds = append(r.bounds[:2*lo], append(keep, r.bounds[2*hi:]...)...)
+}
+
+// replace replaces runs [lo, hi) with the run [start, limit).
+func (r *Runs) replace(lo, hi, start, limit int) {
+	switch {
+	case lo == hi:
+		r.bounds = append(r.bounds, 0, 0)
+		copy(r.bounds[2*lo+2:], r.bounds[2*lo:])
+	case hi > lo+1:
+		r.bounds = append(r.bounds[:2*lo+2], r.bounds[2*hi:]...)
+	}
+	r.bounds[2*lo], r.bounds[2*lo+1] = start, limit
+}
+
+// Scan calls fn for each run of set bits [start, limit), in increasing order,
+// until fn returns false.
+func (r *Runs) Scan(fn func(start, limit int) bool) {
+	for i := 0; i < len(r.bounds); i += 2 {
+		if !fn(r.bounds[i], r.bounds[i+1]) {
+			return
+		}
+	}
+}
+
+// ScanBits calls fn for the position of each set bit, in increasing order,
+// until fn returns false.
+func (r *Runs) ScanBits(fn func(bitIdx int) bool) {
+	for i := 0; i < len(r.bounds); i += 2 {
+		for bitIdx := r.bounds[i]; bitIdx < r.bounds[i+1]; bitIdx++ {
+			if !fn(bitIdx) {
+				return
+			}
+		}
+	}
+}
+
+// Union returns the union of a and b.  It takes time proportional to the
+// total number of runs.
+func Union(a, b *Runs) *Runs {
+	out := &Runs{bounds: make([]int, 0, len(a.bounds)+len(b.bounds))}
+	i, j := 0, 0
+	for i < len(a.bounds) || j < len(b.bounds) {
+		var start, limit int
+		if j == len(b.bounds) || i < len(a.bounds) && a.bounds[i] <= b.bounds[j] {
+			start, limit = a.bounds[i], a.bounds[i+1]
+			i += 2
+		} else {
+			start, limit = b.bounds[j], b.bounds[j+1]
+			j += 2
+		}
+		if n := len(out.bounds); n > 0 && out.bounds[n-1] >= start {
+			if limit > out.bounds[n-1] {
+				out.bounds[n-1] = limit
+			}
+			continue
+		}
+		out.bounds = append(out.bounds, start, limit)
+	}
+	return out
+}
+
+// Intersection returns the intersection of a and b.  It takes time
+// proportional to the total number of runs.
+func Intersection(a, b *Runs) *Runs {
+	out := &Runs{}
+	i, j := 0, 0
+	for i < len(a.bounds) && j < len(b.bounds) {
+		start, limit := a.bounds[i], a.bounds[i+1]
+		if b.bounds[j] > start {
+			start = b.bounds[j]
+		}
+		if b.bounds[j+1] < limit {
+			limit = b.bounds[j+1]
+		}
+		if start < limit {
+			out.bounds = append(out.bounds, start, limit)
+		}
+		// Advance past the run that ends first.
+		if a.bounds[i+1] < b.bounds[j+1] {
+			i += 2
+		} else {
+			j += 2
+		}
+	}
+	return out
+}
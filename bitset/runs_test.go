@@ -0,0 +1,84 @@
+// Copyright 2022 GRAIL, Inc.  All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package bitset_test
+
+import (
+	"math/rand"
+	"testing"
+
+	gbitset "github.com/grailbio/base/bitset"
+	"github.com/grailbio/testutil/expect"
+)
+
+// randomRuns applies random SetInterval and ClearInterval operations to both
+// a Runs and a []uintptr bitset.
+func randomRuns(nBits, nOps int) (*gbitset.Runs, []uintptr) {
+	var (
+		r  gbitset.Runs
+		bs = gbitset.NewClearBits(nBits)
+	)
+	for i := 0; i < nOps; i++ {
+		startIdx := rand.Intn(nBits)
+		limitIdx := startIdx + rand.Intn(1+(nBits-startIdx)/8)
+		if rand.Intn(3) == 0 {
+			r.ClearInterval(startIdx, limitIdx)
+			gbitset.ClearInterval(bs, startIdx, limitIdx)
+		} else {
+			r.SetInterval(startIdx, limitIdx)
+			gbitset.SetInterval(bs, startIdx, limitIdx)
+		}
+	}
+	return &r, bs
+}
+
+func expectRunsEQ(t *testing.T, r *gbitset.Runs, bs []uintptr, nBits int) {
+	t.Helper()
+	count, prevLimit := 0, -1
+	r.Scan(func(start, limit int) bool {
+		if start <= prevLimit || start >= limit {
+			t.Fatalf("invalid run [%d, %d) after limit %d", start, limit, prevLimit)
+		}
+		prevLimit = limit
+		return true
+	})
+	for i := 0; i < nBits; i++ {
+		if r.Test(i) != gbitset.Test(bs, i) {
+			t.Fatalf("bit %d: got %v, want %v", i, r.Test(i), gbitset.Test(bs, i))
+		}
+		if gbitset.Test(bs, i) {
+			count++
+		}
+	}
+	expect.EQ(t, r.Count(), count)
+	expect.EQ(t, r.Bits(nBits), bs)
+	expect.EQ(t, gbitset.RunsFromBits(bs, nBits), r)
+}
+
+func TestRuns(t *testing.T) {
+	rand.Seed(1)
+	for _, nBits := range []int{1, 63, 64, 65, 1000} {
+		for trial := 0; trial < 20; trial++ {
+			a, abs := randomRuns(nBits, trial)
+			expectRunsEQ(t, a, abs, nBits)
+			b, bbs := randomRuns(nBits, trial)
+
+			union := gbitset.NewClearBits(nBits)
+			intersection := gbitset.NewClearBits(nBits)
+			for i := range union {
+				union[i] = abs[i] | bbs[i]
+				intersection[i] = abs[i] & bbs[i]
+			}
+			expectRunsEQ(t, gbitset.Union(a, b), union, nBits)
+			expectRunsEQ(t, gbitset.Intersection(a, b), intersection, nBits)
+
+			var bitIdxs []int
+			a.ScanBits(func(bitIdx int) bool {
+				bitIdxs = append(bitIdxs, bitIdx)
+				return true
+			})
+			expect.EQ(t, len(bitIdxs), a.Count())
+		}
+	}
+}
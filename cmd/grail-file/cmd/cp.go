@@ -128,12 +128,13 @@ func copyFile(ctx context.Context, dst file.File, src file.File) error {
 }
 
 func copyStream(ctx context.Context, dst io.Writer, src io.Reader) error {
-	item, err := parLimiter.Acquire(ctx)
-	if err != nil {
+	if err := parLimiter.Acquire(ctx, 1); err != nil {
 		return err
 	}
-	defer item.Release()
-	_, err = io.CopyBuffer(dst, src, item.Buf())
+	defer parLimiter.Release(1)
+	buf := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(buf)
+	_, err := io.CopyBuffer(dst, src, *buf)
 	return err
 }
 
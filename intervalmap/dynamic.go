@@ -0,0 +1,482 @@
+package intervalmap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
+
+// Ordered is the set of key types supported by Map.
+type Ordered interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64 | ~string
+}
+
+// IntervalOf defines a half-open interval, [Start, Limit), over keys of type
+// K. It has the same semantics as Interval.
+type IntervalOf[K Ordered] struct {
+	// Start is included
+	Start K
+	// Limit is excluded.
+	Limit K
+}
+
+// Intersects checks if (i∩j) != ∅
+func (i IntervalOf[K]) Intersects(j IntervalOf[K]) bool {
+	return i.Limit > j.Start && j.Limit > i.Start
+}
+
+// Empty checks if the interval is empty.
+func (i IntervalOf[K]) Empty() bool { return i.Start >= i.Limit }
+
+// Contains checks if the key is in the interval.
+func (i IntervalOf[K]) Contains(key K) bool { return i.Start <= key && key < i.Limit }
+
+// EntryOf is an interval over keys of type K and its payload. It has the
+// same semantics as Entry.
+type EntryOf[K Ordered, V any] struct {
+	// Interval defines a half-open interval, [Start,Limit)
+	Interval IntervalOf[K]
+	// Data is an arbitrary user-defined payload
+	Data V
+}
+
+// Item is an interval stored in a Map, along with its payload. Items are
+// returned by Map.Insert, and are used to delete intervals from the map.
+// An item's interval orders it within the map, so it cannot be changed;
+// to move an item, delete it and insert a new one.
+type Item[K Ordered, V any] struct {
+	interval IntervalOf[K]
+	// Data is an arbitrary user-defined payload
+	Data V
+
+	id uint64 // disambiguates items with equal intervals.
+}
+
+// Interval returns the item's half-open interval, [Start,Limit).
+func (it *Item[K, V]) Interval() IntervalOf[K] { return it.interval }
+
+// Map is a set of (potentially overlapping) intervals that, unlike T, may be
+// updated incrementally. It is implemented as a pair of AVL trees: one
+// ordered by interval start and augmented with the maximum limit of each
+// subtree, which answers overlap and stabbing queries in O(log n + k) time;
+// and one ordered by interval limit, which answers nearest-neighbor queries
+// in O(log n) time. Insert and Delete take O(log n) time.
+//
+// Map is thread compatible. The zero value is an empty map.
+type Map[K Ordered, V any] struct {
+	byStart, byLimit *dnode[K, V]
+	n                int
+	nextID           uint64
+}
+
+// NewMap creates a map with the given set of intervals.
+func NewMap[K Ordered, V any](entries []EntryOf[K, V]) *Map[K, V] {
+	m := &Map[K, V]{}
+	for _, e := range entries {
+		m.Insert(e.Interval, e.Data)
+	}
+	return m
+}
+
+// Len returns the number of intervals in the map.
+func (m *Map[K, V]) Len() int { return m.n }
+
+// Insert adds the interval with the given payload to the map. It returns the
+// map's item, which may be passed to Delete. Empty intervals may be inserted,
+// but they never intersect any interval.
+func (m *Map[K, V]) Insert(interval IntervalOf[K], data V) *Item[K, V] {
+	it := &Item[K, V]{interval: interval, Data: data, id: m.nextID}
+	m.nextID++
+	m.byStart = insertNode(m.byStart, it, lessStart[K, V])
+	m.byLimit = insertNode(m.byLimit, it, lessLimit[K, V])
+	m.n++
+	return it
+}
+
+// Delete removes the item, which must have been returned by Insert, from the
+// map. It returns false if the item is not in the map.
+func (m *Map[K, V]) Delete(it *Item[K, V]) bool {
+	var ok bool
+	if m.byStart, ok = deleteNode(m.byStart, it, lessStart[K, V]); !ok {
+		return false
+	}
+	m.byLimit, _ = deleteNode(m.byLimit, it, lessLimit[K, V])
+	m.n--
+	return true
+}
+
+// Get finds all the items that intersect the given interval and returns
+// them in *items, ordered by interval start.
+func (m *Map[K, V]) Get(interval IntervalOf[K], items *[]*Item[K, V]) {
+	*items = (*items)[:0]
+	if interval.Empty() {
+		return
+	}
+	m.byStart.get(interval, func(it *Item[K, V]) bool {
+		*items = append(*items, it)
+		return true
+	})
+}
+
+// Any checks if any of the items intersect the given interval.
+func (m *Map[K, V]) Any(interval IntervalOf[K]) bool {
+	found := false
+	if !interval.Empty() {
+		m.byStart.get(interval, func(*Item[K, V]) bool {
+			found = true
+			return false
+		})
+	}
+	return found
+}
+
+// Stab returns the number of intervals that contain the given key.
+func (m *Map[K, V]) Stab(key K) int {
+	n := 0
+	m.byStart.stab(key, &n)
+	return n
+}
+
+// Before returns the item closest to the given key among those that end at
+// or before it, that is, the item with the largest Limit <= key. Ties are
+// broken in favor of the latest Start. Before returns false if there is no
+// such item.
+func (m *Map[K, V]) Before(key K) (*Item[K, V], bool) {
+	var best *Item[K, V]
+	for n := m.byLimit; n != nil; {
+		if n.item.interval.Limit <= key {
+			best = n.item
+			n = n.right
+		} else {
+			n = n.left
+		}
+	}
+	return best, best != nil
+}
+
+// After returns the item closest to the given key among those that start at
+// or after it, that is, the item with the smallest Start >= key. Ties are
+// broken in favor of the earliest Limit. After returns false if there is no
+// such item.
+func (m *Map[K, V]) After(key K) (*Item[K, V], bool) {
+	var best *Item[K, V]
+	for n := m.byStart; n != nil; {
+		if n.item.interval.Start >= key {
+			best = n.item
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return best, best != nil
+}
+
+// Ascend calls fn for each item in the map, in order of interval start,
+// until fn returns false.
+func (m *Map[K, V]) Ascend(fn func(*Item[K, V]) bool) {
+	m.byStart.ascend(fn)
+}
+
+func lessStart[K Ordered, V any](a, b *Item[K, V]) bool {
+	if a.interval.Start != b.interval.Start {
+		return a.interval.Start < b.interval.Start
+	}
+	if a.interval.Limit != b.interval.Limit {
+		return a.interval.Limit < b.interval.Limit
+	}
+	return a.id < b.id
+}
+
+func lessLimit[K Ordered, V any](a, b *Item[K, V]) bool {
+	if a.interval.Limit != b.interval.Limit {
+		return a.interval.Limit < b.interval.Limit
+	}
+	if a.interval.Start != b.interval.Start {
+		return a.interval.Start < b.interval.Start
+	}
+	return a.id < b.id
+}
+
+// dnode is a node in an AVL tree of items. Each node tracks the maximum
+// interval limit in its subtree.
+type dnode[K Ordered, V any] struct {
+	item        *Item[K, V]
+	left, right *dnode[K, V]
+	height      int
+	maxLimit    K
+}
+
+func (n *dnode[K, V]) getHeight() int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+// update recomputes the node's height and maximum limit from its children.
+func (n *dnode[K, V]) update() {
+	n.height = n.left.getHeight() + 1
+	if h := n.right.getHeight() + 1; h > n.height {
+		n.height = h
+	}
+	n.maxLimit = n.item.interval.Limit
+	if n.left != nil && n.left.maxLimit > n.maxLimit {
+		n.maxLimit = n.left.maxLimit
+	}
+	if n.right != nil && n.right.maxLimit > n.maxLimit {
+		n.maxLimit = n.right.maxLimit
+	}
+}
+
+func rotateRight[K Ordered, V any](n *dnode[K, V]) *dnode[K, V] {
+	l := n.left
+	n.left = l.right
+	n.update()
+	l.right = n
+	l.update()
+	return l
+}
+
+func rotateLeft[K Ordered, V any](n *dnode[K, V]) *dnode[K, V] {
+	r := n.right
+	n.right = r.left
+	n.update()
+	r.left = n
+	r.update()
+	return r
+}
+
+// balance restores the AVL invariant at n, whose subtrees are balanced and
+// differ in height by at most 2.
+func balance[K Ordered, V any](n *dnode[K, V]) *dnode[K, V] {
+	n.update()
+	switch bf := n.left.getHeight() - n.right.getHeight(); {
+	case bf > 1:
+		if n.left.left.getHeight() < n.left.right.getHeight() {
+			n.left = rotateLeft(n.left)
+		}
+		return rotateRight(n)
+	case bf < -1:
+		if n.right.right.getHeight() < n.right.left.getHeight() {
+			n.right = rotateRight(n.right)
+		}
+		return rotateLeft(n)
+	}
+	return n
+}
+
+func insertNode[K Ordered, V any](n *dnode[K, V], it *Item[K, V], less func(a, b *Item[K, V]) bool) *dnode[K, V] {
+	if n == nil {
+		return &dnode[K, V]{item: it, height: 1, maxLimit: it.interval.Limit}
+	}
+	if less(it, n.item) {
+		n.left = insertNode(n.left, it, less)
+	} else {
+		n.right = insertNode(n.right, it, less)
+	}
+	return balance(n)
+}
+
+func deleteNode[K Ordered, V any](n *dnode[K, V], it *Item[K, V], less func(a, b *Item[K, V]) bool) (*dnode[K, V], bool) {
+	if n == nil {
+		return nil, false
+	}
+	var ok bool
+	switch {
+	case it == n.item:
+		if n.left == nil {
+			return n.right, true
+		}
+		if n.right == nil {
+			return n.left, true
+		}
+		var min *dnode[K, V]
+		n.right, min = removeMin(n.right)
+		min.left, min.right = n.left, n.right
+		return balance(min), true
+	case less(it, n.item):
+		n.left, ok = deleteNode(n.left, it, less)
+	default:
+		n.right, ok = deleteNode(n.right, it, less)
+	}
+	return balance(n), ok
+}
+
+// removeMin removes the leftmost node from the tree rooted at n. It returns
+// the new root and the removed node.
+func removeMin[K Ordered, V any](n *dnode[K, V]) (root, min *dnode[K, V]) {
+	if n.left == nil {
+		return n.right, n
+	}
+	n.left, min = removeMin(n.left)
+	return balance(n), min
+}
+
+// get calls fn for each item intersecting the nonempty interval, in order,
+// until fn returns false. It returns false if fn did.
+func (n *dnode[K, V]) get(interval IntervalOf[K], fn func(*Item[K, V]) bool) bool {
+	if n == nil || n.maxLimit <= interval.Start {
+		return true
+	}
+	if !n.left.get(interval, fn) {
+		return false
+	}
+	if n.item.interval.Start >= interval.Limit {
+		// No item in the right subtree can intersect the interval.
+		return true
+	}
+	if n.item.interval.Intersects(interval) && !fn(n.item) {
+		return false
+	}
+	return n.right.get(interval, fn)
+}
+
+func (n *dnode[K, V]) stab(key K, count *int) {
+	if n == nil || n.maxLimit <= key {
+		return
+	}
+	n.left.stab(key, count)
+	if n.item.interval.Start > key {
+		return
+	}
+	if n.item.interval.Contains(key) {
+		*count++
+	}
+	n.right.stab(key, count)
+}
+
+func (n *dnode[K, V]) ascend(fn func(*Item[K, V]) bool) bool {
+	if n == nil {
+		return true
+	}
+	return n.left.ascend(fn) && fn(n.item) && n.right.ascend(fn)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler interface.  It allows Map
+// to be encoded and decoded using Gob. Map uses T's encoding, with
+// gobFormatVersion: the intervals are written as a single leaf node, so a Map
+// with Key-typed keys may be decoded as a T, and vice versa. As with T, the
+// payloads are encoded as interface values, so their concrete types must be
+// registered with gob.Register.
+func (m *Map[K, V]) MarshalBinary() (data []byte, err error) {
+	buf := bytes.Buffer{}
+	e := gob.NewEncoder(&buf)
+	encode := func(v interface{}) {
+		if err == nil {
+			err = e.Encode(v)
+		}
+	}
+	var bounds IntervalOf[K]
+	if n := m.byStart; n != nil {
+		for n.left != nil {
+			n = n.left
+		}
+		bounds = IntervalOf[K]{n.item.interval.Start, m.byStart.maxLimit}
+	}
+	encode(gobFormatVersion)
+	encode(true) // Root node.
+	encode(bounds)
+	encode(false) // No left child.
+	encode(false) // No right child.
+	encode(m.n)
+	id := 0
+	m.Ascend(func(it *Item[K, V]) bool {
+		encode(EntryOf[K, interface{}]{it.interval, it.Data})
+		encode(id)
+		id++
+		return err == nil
+	})
+	encode("") // Label.
+	encode(TreeStats{
+		Nodes:             1,
+		LeafNodes:         1,
+		MaxLeafNodeSize:   m.n,
+		TotalLeafNodeSize: m.n,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler interface.
+// It allows Map to be encoded and decoded using Gob. It accepts the
+// encodings of both Map and T.
+func (m *Map[K, V]) UnmarshalBinary(data []byte) error {
+	d := gob.NewDecoder(bytes.NewReader(data))
+	var version int
+	if err := d.Decode(&version); err != nil {
+		return err
+	}
+	if version != gobFormatVersion {
+		return fmt.Errorf("gob decode: got version %d, want %d", version, gobFormatVersion)
+	}
+	var (
+		ents []EntryOf[K, V]
+		seen = make(map[int]bool)
+	)
+	if err := unmarshalMapNode(d, seen, &ents); err != nil {
+		return err
+	}
+	var stats TreeStats
+	if err := d.Decode(&stats); err != nil {
+		return err
+	}
+	*m = *NewMap(ents)
+	return nil
+}
+
+// unmarshalMapNode decodes a node in T's encoding, appending the entries not
+// yet seen to *ents. T may store an entry in more than one leaf; seen holds
+// the IDs of the entries decoded so far.
+func unmarshalMapNode[K Ordered, V any](d *gob.Decoder, seen map[int]bool, ents *[]EntryOf[K, V]) error {
+	var exist bool
+	if err := d.Decode(&exist); err != nil {
+		return err
+	}
+	if !exist {
+		return nil
+	}
+	var bounds IntervalOf[K]
+	if err := d.Decode(&bounds); err != nil {
+		return err
+	}
+	if err := unmarshalMapNode(d, seen, ents); err != nil {
+		return err
+	}
+	if err := unmarshalMapNode(d, seen, ents); err != nil {
+		return err
+	}
+	var nEnt int
+	if err := d.Decode(&nEnt); err != nil {
+		return err
+	}
+	for i := 0; i < nEnt; i++ {
+		var (
+			e  EntryOf[K, interface{}]
+			id int
+		)
+		if err := d.Decode(&e); err != nil {
+			return err
+		}
+		if err := d.Decode(&id); err != nil {
+			return err
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ent := EntryOf[K, V]{Interval: e.Interval}
+		if e.Data != nil {
+			var ok bool
+			if ent.Data, ok = e.Data.(V); !ok {
+				return fmt.Errorf("gob decode: got payload of type %T, want %T", e.Data, ent.Data)
+			}
+		}
+		*ents = append(*ents, ent)
+	}
+	var label string
+	return d.Decode(&label)
+}
@@ -0,0 +1,193 @@
+package intervalmap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/grailbio/testutil/assert"
+	"github.com/grailbio/testutil/expect"
+)
+
+// checkNode verifies the AVL and augmentation invariants of the subtree
+// rooted at n.
+func checkNode[K Ordered, V any](t *testing.T, n *dnode[K, V], less func(a, b *Item[K, V]) bool) {
+	t.Helper()
+	if n == nil {
+		return
+	}
+	checkNode(t, n.left, less)
+	checkNode(t, n.right, less)
+	if n.left != nil && !less(n.left.item, n.item) || n.right != nil && !less(n.item, n.right.item) {
+		t.Fatal("tree out of order")
+	}
+	if bf := n.left.getHeight() - n.right.getHeight(); bf < -1 || bf > 1 {
+		t.Fatalf("unbalanced node: %d", bf)
+	}
+	height, maxLimit := n.height, n.maxLimit
+	n.update()
+	if height != n.height || maxLimit != n.maxLimit {
+		t.Fatal("stale node")
+	}
+}
+
+func itemIntervals(items []*Item[Key, int]) []Interval {
+	intervals := []Interval{}
+	for _, it := range items {
+		intervals = append(intervals, Interval{it.Interval().Start, it.Interval().Limit})
+	}
+	return intervals
+}
+
+func TestMapRandom(t *testing.T) {
+	const (
+		N   = 2000
+		max = 1000
+	)
+	r := rand.New(rand.NewSource(0))
+	var (
+		m     Map[Key, int]
+		items []*Item[Key, int]
+		model slowModel
+		got   []*Item[Key, int]
+	)
+	for i := 0; i < N; i++ {
+		if len(items) > 0 && r.Intn(3) == 0 {
+			// Delete a random item.
+			j := r.Intn(len(items))
+			assert.True(t, m.Delete(items[j]))
+			assert.False(t, m.Delete(items[j]))
+			items[j] = items[len(items)-1]
+			items = items[:len(items)-1]
+			model = model[:0]
+			for _, it := range items {
+				model.insert(it.Interval().Start, it.Interval().Limit)
+			}
+		} else {
+			start, limit := randInterval(r, max, 20)
+			items = append(items, m.Insert(IntervalOf[Key]{start, limit}, i))
+			model.insert(start, limit)
+		}
+		assert.EQ(t, m.Len(), len(items))
+		if i%50 != 0 {
+			continue
+		}
+		checkNode(t, m.byStart, lessStart[Key, int])
+		checkNode(t, m.byLimit, lessLimit[Key, int])
+		for j := 0; j < 20; j++ {
+			start, limit := randInterval(r, max, 50)
+			m.Get(IntervalOf[Key]{start, limit}, &got)
+			want := model.get(start, limit)
+			expect.EQ(t, sortIntervals(itemIntervals(got)), sortIntervals(want))
+			expect.EQ(t, m.Any(IntervalOf[Key]{start, limit}), len(want) > 0)
+
+			key := Key(r.Intn(max))
+			stab := 0
+			var before, after *Interval
+			for k := range model {
+				iv := model[k]
+				if iv.Start <= key && key < iv.Limit {
+					stab++
+				}
+				if iv.Limit <= key && (before == nil || iv.Limit > before.Limit ||
+					iv.Limit == before.Limit && iv.Start > before.Start) {
+					before = &model[k]
+				}
+				if iv.Start >= key && (after == nil || iv.Start < after.Start ||
+					iv.Start == after.Start && iv.Limit < after.Limit) {
+					after = &model[k]
+				}
+			}
+			expect.EQ(t, m.Stab(key), stab)
+			if it, ok := m.Before(key); ok {
+				expect.EQ(t, Interval{it.Interval().Start, it.Interval().Limit}, *before)
+			} else {
+				expect.True(t, before == nil)
+			}
+			if it, ok := m.After(key); ok {
+				expect.EQ(t, Interval{it.Interval().Start, it.Interval().Limit}, *after)
+			} else {
+				expect.True(t, after == nil)
+			}
+		}
+	}
+}
+
+func TestMapStringKeys(t *testing.T) {
+	m := NewMap([]EntryOf[string, string]{
+		{Interval: IntervalOf[string]{"apple", "banana"}, Data: "a"},
+		{Interval: IntervalOf[string]{"avocado", "cherry"}, Data: "b"},
+		{Interval: IntervalOf[string]{"grape", "lemon"}, Data: "c"},
+	})
+	var got []*Item[string, string]
+	m.Get(IntervalOf[string]{"b", "c"}, &got)
+	assert.EQ(t, len(got), 2)
+	expect.EQ(t, got[0].Data, "a")
+	expect.EQ(t, got[1].Data, "b")
+	expect.EQ(t, m.Stab("azure"), 2)
+	expect.EQ(t, m.Stab("kiwi"), 1)
+	it, ok := m.Before("date")
+	assert.True(t, ok)
+	expect.EQ(t, it.Data, "b")
+	it, ok = m.After("date")
+	assert.True(t, ok)
+	expect.EQ(t, it.Data, "c")
+	_, ok = m.After("mango")
+	expect.False(t, ok)
+}
+
+func TestMapGob(t *testing.T) {
+	m := NewMap([]EntryOf[Key, string]{
+		{Interval: IntervalOf[Key]{1, 4}, Data: "[1,4)"},
+		{Interval: IntervalOf[Key]{3, 5}, Data: "[3,5)"},
+		{Interval: IntervalOf[Key]{3, 5}, Data: ""},
+		{Interval: IntervalOf[Key]{6, 7}, Data: "[6,7)"},
+	})
+	buf := bytes.Buffer{}
+	assert.NoError(t, gob.NewEncoder(&buf).Encode(m))
+	var m2 *Map[Key, string]
+	assert.NoError(t, gob.NewDecoder(&buf).Decode(&m2))
+	assert.EQ(t, m2.Len(), 4)
+	var got []*Item[Key, string]
+	m2.Get(IntervalOf[Key]{0, 4}, &got)
+	var data []string
+	for _, it := range got {
+		data = append(data, it.Data)
+	}
+	sort.Strings(data)
+	expect.EQ(t, data, []string{"", "[1,4)", "[3,5)"})
+
+	// Map and T share an encoding.
+	var ents []Entry
+	for i := 0; i < 100; i++ {
+		ents = append(ents, Entry{Interval{Key(i), Key(i + 10)}, fmt.Sprint(i)})
+	}
+	data2, err := New(ents).MarshalBinary()
+	assert.NoError(t, err)
+	var m3 Map[Key, string]
+	assert.NoError(t, m3.UnmarshalBinary(data2))
+	expect.EQ(t, m3.Len(), 100)
+	expect.EQ(t, m3.Stab(50), 10)
+	got = nil
+	m3.Get(IntervalOf[Key]{95, 96}, &got)
+	expect.EQ(t, len(got), 10)
+
+	data3, err := m.MarshalBinary()
+	assert.NoError(t, err)
+	var t3 T
+	assert.NoError(t, t3.UnmarshalBinary(data3))
+	var tgot []*Entry
+	t3.Get(Interval{0, 4}, &tgot)
+	data = nil
+	for _, e := range tgot {
+		data = append(data, e.Data.(string))
+	}
+	sort.Strings(data)
+	expect.EQ(t, data, []string{"", "[1,4)", "[3,5)"})
+
+	var m4 Map[Key, int]
+	expect.HasSubstr(t, m4.UnmarshalBinary(data3), "payload")
+}
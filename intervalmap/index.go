@@ -0,0 +1,391 @@
+package intervalmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// The on-disk index format is designed to be queried through an io.ReaderAt
+// (e.g., a memory-mapped file or an S3 object) without loading the entries
+// into memory. Entries are sorted by interval start and stored in blocks,
+// followed by a block index and a trailer:
+//
+//	index := block* blockIndex trailer
+//	block :=
+//		entry*
+//		crc32: uint32             // IEEE crc32 of the block's entries
+//	entry :=
+//		start:  uvarint           // delta from the previous start in the block
+//		length: uvarint           // Limit - Start
+//		ndata:  uvarint           // size of the payload
+//		data:   uint8[ndata]      // the payload
+//	blockIndex := nblock: uvarint, blockInfo[nblock]
+//	blockInfo :=
+//		start:    varint          // delta from the previous block's first start
+//		maxLimit: uvarint         // maximum limit in the block, minus start
+//		size:     uvarint         // size of the block, including crc
+//		nentry:   uvarint         // number of entries in the block
+//	trailer :=
+//		offset: uint64            // offset of the block index
+//		size:   uint64            // size of the block index
+//		magic:  uint64            // indexMagic
+//
+// The first start of each block is stored in the block index, so that the
+// first entry's start delta is relative to it. Blocks are laid out
+// contiguously, so that block offsets are implied by their sizes.
+//
+// When opened, the block index is loaded into memory, and an implicit
+// interval tree (a max-heap of block limits) is built over it. A query reads
+// only the blocks that may contain intersecting entries.
+
+const (
+	indexMagic       = uint64(0x1c7f3b9a5e2d4c81)
+	indexTrailerSize = 24
+	// DefaultIndexBlockSize is the default target size of index blocks.
+	DefaultIndexBlockSize = 1 << 16
+)
+
+// IndexWriter writes an on-disk interval index. Entries must be appended in
+// order of interval start.
+type IndexWriter struct {
+	w         *bufio.Writer
+	blockSize int
+	off       int64
+	err       error
+
+	block      []byte
+	blockStart Key
+	prevStart  Key
+	maxLimit   Key
+	nblockEnt  int
+	blocks     []blockInfo
+	scratch    [3 * binary.MaxVarintLen64]byte
+}
+
+type blockInfo struct {
+	start, maxLimit Key
+	off, size       int64
+	nentry          int
+}
+
+// NewIndexWriter creates a writer that writes an index to w. Blocks are
+// flushed when they reach blockSize bytes; if blockSize <= 0,
+// DefaultIndexBlockSize is used.
+func NewIndexWriter(w io.Writer, blockSize int) *IndexWriter {
+	if blockSize <= 0 {
+		blockSize = DefaultIndexBlockSize
+	}
+	return &IndexWriter{w: bufio.NewWriter(w), blockSize: blockSize}
+}
+
+// Append adds an entry with the given interval and payload to the index.
+// Intervals must be nonempty, and appended in order of Start.
+func (w *IndexWriter) Append(interval Interval, data []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if interval.Empty() {
+		w.err = fmt.Errorf("intervalmap: empty interval %v", interval)
+		return w.err
+	}
+	if w.nblockEnt == 0 {
+		if len(w.blocks) > 0 && interval.Start < w.prevStart {
+			w.err = fmt.Errorf("intervalmap: interval %v appended out of order", interval)
+			return w.err
+		}
+		w.blockStart, w.prevStart, w.maxLimit = interval.Start, interval.Start, interval.Limit
+	} else if interval.Start < w.prevStart {
+		w.err = fmt.Errorf("intervalmap: interval %v appended out of order", interval)
+		return w.err
+	}
+	n := binary.PutUvarint(w.scratch[:], uint64(interval.Start-w.prevStart))
+	n += binary.PutUvarint(w.scratch[n:], uint64(interval.Limit-interval.Start))
+	n += binary.PutUvarint(w.scratch[n:], uint64(len(data)))
+	w.block = append(w.block, w.scratch[:n]...)
+	w.block = append(w.block, data...)
+	w.prevStart = interval.Start
+	if interval.Limit > w.maxLimit {
+		w.maxLimit = interval.Limit
+	}
+	w.nblockEnt++
+	if len(w.block) >= w.blockSize {
+		w.err = w.flush()
+	}
+	return w.err
+}
+
+// flush writes the current block.
+func (w *IndexWriter) flush() error {
+	if w.nblockEnt == 0 {
+		return nil
+	}
+	w.block = append(w.block, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(w.block[len(w.block)-4:], crc32.ChecksumIEEE(w.block[:len(w.block)-4]))
+	if _, err := w.w.Write(w.block); err != nil {
+		return err
+	}
+	w.blocks = append(w.blocks, blockInfo{
+		start:    w.blockStart,
+		maxLimit: w.maxLimit,
+		off:      w.off,
+		size:     int64(len(w.block)),
+		nentry:   w.nblockEnt,
+	})
+	w.off += int64(len(w.block))
+	w.block = w.block[:0]
+	w.nblockEnt = 0
+	return nil
+}
+
+// Close flushes the remaining entries and writes the block index and
+// trailer. It does not close the underlying writer.
+func (w *IndexWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = w.flush(); w.err != nil {
+		return w.err
+	}
+	index := w.scratch[:binary.PutUvarint(w.scratch[:], uint64(len(w.blocks)))]
+	index = append([]byte(nil), index...)
+	var prevStart Key
+	for _, b := range w.blocks {
+		n := binary.PutVarint(w.scratch[:], b.start-prevStart)
+		n += binary.PutUvarint(w.scratch[n:], uint64(b.maxLimit-b.start))
+		index = append(index, w.scratch[:n]...)
+		n = binary.PutUvarint(w.scratch[:], uint64(b.size))
+		n += binary.PutUvarint(w.scratch[n:], uint64(b.nentry))
+		index = append(index, w.scratch[:n]...)
+		prevStart = b.start
+	}
+	var trailer [indexTrailerSize]byte
+	binary.LittleEndian.PutUint64(trailer[0:], uint64(w.off))
+	binary.LittleEndian.PutUint64(trailer[8:], uint64(len(index)))
+	binary.LittleEndian.PutUint64(trailer[16:], indexMagic)
+	if _, w.err = w.w.Write(index); w.err != nil {
+		return w.err
+	}
+	if _, w.err = w.w.Write(trailer[:]); w.err != nil {
+		return w.err
+	}
+	w.err = w.w.Flush()
+	if w.err == nil {
+		w.err = errors.New("intervalmap: index writer closed")
+		return nil
+	}
+	return w.err
+}
+
+// Index is an on-disk interval index written by IndexWriter. Index is safe
+// for concurrent use, provided that the underlying io.ReaderAt is.
+type Index struct {
+	r      io.ReaderAt
+	blocks []blockInfo
+	// tree is an implicit binary tree over the blocks: tree[1] is the
+	// root, the children of tree[i] are tree[2i] and tree[2i+1], and leaf
+	// i is tree[len(tree)/2+i]. Each node holds the maximum limit of the
+	// blocks below it.
+	tree []Key
+	n    int
+}
+
+// readFullAt reads len(p) bytes at offset off of r.
+func readFullAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		// ReadAt may return io.EOF along with the last bytes.
+		return nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// OpenIndex opens the index stored in r, whose contents are size bytes long.
+// Only the block index is read into memory.
+func OpenIndex(r io.ReaderAt, size int64) (*Index, error) {
+	if size < indexTrailerSize {
+		return nil, errors.New("intervalmap: index too small")
+	}
+	var trailer [indexTrailerSize]byte
+	if err := readFullAt(r, trailer[:], size-indexTrailerSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint64(trailer[16:]) != indexMagic {
+		return nil, errors.New("intervalmap: invalid index: wrong magic number")
+	}
+	off, n := int64(binary.LittleEndian.Uint64(trailer[0:])), int64(binary.LittleEndian.Uint64(trailer[8:]))
+	if off < 0 || n < 0 || off+n != size-indexTrailerSize {
+		return nil, errors.New("intervalmap: invalid index: bad block index address")
+	}
+	p := make([]byte, n)
+	if err := readFullAt(r, p, off); err != nil {
+		return nil, err
+	}
+	x := &Index{r: r}
+	dec := indexDecoder{p: p}
+	nblock := dec.uvarint()
+	if dec.err == nil && nblock > uint64(len(p)) {
+		dec.err = errors.New("too many blocks")
+	}
+	var (
+		start    Key
+		blockOff int64
+	)
+	x.blocks = make([]blockInfo, 0, nblock)
+	for i := uint64(0); i < nblock && dec.err == nil; i++ {
+		var b blockInfo
+		start += dec.varint()
+		b.start = start
+		b.maxLimit = start + Key(dec.uvarint())
+		b.off = blockOff
+		b.size = int64(dec.uvarint())
+		b.nentry = int(dec.uvarint())
+		blockOff += b.size
+		x.blocks = append(x.blocks, b)
+		x.n += b.nentry
+	}
+	if dec.err != nil {
+		return nil, fmt.Errorf("intervalmap: invalid block index: %v", dec.err)
+	}
+	if blockOff != off {
+		return nil, errors.New("intervalmap: invalid block index: block sizes do not match")
+	}
+	nleaf := 1
+	for nleaf < len(x.blocks) {
+		nleaf *= 2
+	}
+	x.tree = make([]Key, 2*nleaf)
+	for i := range x.tree {
+		x.tree[i] = minKey
+	}
+	for i, b := range x.blocks {
+		x.tree[nleaf+i] = b.maxLimit
+	}
+	for i := nleaf - 1; i > 0; i-- {
+		x.tree[i] = x.tree[2*i]
+		if x.tree[2*i+1] > x.tree[i] {
+			x.tree[i] = x.tree[2*i+1]
+		}
+	}
+	return x, nil
+}
+
+const minKey = Key(-1 << 63)
+
+// Len returns the number of entries in the index.
+func (x *Index) Len() int { return x.n }
+
+// Get calls fn for each entry that intersects the given interval, in order
+// of interval start, until fn returns false. The data passed to fn is valid
+// only during the call.
+func (x *Index) Get(interval Interval, fn func(interval Interval, data []byte) bool) error {
+	if interval.Empty() || len(x.blocks) == 0 {
+		return nil
+	}
+	// Blocks at or after end contain only entries that start at or after
+	// interval.Limit.
+	end := sort.Search(len(x.blocks), func(i int) bool { return x.blocks[i].start >= interval.Limit })
+	_, err := x.get(1, 0, len(x.tree)/2, end, interval, fn)
+	return err
+}
+
+// get visits the blocks in [lo, hi) covered by tree node i, and before end.
+func (x *Index) get(i, lo, hi, end int, interval Interval, fn func(Interval, []byte) bool) (bool, error) {
+	if lo >= end || x.tree[i] <= interval.Start {
+		return true, nil
+	}
+	if hi-lo == 1 {
+		return x.getBlock(&x.blocks[lo], interval, fn)
+	}
+	mid := (lo + hi) / 2
+	ok, err := x.get(2*i, lo, mid, end, interval, fn)
+	if !ok || err != nil {
+		return ok, err
+	}
+	return x.get(2*i+1, mid, hi, end, interval, fn)
+}
+
+func (x *Index) getBlock(b *blockInfo, interval Interval, fn func(Interval, []byte) bool) (bool, error) {
+	if b.size < 4 {
+		return false, errors.New("intervalmap: invalid index: block too small")
+	}
+	p := make([]byte, b.size)
+	if err := readFullAt(x.r, p, b.off); err != nil {
+		return false, err
+	}
+	p, crc := p[:len(p)-4], binary.LittleEndian.Uint32(p[len(p)-4:])
+	if crc32.ChecksumIEEE(p) != crc {
+		return false, fmt.Errorf("intervalmap: corrupt block at offset %d: checksum mismatch", b.off)
+	}
+	var (
+		dec   = indexDecoder{p: p}
+		start = b.start
+	)
+	for i := 0; i < b.nentry; i++ {
+		start += Key(dec.uvarint())
+		limit := start + Key(dec.uvarint())
+		data := dec.bytes(dec.uvarint())
+		if dec.err != nil {
+			return false, fmt.Errorf("intervalmap: corrupt block at offset %d: %v", b.off, dec.err)
+		}
+		if start >= interval.Limit {
+			break
+		}
+		if limit > interval.Start && !fn(Interval{start, limit}, data) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// indexDecoder decodes varints from a buffer, recording the first error.
+type indexDecoder struct {
+	p   []byte
+	err error
+}
+
+func (d *indexDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.p)
+	if n <= 0 {
+		d.err = errors.New("bad uvarint")
+		return 0
+	}
+	d.p = d.p[n:]
+	return v
+}
+
+func (d *indexDecoder) varint() Key {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.p)
+	if n <= 0 {
+		d.err = errors.New("bad varint")
+		return 0
+	}
+	d.p = d.p[n:]
+	return v
+}
+
+func (d *indexDecoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.p)) {
+		d.err = errors.New("truncated data")
+		return nil
+	}
+	b := d.p[:n]
+	d.p = d.p[n:]
+	return b
+}
@@ -0,0 +1,104 @@
+package intervalmap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/grailbio/testutil/assert"
+	"github.com/grailbio/testutil/expect"
+)
+
+func TestIndexRandom(t *testing.T) {
+	const max = 100000
+	r := rand.New(rand.NewSource(0))
+	for _, n := range []int{0, 1, 10, 5000} {
+		var model slowModel
+		for i := 0; i < n; i++ {
+			start, limit := randInterval(r, max, 100)
+			if i%1000 == 0 {
+				// Occasional long intervals.
+				limit += max / 2
+			}
+			model.insert(start, limit)
+		}
+		sort.SliceStable(model, func(i, j int) bool { return model[i].Start < model[j].Start })
+
+		var buf bytes.Buffer
+		w := NewIndexWriter(&buf, 256)
+		for _, iv := range model {
+			assert.NoError(t, w.Append(iv, []byte(fmt.Sprint(iv))))
+		}
+		assert.NoError(t, w.Close())
+		x, err := OpenIndex(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		assert.NoError(t, err)
+		expect.EQ(t, x.Len(), n)
+
+		for j := 0; j < 200; j++ {
+			start, limit := randInterval(r, max, 500)
+			got := []Interval{}
+			assert.NoError(t, x.Get(Interval{start, limit}, func(iv Interval, data []byte) bool {
+				expect.EQ(t, string(data), fmt.Sprint(iv))
+				got = append(got, iv)
+				return true
+			}))
+			expect.True(t, sort.SliceIsSorted(got, func(i, j int) bool { return got[i].Start < got[j].Start }))
+			expect.EQ(t, sortIntervals(got), sortIntervals(model.get(start, limit)))
+		}
+	}
+}
+
+func TestIndexErrors(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewIndexWriter(&buf, 0)
+	assert.NoError(t, w.Append(Interval{10, 20}, nil))
+	expect.HasSubstr(t, w.Append(Interval{5, 20}, nil), "out of order")
+	expect.HasSubstr(t, w.Close(), "out of order")
+
+	buf.Reset()
+	w = NewIndexWriter(&buf, 0)
+	expect.HasSubstr(t, w.Append(Interval{5, 5}, nil), "empty interval")
+
+	buf.Reset()
+	w = NewIndexWriter(&buf, 0)
+	assert.NoError(t, w.Append(Interval{1, 2}, []byte("x")))
+	assert.NoError(t, w.Close())
+	p := buf.Bytes()
+	p[3] ^= 0xff // corrupt the payload
+	x, err := OpenIndex(bytes.NewReader(p), int64(len(p)))
+	assert.NoError(t, err)
+	expect.HasSubstr(t, x.Get(Interval{0, 10}, func(Interval, []byte) bool { return true }), "checksum mismatch")
+
+	_, err = OpenIndex(bytes.NewReader(p[:len(p)-1]), int64(len(p)-1))
+	expect.HasSubstr(t, err, "magic")
+}
+
+// eofReaderAt returns io.EOF along with reads that reach the end of its
+// data, as io.ReaderAt implementations may.
+type eofReaderAt []byte
+
+func (r eofReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := bytes.NewReader(r).ReadAt(p, off)
+	if err == nil && off+int64(n) == int64(len(r)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func TestIndexReaderAtEOF(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewIndexWriter(&buf, 0)
+	assert.NoError(t, w.Append(Interval{1, 2}, []byte("x")))
+	assert.NoError(t, w.Close())
+	x, err := OpenIndex(eofReaderAt(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	var got []string
+	assert.NoError(t, x.Get(Interval{0, 10}, func(_ Interval, data []byte) bool {
+		got = append(got, string(data))
+		return true
+	}))
+	expect.EQ(t, got, []string{"x"})
+}
@@ -4,6 +4,11 @@
 // The implementation uses an 1-D version of Kd tree with randomized
 // surface-area heuristic
 // (http://www.sci.utah.edu/~wald/Publications/2007/ParallelBVHBuild/fastbuild.pdf).
+//
+// T is immutable once built. Map is a generic alternative that supports
+// incremental inserts and deletes, as well as stabbing and nearest-neighbor
+// queries. IndexWriter and Index implement an on-disk index, queried through
+// an io.ReaderAt, for interval sets too large to hold in memory.
 package intervalmap
 
 //go:generate ../gtl/generate_randomized_freepool.py --output=search_freepool --prefix=searcher --PREFIX=searcher -DELEM=*searcher --package=intervalmap
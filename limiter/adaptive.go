@@ -0,0 +1,229 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/sync/ctxsync"
+)
+
+// AdaptiveOpts configures an Adaptive limiter.
+type AdaptiveOpts struct {
+	// Rate is the initial rate, in calls per second. It must be positive.
+	Rate float64
+	// MinRate and MaxRate bound the rate as it is adjusted. They default to
+	// Rate/100 and Rate, respectively.
+	MinRate, MaxRate float64
+	// Burst is the maximum number of calls that may be made at once after
+	// a period of inactivity. It defaults to 1.
+	Burst int
+	// MaxConcurrency, if > 0, is the maximum number of concurrent calls.
+	MaxConcurrency int
+	// Increase is the amount by which the rate is increased after each
+	// call that is not throttled. It defaults to MaxRate/100.
+	Increase float64
+	// DecFactor is the factor by which the rate is reduced after a call is
+	// throttled. It defaults to 0.5.
+	DecFactor float64
+	// IsThrottled reports whether err indicates that a call was throttled.
+	// It defaults to checking for errors of kind errors.ResourcesExhausted.
+	IsThrottled func(err error) bool
+}
+
+// Adaptive limits calls by a token-bucket rate and, optionally, by
+// concurrency. The rate is adjusted from the calls' outcomes by the additive
+// increase/multiplicative decrease algorithm: it is increased additively
+// after each call that is not throttled, and reduced multiplicatively after a
+// call is throttled. Adaptive is safe for concurrent use.
+type Adaptive struct {
+	opts   AdaptiveOpts
+	bucket bucket
+	// sem limits concurrency. It is nil if concurrency is unlimited.
+	sem *ctxsync.Semaphore
+
+	mu sync.Mutex
+	// lastDecrease is the time of the last rate reduction. Throttling of
+	// calls that started before it does not reduce the rate again, since
+	// they were made at the rate that was reduced.
+	lastDecrease time.Time
+}
+
+// NewAdaptive returns a new Adaptive limiter configured by opts.
+func NewAdaptive(opts AdaptiveOpts) *Adaptive {
+	if opts.Rate <= 0 {
+		panic("limiter: adaptive rate must be positive")
+	}
+	if opts.MinRate <= 0 {
+		opts.MinRate = opts.Rate / 100
+	}
+	if opts.MaxRate <= 0 {
+		opts.MaxRate = opts.Rate
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = 1
+	}
+	if opts.Increase <= 0 {
+		opts.Increase = opts.MaxRate / 100
+	}
+	if opts.DecFactor <= 0 {
+		opts.DecFactor = 0.5
+	}
+	if opts.IsThrottled == nil {
+		opts.IsThrottled = func(err error) bool { return errors.Is(errors.ResourcesExhausted, err) }
+	}
+	a := &Adaptive{opts: opts, bucket: bucket{rate: opts.Rate, burst: float64(opts.Burst), tokens: float64(opts.Burst)}}
+	if opts.MaxConcurrency > 0 {
+		a.sem = ctxsync.NewSemaphore(int64(opts.MaxConcurrency))
+	}
+	return a
+}
+
+// Do calls fn once it is admitted by the limiter, and adjusts the rate from
+// the error that fn returns, which Do returns. If ctx is done before fn is
+// admitted, Do returns ctx's error without calling fn.
+func (a *Adaptive) Do(ctx context.Context, fn func(context.Context) error) error {
+	if err := a.acquire(ctx); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := fn(ctx)
+	a.release(start, a.opts.IsThrottled(err))
+	return err
+}
+
+// Rate returns the current rate, in calls per second.
+func (a *Adaptive) Rate() float64 {
+	a.bucket.mu.Lock()
+	defer a.bucket.mu.Unlock()
+	return a.bucket.rate
+}
+
+// acquire waits for a concurrency slot and a rate token.
+func (a *Adaptive) acquire(ctx context.Context) error {
+	if a.sem != nil {
+		if err := a.sem.Acquire(ctx, 1); err != nil {
+			return err
+		}
+	}
+	var t *time.Timer
+	defer func() {
+		if t != nil {
+			t.Stop()
+		}
+	}()
+	for {
+		d := a.bucket.take(time.Now())
+		if d == 0 {
+			return nil
+		}
+		if t == nil {
+			t = time.NewTimer(d)
+		} else {
+			t.Reset(d)
+		}
+		select {
+		case <-ctx.Done():
+			a.releaseSlot()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// tryAcquire acquires a concurrency slot and a rate token if both are
+// immediately available.
+func (a *Adaptive) tryAcquire() bool {
+	if a.sem != nil && !a.sem.TryAcquire(1) {
+		return false
+	}
+	if a.bucket.take(time.Now()) != 0 {
+		a.releaseSlot()
+		return false
+	}
+	return true
+}
+
+// releaseSlot releases a concurrency slot without adjusting the rate, for
+// when a call was admitted but not made.
+func (a *Adaptive) releaseSlot() {
+	if a.sem != nil {
+		a.sem.Release(1)
+	}
+}
+
+// release releases the concurrency slot of a call that started at start,
+// and adjusts the rate according to whether the call was throttled.
+func (a *Adaptive) release(start time.Time, throttled bool) {
+	a.releaseSlot()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := a.Rate()
+	switch {
+	case !throttled:
+		r += a.opts.Increase
+		if r > a.opts.MaxRate {
+			r = a.opts.MaxRate
+		}
+	case start.Before(a.lastDecrease):
+		return
+	default:
+		r *= 1 - a.opts.DecFactor
+		if r < a.opts.MinRate {
+			r = a.opts.MinRate
+		}
+		a.lastDecrease = time.Now()
+	}
+	a.bucket.setRate(r, time.Now())
+}
+
+// bucket is a token bucket whose rate may be changed. (rate.Limiter's
+// SetLimit can leave a bucket with a burst of 1 unable to fill.)
+type bucket struct {
+	mu sync.Mutex
+	// rate is the rate at which tokens are added, per second.
+	rate, burst float64
+	// tokens is the number of tokens at time last.
+	tokens float64
+	last   time.Time
+}
+
+// advanceLocked adds the tokens accrued since b.last.
+func (b *bucket) advanceLocked(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// take takes a token if one is available, returning 0. Otherwise, it returns
+// the time until one will be.
+func (b *bucket) take(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advanceLocked(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	d := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if d <= 0 {
+		d = time.Nanosecond
+	}
+	return d
+}
+
+func (b *bucket) setRate(r float64, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advanceLocked(now)
+	b.rate = r
+}
@@ -0,0 +1,163 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/traverse"
+)
+
+var errThrottled = errors.E(errors.ResourcesExhausted, "throttled")
+
+func TestAdaptiveAIMD(t *testing.T) {
+	a := NewAdaptive(AdaptiveOpts{Rate: 1000, MinRate: 100, Increase: 10})
+	ctx := context.Background()
+	if err := a.Do(ctx, func(context.Context) error { return errThrottled }); err != errThrottled {
+		t.Fatalf("got %v, want %v", err, errThrottled)
+	}
+	if got, want := a.Rate(), 500.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for i := 0; i < 3; i++ {
+		if err := a.Do(ctx, func(context.Context) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := a.Rate(), 530.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Errors other than throttling do not reduce the rate.
+	_ = a.Do(ctx, func(context.Context) error { return fmt.Errorf("failed") })
+	if got, want := a.Rate(), 540.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for i := 0; i < 10; i++ {
+		_ = a.Do(ctx, func(context.Context) error { return errThrottled })
+	}
+	if got, want := a.Rate(), 100.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAdaptiveConcurrentThrottle(t *testing.T) {
+	a := NewAdaptive(AdaptiveOpts{Rate: 1000, Burst: 10})
+	var (
+		started sync.WaitGroup
+		release = make(chan struct{})
+	)
+	started.Add(10)
+	go func() {
+		started.Wait()
+		close(release)
+	}()
+	// Calls that are throttled concurrently reduce the rate only once.
+	_ = traverse.Each(10, func(int) error {
+		return a.Do(context.Background(), func(context.Context) error {
+			started.Done()
+			<-release
+			return errThrottled
+		})
+	})
+	if got, want := a.Rate(), 500.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	a := NewAdaptive(AdaptiveOpts{Rate: 1e6, Burst: 100, MaxConcurrency: 2})
+	var running, maxRunning int32
+	err := traverse.Each(20, func(int) error {
+		return a.Do(context.Background(), func(context.Context) error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxRunning > 2 {
+		t.Errorf("got %d concurrent calls, want <= 2", maxRunning)
+	}
+}
+
+func TestAdaptiveCtxCanceled(t *testing.T) {
+	a := NewAdaptive(AdaptiveOpts{Rate: 1, MaxConcurrency: 1})
+	ctx := context.Background()
+	if err := a.Do(ctx, func(context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	called := false
+	if err := a.Do(ctx, func(context.Context) error { called = true; return nil }); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if called {
+		t.Error("fn was called")
+	}
+}
+
+type throttlingBatchApi struct {
+	mu       sync.Mutex
+	batches  int
+	throttle bool
+}
+
+func (a *throttlingBatchApi) MaxPerBatch() int { return 0 }
+
+func (a *throttlingBatchApi) Do(results map[string]*TypedResult[string]) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.batches++
+	for id, r := range results {
+		if a.throttle {
+			r.Set("", errThrottled)
+		} else {
+			r.Set(id, nil)
+		}
+	}
+	return nil
+}
+
+func TestAdaptiveBatchLimiter(t *testing.T) {
+	a := NewAdaptive(AdaptiveOpts{Rate: 1000, Increase: 1})
+	api := &throttlingBatchApi{throttle: true}
+	l := NewTypedAdaptiveBatchLimiter[string, string](api, a)
+	ctx := context.Background()
+	if _, err := l.Do(ctx, "a"); err != errThrottled {
+		t.Fatalf("got %v, want %v", err, errThrottled)
+	}
+	if got, want := a.Rate(), 500.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	api.mu.Lock()
+	api.throttle = false
+	api.mu.Unlock()
+	v, err := l.Do(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "b" {
+		t.Errorf("got %v, want b", v)
+	}
+	if got, want := a.Rate(), 501.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
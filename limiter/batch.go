@@ -14,21 +14,31 @@ import (
 	"golang.org/x/time/rate"
 )
 
-// BatchLimiter provides the ability to batch calls and apply a rate limit (on the batches).
-// Users have to provide an implementation of BatchApi and a rate.Limiter.
-// Thereafter callers can concurrently Do calls for each individual ID and the BatchLimiter will
+// BatchLimiter is a TypedBatchLimiter whose IDs and values are of any type.
+type BatchLimiter = TypedBatchLimiter[ID, interface{}]
+
+// TypedBatchLimiter provides the ability to batch calls and apply a rate limit (on the batches).
+// Users have to provide an implementation of TypedBatchApi and a rate.Limiter, or
+// an Adaptive limiter, which adapts the rate to throttling of the batch calls.
+// Thereafter callers can concurrently Do calls for each individual ID of type K and the TypedBatchLimiter will
 // batch calls (whenever appropriate) while respecting the rate limit.
 // Individual requests are serviced in the order of submission.
-type BatchLimiter struct {
-	api     BatchApi
-	limiter *rate.Limiter
-	wait    time.Duration
+type TypedBatchLimiter[K comparable, V any] struct {
+	api TypedBatchApi[K, V]
+	// Batch calls are limited by either limiter or adaptive.
+	limiter  *rate.Limiter
+	adaptive *Adaptive
+	opts     batchOpts
 
 	mu sync.Mutex
-	// pending is the list of pending ids in the order of submission
-	pending []ID
+	// pending is the list of pending ids in the order of submission, and
+	// pendingAt their times of submission.
+	pending   []K
+	pendingAt []time.Time
 	// results maps each submitted ID to its result.
-	results map[ID]*Result
+	results map[K]*TypedResult[V]
+	// inFlight is the number of batch calls in progress.
+	inFlight int
 }
 
 // BatchApi needs to be implemented in order to use BatchLimiter.
@@ -46,19 +56,43 @@ type BatchApi interface {
 // ID is the identifier of each call.
 type ID interface{}
 
-// Result is the result of an API call for a given id.
-type Result struct {
+// Result is a TypedResult of any type.
+type Result = TypedResult[interface{}]
+
+// batchApi adapts a BatchApi to a TypedBatchApi.
+type batchApi struct{ BatchApi }
+
+func (a batchApi) Do(results map[ID]*Result) error {
+	a.BatchApi.Do(results)
+	return nil
+}
+
+// TypedBatchApi needs to be implemented in order to use TypedBatchLimiter.
+type TypedBatchApi[K comparable, V any] interface {
+	// MaxPerBatch is the max number of ids to call per `Do` (zero implies no limit).
+	MaxPerBatch() int
+
+	// Do the batch call with the given map of IDs to Results.
+	// The implementation must call Result.Set to provide the Value or Err (as applicable) for the every ID.
+	// If the batch call as a whole fails, Do should return the error, which is then the result of
+	// every ID whose Result.Set was not called.
+	// Otherwise, at the end of this call, if Result.Set was not called on the result of a particular ID,
+	// the corresponding ID's `Do` call will get ErrNoResult.
+	Do(map[K]*TypedResult[V]) error
+}
+
+// TypedResult is the result of an API call for a given id.
+type TypedResult[V any] struct {
 	mu       sync.Mutex
 	cond     *ctxsync.Cond
-	id       ID
-	value    interface{}
+	value    V
 	err      error
 	done     bool
 	nWaiters int
 }
 
 // Set sets the result of a given id with the given value v and error err.
-func (r *Result) Set(v interface{}, err error) {
+func (r *TypedResult[V]) Set(v V, err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.done = true
@@ -67,21 +101,71 @@ func (r *Result) Set(v interface{}, err error) {
 	r.cond.Broadcast()
 }
 
-func (r *Result) doneC() <-chan struct{} {
+func (r *TypedResult[V]) doneC() <-chan struct{} {
 	r.mu.Lock()
 	return r.cond.Done()
 }
 
+// BatchOption is an option for NewBatchLimiter, NewAdaptiveBatchLimiter and
+// their typed counterparts.
+type BatchOption func(*batchOpts)
+
+type batchOpts struct {
+	maxWait     time.Duration
+	maxInFlight int
+}
+
+// MaxBatchWait makes the batch limiter wait up to d after an ID is submitted
+// for more IDs to fill its batch, instead of calling the batch API as soon
+// as the rate limit allows. A full batch is called without waiting.
+func MaxBatchWait(d time.Duration) BatchOption {
+	return func(o *batchOpts) { o.maxWait = d }
+}
+
+// MaxInFlight limits the number of concurrent batch calls to n. By
+// default, batch calls are limited only by the rate limit.
+func MaxInFlight(n int) BatchOption {
+	return func(o *batchOpts) { o.maxInFlight = n }
+}
+
 // NewBatchLimiter returns a new BatchLimiter which will call the given batch API
 // as per the limits set by the given rate limiter.
-func NewBatchLimiter(api BatchApi, limiter *rate.Limiter) *BatchLimiter {
-	eventsPerSecond := limiter.Limit()
-	if eventsPerSecond == 0 {
+func NewBatchLimiter(api BatchApi, limiter *rate.Limiter, opts ...BatchOption) *BatchLimiter {
+	return NewTypedBatchLimiter[ID, interface{}](batchApi{api}, limiter, opts...)
+}
+
+// NewTypedBatchLimiter returns a new TypedBatchLimiter which will call the given batch API
+// as per the limits set by the given rate limiter.
+func NewTypedBatchLimiter[K comparable, V any](api TypedBatchApi[K, V], limiter *rate.Limiter, opts ...BatchOption) *TypedBatchLimiter[K, V] {
+	if limiter.Limit() == 0 {
 		panic("limiter does not allow any events")
 	}
-	d := float64(time.Second) / float64(eventsPerSecond)
-	wait := time.Duration(d)
-	return &BatchLimiter{api: api, limiter: limiter, wait: wait, results: make(map[ID]*Result)}
+	l := &TypedBatchLimiter[K, V]{api: api, limiter: limiter, results: make(map[K]*TypedResult[V])}
+	for _, opt := range opts {
+		opt(&l.opts)
+	}
+	return l
+}
+
+// NewAdaptiveBatchLimiter returns a new BatchLimiter which will call the given
+// batch API as per the limits set by the given adaptive limiter. A batch call
+// is considered throttled, and the limiter's rate reduced, if the result of
+// any of its IDs is an error that the limiter classifies as throttling.
+func NewAdaptiveBatchLimiter(api BatchApi, limiter *Adaptive, opts ...BatchOption) *BatchLimiter {
+	return NewTypedAdaptiveBatchLimiter[ID, interface{}](batchApi{api}, limiter, opts...)
+}
+
+// NewTypedAdaptiveBatchLimiter returns a new TypedBatchLimiter which will call
+// the given batch API as per the limits set by the given adaptive limiter. A
+// batch call is considered throttled, and the limiter's rate reduced, if it
+// returns an error, or the result of any of its IDs is an error, that the
+// limiter classifies as throttling.
+func NewTypedAdaptiveBatchLimiter[K comparable, V any](api TypedBatchApi[K, V], limiter *Adaptive, opts ...BatchOption) *TypedBatchLimiter[K, V] {
+	l := &TypedBatchLimiter[K, V]{api: api, adaptive: limiter, results: make(map[K]*TypedResult[V])}
+	for _, opt := range opts {
+		opt(&l.opts)
+	}
+	return l
 }
 
 var ErrNoResult = fmt.Errorf("no result")
@@ -89,10 +173,10 @@ var ErrNoResult = fmt.Errorf("no result")
 // Do submits the given ID to the batch limiter and returns the result or an error.
 // If the returned error is ErrNoResult, it indicates that the batch call did not produce any result for the given ID.
 // Callers may then apply their own retry strategy if necessary.
-// Do merges duplicate calls if the IDs are of a comparable type (and if the result is still pending)
+// Do merges duplicate calls (if the result is still pending)
 // However, de-duplication is not guaranteed.
 // Callers can avoid de-duplication by using a pointer type instead.
-func (l *BatchLimiter) Do(ctx context.Context, id ID) (interface{}, error) {
+func (l *TypedBatchLimiter[K, V]) Do(ctx context.Context, id K) (V, error) {
 	var t *time.Timer
 	defer func() {
 		if t != nil {
@@ -100,21 +184,29 @@ func (l *BatchLimiter) Do(ctx context.Context, id ID) (interface{}, error) {
 		}
 	}()
 	r := l.register(id)
-	defer l.unregister(r)
+	defer l.unregister(id, r)
 	for {
 		if done, v, err := l.get(r); done {
 			return v, err
 		}
-		if l.limiter.Allow() {
+		ready, d := l.ready()
+		if ready && l.allow() {
+			start := time.Now()
 			m := l.claim()
+			var err error
+			if len(m) > 0 {
+				err = l.api.Do(m)
+				l.update(m, err)
+			}
+			l.done(start, m, err)
 			if len(m) > 0 {
-				l.api.Do(m)
-				l.update(m)
 				continue
 			}
 		}
-		// Wait half the interval to increase chances of making the next call as early as possible.
-		d := l.wait / 2
+		if d <= 0 {
+			// Wait half the interval to increase chances of making the next call as early as possible.
+			d = l.interval() / 2
+		}
 		if t == nil {
 			t = time.NewTimer(d)
 		} else {
@@ -122,20 +214,85 @@ func (l *BatchLimiter) Do(ctx context.Context, id ID) (interface{}, error) {
 		}
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			var zero V
+			return zero, ctx.Err()
 		case <-r.doneC():
 		case <-t.C:
 		}
 	}
 }
 
+// ready returns whether a batch call should be made now, subject to the rate
+// limit. If a call should not be made because the batch is waiting to be
+// filled, ready also returns the time until it should be made.
+func (l *TypedBatchLimiter[K, V]) ready() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.opts.maxInFlight > 0 && l.inFlight >= l.opts.maxInFlight {
+		return false, 0
+	}
+	if l.opts.maxWait <= 0 || len(l.pending) == 0 {
+		return true, 0
+	}
+	if max := l.api.MaxPerBatch(); max > 0 && len(l.pending) >= max {
+		return true, 0
+	}
+	wait := l.opts.maxWait - time.Since(l.pendingAt[0])
+	return wait <= 0, wait
+}
+
+// interval returns the current interval between batch calls.
+func (l *TypedBatchLimiter[K, V]) interval() time.Duration {
+	var eventsPerSecond float64
+	if l.adaptive != nil {
+		eventsPerSecond = l.adaptive.Rate()
+	} else {
+		eventsPerSecond = float64(l.limiter.Limit())
+	}
+	return time.Duration(float64(time.Second) / eventsPerSecond)
+}
+
+// allow reports whether a batch call may be made now. If it returns true,
+// done must be called after the call.
+func (l *TypedBatchLimiter[K, V]) allow() bool {
+	if l.adaptive != nil {
+		return l.adaptive.tryAcquire()
+	}
+	return l.limiter.Allow()
+}
+
+// done is called after a batch call, which started at start, with the call's
+// results and error. No call was made if results is empty.
+func (l *TypedBatchLimiter[K, V]) done(start time.Time, results map[K]*TypedResult[V], err error) {
+	if len(results) > 0 {
+		l.mu.Lock()
+		l.inFlight--
+		l.mu.Unlock()
+	}
+	if l.adaptive == nil {
+		return
+	}
+	if len(results) == 0 {
+		l.adaptive.releaseSlot()
+		return
+	}
+	throttled := l.adaptive.opts.IsThrottled(err)
+	for _, r := range results {
+		r.mu.Lock()
+		throttled = throttled || l.adaptive.opts.IsThrottled(r.err)
+		r.mu.Unlock()
+	}
+	l.adaptive.release(start, throttled)
+}
+
 // register registers the given id.
-func (l *BatchLimiter) register(id ID) *Result {
+func (l *TypedBatchLimiter[K, V]) register(id K) *TypedResult[V] {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if _, ok := l.results[id]; !ok {
 		l.pending = append(l.pending, id)
-		r := &Result{id: id}
+		l.pendingAt = append(l.pendingAt, time.Now())
+		r := &TypedResult[V]{}
 		r.cond = ctxsync.NewCond(&r.mu)
 		l.results[id] = r
 	}
@@ -147,7 +304,7 @@ func (l *BatchLimiter) register(id ID) *Result {
 }
 
 // unregister indicates that the calling goroutine is no longer interested in the given result.
-func (l *BatchLimiter) unregister(r *Result) {
+func (l *TypedBatchLimiter[K, V]) unregister(id K, r *TypedResult[V]) {
 	var remove bool
 	r.mu.Lock()
 	r.nWaiters -= 1
@@ -155,39 +312,50 @@ func (l *BatchLimiter) unregister(r *Result) {
 	r.mu.Unlock()
 	if remove {
 		l.mu.Lock()
-		delete(l.results, r.id)
+		if l.results[id] == r {
+			delete(l.results, id)
+		}
 		l.mu.Unlock()
 	}
 }
 
 // get returns whether the result is done and the value and error.
-func (l *BatchLimiter) get(r *Result) (bool, interface{}, error) {
+func (l *TypedBatchLimiter[K, V]) get(r *TypedResult[V]) (bool, V, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	return r.done, r.value, r.err
 }
 
-// update updates the internal results using the given ones.
-// update also sets ErrNoResult as the error result for IDs for which `Result.Set` was not called.
-func (l *BatchLimiter) update(results map[ID]*Result) {
+// update updates the internal results using the given ones and the batch
+// call's error, err.
+// update sets err, or ErrNoResult if err is nil, as the error result for IDs for which `Result.Set` was not called.
+func (l *TypedBatchLimiter[K, V]) update(results map[K]*TypedResult[V], err error) {
+	if err == nil {
+		err = ErrNoResult
+	}
 	for _, r := range results {
 		r.mu.Lock()
 		if !r.done {
-			r.done, r.err = true, ErrNoResult
+			r.done, r.err = true, err
+			r.cond.Broadcast()
 		}
 		r.mu.Unlock()
 	}
 }
 
 // claim claims pending ids and returns a mapping of those ids to their results.
-func (l *BatchLimiter) claim() map[ID]*Result {
+// If any are claimed, it counts the batch call as in flight.
+func (l *TypedBatchLimiter[K, V]) claim() map[K]*TypedResult[V] {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.opts.maxInFlight > 0 && l.inFlight >= l.opts.maxInFlight {
+		return nil
+	}
 	max := l.api.MaxPerBatch()
 	if max == 0 {
 		max = len(l.pending)
 	}
-	claimed := make(map[ID]*Result)
+	claimed := make(map[K]*TypedResult[V])
 	i := 0
 	for ; i < len(l.pending) && len(claimed) < max; i++ {
 		id := l.pending[i]
@@ -203,5 +371,9 @@ func (l *BatchLimiter) claim() map[ID]*Result {
 	}
 	// Remove the claimed ids from the pending list.
 	l.pending = l.pending[i:]
+	l.pendingAt = l.pendingAt[i:]
+	if len(claimed) > 0 {
+		l.inFlight++
+	}
 	return claimed
 }
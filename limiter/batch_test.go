@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -232,3 +233,166 @@ func testApi(t *testing.T, a *testBatchApi, timeout time.Duration) {
 		t.Logf("batch %d (after %s): %v", i, a.durs[i].Round(time.Millisecond), batchIds)
 	}
 }
+
+// funcBatchApi implements TypedBatchApi with a function.
+type funcBatchApi struct {
+	maxPerBatch int
+	do          func(map[int]*TypedResult[int]) error
+}
+
+func (a funcBatchApi) MaxPerBatch() int                           { return a.maxPerBatch }
+func (a funcBatchApi) Do(results map[int]*TypedResult[int]) error { return a.do(results) }
+
+func TestTypedDo(t *testing.T) {
+	a := funcBatchApi{do: func(results map[int]*TypedResult[int]) error {
+		for id, r := range results {
+			if id > 0 {
+				r.Set(2*id, nil)
+			}
+		}
+		return nil
+	}}
+	l := NewTypedBatchLimiter[int, int](a, rate.NewLimiter(rate.Every(time.Millisecond), 1))
+	ctx := context.Background()
+	err := traverse.Each(10, func(i int) error {
+		v, err := l.Do(ctx, i)
+		switch {
+		case i == 0 && err != ErrNoResult:
+			return fmt.Errorf("got %v, want %v", err, ErrNoResult)
+		case i > 0 && (err != nil || v != 2*i):
+			return fmt.Errorf("got %v, %v, want %v", v, err, 2*i)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTypedNoDedup(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen int
+	)
+	a := funcBatchApi{do: func(results map[int]*TypedResult[int]) error {
+		mu.Lock()
+		seen += len(results)
+		mu.Unlock()
+		for id, r := range results {
+			r.Set(id, nil)
+		}
+		return nil
+	}}
+	l := NewTypedBatchLimiter[int, int](a, rate.NewLimiter(rate.Inf, 1))
+	for i := 0; i < 3; i++ {
+		if _, err := l.Do(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Calls for an ID whose result is no longer pending are not merged.
+	if got, want := seen, 3; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	errBatch := fmt.Errorf("batch failed")
+	a := funcBatchApi{do: func(results map[int]*TypedResult[int]) error {
+		if r, ok := results[1]; ok {
+			r.Set(1, nil)
+		}
+		return errBatch
+	}}
+	l := NewTypedBatchLimiter[int, int](a, rate.NewLimiter(rate.Inf, 1), MaxBatchWait(10*time.Millisecond))
+	got := make([]error, 2)
+	_ = traverse.Each(2, func(i int) error {
+		_, got[i] = l.Do(context.Background(), i)
+		return nil
+	})
+	// The batch error is the result of the IDs without a result.
+	if got[0] != errBatch {
+		t.Errorf("got %v, want %v", got[0], errBatch)
+	}
+	if got[1] != nil {
+		t.Errorf("got %v, want nil", got[1])
+	}
+}
+
+func TestMaxBatchWait(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]int
+	)
+	a := funcBatchApi{maxPerBatch: 3, do: func(results map[int]*TypedResult[int]) error {
+		var batch []int
+		for id, r := range results {
+			batch = append(batch, id)
+			r.Set(id, nil)
+		}
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+		return nil
+	}}
+	const wait = 100 * time.Millisecond
+	l := NewTypedBatchLimiter[int, int](a, rate.NewLimiter(rate.Inf, 1), MaxBatchWait(wait))
+	start := time.Now()
+	// A full batch is called without waiting.
+	_ = traverse.Each(3, func(i int) error {
+		_, err := l.Do(context.Background(), i)
+		return err
+	})
+	if elapsed := time.Since(start); elapsed >= wait {
+		t.Errorf("full batch took %v", elapsed)
+	}
+	// A partial batch is called after waiting.
+	start = time.Now()
+	_ = traverse.Each(2, func(i int) error {
+		_, err := l.Do(context.Background(), 10+i)
+		return err
+	})
+	if elapsed := time.Since(start); elapsed < wait {
+		t.Errorf("partial batch took %v, want >= %v", elapsed, wait)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := len(batches), 2; got != want {
+		t.Fatalf("got %v batches, want %v: %v", got, want, batches)
+	}
+	if got, want := len(batches[1]), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	var inFlight, maxInFlight int32
+	a := funcBatchApi{maxPerBatch: 1, do: func(results map[int]*TypedResult[int]) error {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		for id, r := range results {
+			r.Set(id, nil)
+		}
+		atomic.AddInt32(&inFlight, -1)
+		return nil
+	}}
+	l := NewTypedBatchLimiter[int, int](a, rate.NewLimiter(rate.Every(time.Millisecond), 10), MaxInFlight(2))
+	err := traverse.Each(20, func(i int) error {
+		v, err := l.Do(context.Background(), i)
+		if err == nil && v != i {
+			err = fmt.Errorf("got %v, want %v", v, i)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&maxInFlight); got != 2 {
+		t.Errorf("got %v batches in flight, want 2", got)
+	}
+}
@@ -2,8 +2,8 @@
 // Use of this source code is governed by the Apache-2.0
 // license that can be found in the LICENSE file.
 
-// Package limiter implements a concurrency limiter with support for
-// contexts.
+// Package limiter implements concurrency and rate limiters with support
+// for contexts.
 package limiter
 
 import "context"
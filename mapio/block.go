@@ -11,6 +11,8 @@ import (
 	"fmt"
 	"hash/crc32"
 	"sort"
+
+	"github.com/grailbio/base/compress/zstd"
 )
 
 const (
@@ -25,6 +27,17 @@ const (
 
 var order = binary.LittleEndian
 
+// Compression is a block compression algorithm. It is stored in the
+// type byte of each block's trailer.
+type Compression uint8
+
+const (
+	// NoCompression stores blocks uncompressed.
+	NoCompression Compression = 0
+	// ZstdCompression compresses blocks with zstd.
+	ZstdCompression Compression = 1
+)
+
 // A blockBuffer is a writable block buffer.
 type blockBuffer struct {
 	bytes.Buffer
@@ -34,6 +47,9 @@ type blockBuffer struct {
 	restartInterval int
 	restarts        []int
 	restartCount    int
+
+	compression Compression
+	scratch     []byte
 }
 
 // Append appends the provided entry to the block. Must be called
@@ -97,7 +113,19 @@ func (b *blockBuffer) Finish() {
 		order.PutUint32(p, 0)
 	}
 	b.Write(p)
-	b.WriteByte(0) // zero type. reserved.
+	btype := NoCompression
+	if b.compression == ZstdCompression {
+		// Store the block compressed only if it saves at least 1/8 of
+		// its size.
+		compressed, err := zstd.CompressLevel(b.scratch, b.Bytes(), -1)
+		if err == nil && len(compressed) < b.Buffer.Len()-b.Buffer.Len()/8 {
+			btype = ZstdCompression
+			b.Buffer.Reset()
+			b.Write(compressed)
+		}
+		b.scratch = compressed
+	}
+	b.WriteByte(byte(btype))
 	order.PutUint32(p, crc32.ChecksumIEEE(b.Bytes()))
 	b.Write(p)
 }
@@ -131,15 +159,29 @@ func (b *block) init() error {
 	if got, want := crc32.ChecksumIEEE(b.p[:len(b.p)-4]), order.Uint32(b.p[len(b.p)-4:]); got != want {
 		return fmt.Errorf("invalid checksum: expected %x, got %v", want, got)
 	}
-	off := len(b.p) - blockMinTrailerSize
+	// Strip the type and checksum; then decompress the remainder, if
+	// needed.
+	switch btype := Compression(b.p[len(b.p)-5]); btype {
+	case NoCompression:
+		b.p = b.p[:len(b.p)-5]
+	case ZstdCompression:
+		p, err := zstd.Decompress(nil, b.p[:len(b.p)-5])
+		if err != nil {
+			return fmt.Errorf("corrupt compressed block: %v", err)
+		}
+		b.p = p
+	default:
+		return fmt.Errorf("invalid block type %d", btype)
+	}
+	if len(b.p) < 4 {
+		return errors.New("corrupt block")
+	}
+	off := len(b.p) - 4
 	b.nrestart = int(order.Uint32(b.p[off:]))
 	if b.nrestart*4 > off {
 		return errors.New("corrupt block")
 	}
 	b.restarts = b.p[off-4*b.nrestart : off]
-	if btype := b.p[off+4]; btype != 0 {
-		return fmt.Errorf("invalid block type %d", btype)
-	}
 	b.p = b.p[:off-4*b.nrestart]
 	b.key = nil
 	b.value = nil
@@ -0,0 +1,97 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package mapio
+
+import (
+	"container/list"
+	"sync"
+)
+
+// A BlockCache is a size-bounded, least-recently-used cache of
+// decoded (verified and decompressed) data blocks. A single
+// BlockCache may be shared by many maps; see Cache. BlockCache is
+// safe for concurrent use.
+type BlockCache struct {
+	mu      sync.Mutex
+	maxSize int
+	size    int
+	lru     list.List // of *cacheEntry; most recently used first.
+	entries map[cacheKey]*list.Element
+	nextID  uint64
+}
+
+type cacheKey struct {
+	mapID uint64
+	off   uint64
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	block block
+	size  int
+}
+
+// NewBlockCache returns a new BlockCache that holds up to maxSize
+// bytes of decoded blocks.
+func NewBlockCache(maxSize int) *BlockCache {
+	return &BlockCache{
+		maxSize: maxSize,
+		entries: make(map[cacheKey]*list.Element),
+	}
+}
+
+// Size returns the total size, in bytes, of the blocks in the cache.
+func (c *BlockCache) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// newID returns a new identifier for a map using the cache.
+func (c *BlockCache) newID() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	return c.nextID
+}
+
+// get returns the block with the provided key, if present. The
+// returned block shares its contents with the cache; they must not
+// be modified.
+func (c *BlockCache) get(key cacheKey) (block, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return block{}, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cacheEntry).block, true
+}
+
+// put inserts the provided initialized block into the cache,
+// evicting least recently used blocks as needed. Blocks larger than
+// the cache are not inserted.
+func (c *BlockCache) put(key cacheKey, b block) {
+	size := len(b.p) + len(b.restarts)
+	if size > c.maxSize {
+		return
+	}
+	b.key, b.value, b.off, b.prevOff = nil, nil, 0, 0
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key, b, size})
+	c.size += size
+	for c.size > c.maxSize {
+		e := c.lru.Back()
+		entry := e.Value.(*cacheEntry)
+		c.lru.Remove(e)
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+	}
+}
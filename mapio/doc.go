@@ -8,7 +8,8 @@
 	others. Maps are read-only, and are produced by a Writer. Each
 	Writer expects keys to be appended in lexicographic order. Buf
 	provides a means of buffering writes to be sorted before appended to
-	a Writer.
+	a Writer; Sorter does the same for maps too large to sort in memory,
+	by spilling sorted runs to temporary files.
 
 	Mapio's on-disk layout loosely follows that of LevelDB [3]. Each Map
 	is a sequence of blocks; each block comprises a sequence of entries,
@@ -24,9 +25,14 @@
 		blockTrailer :=
 			restarts:  uint32[nrestart]  // array of key restarts
 			nrestart:  uint32            // size of restart array
-			type:      uint8             // block type (should be 0; reserved for future use)
+			type:      uint8             // block type (0: uncompressed)
 			crc32:     uint32            // IEEE crc32 of contents and trailer
 
+	If the block type is nonzero, the block is compressed: the block's
+	entries and restart array (including nrestart) are stored
+	compressed, followed by the type and crc32 (of the compressed
+	data and the type). Type 1 denotes zstd compression.
+
 	Maps prefix compress each key by storing the number of bytes shared
 	with the previous key. Maps contain a number of restart points:
 	points at which the full key is specified (and nshared = 0). The
@@ -36,9 +42,9 @@
 	A Map is a sequence of data blocks, followed by an index block,
 	followed by a trailer.
 
-		map := block(data)* block(meta)* block(index) mapTrailer
+		map := block(data)* block(meta)* block(metaindex)? block(index) mapTrailer
 		mapTrailer :=
-			meta:	blockAddr[20]  // zero-padded address of the meta block index (tbd)
+			meta:	blockAddr[20]  // zero-padded address of the meta block index, or zero
 			index:  blockAddr[20]  // zero-padded address of index
 			magic:	uint64         // magic (0xa8b2374e8558bc76)
 		blockAddr :=
@@ -51,6 +57,14 @@
 	allows the reader to binary search the index block then search the
 	found block.
 
+	The meta block index, if present, contains one entry for each meta
+	block: each entry's key is the name of the meta block; the entry's
+	value is a blockAddr containing its position. Each meta block
+	contains a single entry keyed by its name. Currently, the only meta
+	block is "filter.bloom", whose value is a bloom filter over all of
+	the map's keys (see BloomFilter). Readers ignore unknown meta
+	blocks.
+
 	[1] https://static.googleusercontent.com/media/research.google.com/en//archive/bigtable-osdi06.pdf
 	[2] https://www.cs.cornell.edu/projects/ladis2009/papers/lakshman-ladis2009.pdf
 	[3] https://github.com/google/leveldb
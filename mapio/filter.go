@@ -0,0 +1,82 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package mapio
+
+import (
+	"errors"
+	"math"
+
+	xxhash "github.com/cespare/xxhash/v2"
+)
+
+// bloomFilterName is the name of the meta block that stores the map's
+// bloom filter.
+const bloomFilterName = "filter.bloom"
+
+// A bloomFilter is a bloom filter over the keys of a map. Its encoded
+// form is:
+//
+//	bloomFilter :=
+//		bits:   uint8[nbits/8]  // the filter's bit array
+//		nprobe: uint8           // number of probes per key
+//
+// Probe positions are computed by double hashing the 64-bit xxhash
+// of the key.
+type bloomFilter []byte
+
+func bloomHash(key []byte) uint64 { return xxhash.Sum64(key) }
+
+// newBloomFilter creates a bloom filter with the given number of bits
+// per key, containing the keys with the provided hashes.
+func newBloomFilter(bitsPerKey int, hashes []uint64) bloomFilter {
+	// The number of probes that minimizes the false positive rate is
+	// bitsPerKey*ln(2).
+	nprobe := int(float64(bitsPerKey) * math.Ln2)
+	if nprobe < 1 {
+		nprobe = 1
+	} else if nprobe > 30 {
+		nprobe = 30
+	}
+	nbits := len(hashes) * bitsPerKey
+	// Small filters have a high false positive rate; enforce a minimum.
+	if nbits < 64 {
+		nbits = 64
+	}
+	nbytes := (nbits + 7) / 8
+	nbits = nbytes * 8
+	f := make(bloomFilter, nbytes+1)
+	for _, h := range hashes {
+		lo, hi := uint32(h), uint32(h>>32)
+		for i := 0; i < nprobe; i++ {
+			pos := (lo + uint32(i)*hi) % uint32(nbits)
+			f[pos/8] |= 1 << (pos % 8)
+		}
+	}
+	f[nbytes] = uint8(nprobe)
+	return f
+}
+
+// validate checks that the filter is well formed.
+func (f bloomFilter) validate() error {
+	if len(f) < 2 {
+		return errors.New("invalid bloom filter: too small")
+	}
+	return nil
+}
+
+// MayContain returns false if the filter does not contain the key
+// with the provided hash.
+func (f bloomFilter) MayContain(h uint64) bool {
+	nbits := uint32(len(f)-1) * 8
+	nprobe := int(f[len(f)-1])
+	lo, hi := uint32(h), uint32(h>>32)
+	for i := 0; i < nprobe; i++ {
+		pos := (lo + uint32(i)*hi) % nbits
+		if f[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
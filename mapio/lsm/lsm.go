@@ -0,0 +1,641 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package lsm implements a small log-structured merge (LSM) store
+// on top of mapio maps. A store is a directory (local or, e.g., on
+// S3; see package file) containing a set of immutable maps and a
+// manifest that lists the live ones.
+//
+// Each call to Store.Write writes a batch of puts and deletes as a
+// new map in level 0. Deletes are recorded as tombstones. Every map
+// carries a sequence number; entries in maps with higher sequence
+// numbers override entries with the same key in maps with lower
+// ones. Reads (Store.Get and Store.Seek) merge the live maps, newest
+// first, so that only the most recent entry for each key is visible,
+// and deleted keys are hidden.
+//
+// Compaction bounds the number of maps a read must consult: when a
+// level contains Options.LevelSize or more maps, they are merged into
+// a single map in the next level. Shadowed entries are dropped, as
+// are tombstones when no older maps remain. Compaction may be run
+// explicitly (Store.Compact), or by a background compactor (see
+// Options.AutoCompact).
+//
+// A store may be read concurrently by many goroutines, but it should
+// be written by a single process: the manifest is not locked, and
+// maps made obsolete by compaction are removed once they are no
+// longer in use by the writing process.
+package lsm
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/base/mapio"
+)
+
+// Entry kinds, stored as the first byte of each map value.
+const (
+	kindPut    = 0
+	kindDelete = 1
+)
+
+// Options configures a Store.
+type Options struct {
+	// LevelSize is the number of maps in a level that triggers its
+	// compaction into the next level. If zero, DefaultLevelSize is
+	// used.
+	LevelSize int
+	// AutoCompact, if true, starts a background compactor that
+	// compacts the store after each write.
+	AutoCompact bool
+	// WriteOptions are the options used to write the store's maps. If
+	// nil, maps are written with a bloom filter of 10 bits per key.
+	WriteOptions []mapio.WriteOption
+	// Cache, if non-nil, is the block cache used to read the store's
+	// maps.
+	Cache *mapio.BlockCache
+}
+
+// DefaultLevelSize is the default value of Options.LevelSize.
+const DefaultLevelSize = 4
+
+// A Batch is a set of puts and deletes to be written atomically to a
+// store. If a batch contains multiple operations on the same key, the
+// last one wins.
+type Batch struct {
+	keys, values [][]byte
+}
+
+// Put sets the value of the provided key.
+func (b *Batch) Put(key, value []byte) {
+	b.append(key, kindPut, value)
+}
+
+// Delete deletes the provided key.
+func (b *Batch) Delete(key []byte) {
+	b.append(key, kindDelete, nil)
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int { return len(b.keys) }
+
+func (b *Batch) append(key []byte, kind byte, value []byte) {
+	b.keys = append(b.keys, append([]byte(nil), key...))
+	b.values = append(b.values, append([]byte{kind}, value...))
+}
+
+// batchSorter sorts a batch's operations by key.
+type batchSorter struct{ *Batch }
+
+func (b batchSorter) Less(i, j int) bool { return bytes.Compare(b.keys[i], b.keys[j]) < 0 }
+
+func (b batchSorter) Swap(i, j int) {
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+	b.values[i], b.values[j] = b.values[j], b.values[i]
+}
+
+// A table is an open map in the store.
+type table struct {
+	tableMeta
+	file file.File
+	m    *mapio.Map
+	// refs is the number of versions containing the table.
+	refs int
+	// obsolete is set when the table is removed from the manifest.
+	obsolete bool
+}
+
+// A version is an immutable set of tables, ordered by increasing
+// sequence number.
+type version struct {
+	tables []*table
+	// refs is the number of users of the version, including the store
+	// itself if the version is current.
+	refs int
+}
+
+// Store is a log-structured merge store. See the package
+// documentation for details.
+type Store struct {
+	dir  string
+	opts Options
+	// ctx is used for all I/O on the store's maps.
+	ctx context.Context
+
+	// writeMu serializes writes, so that sequence numbers reflect the
+	// order in which batches are committed.
+	writeMu sync.Mutex
+	// compactMu serializes compactions.
+	compactMu sync.Mutex
+	// installMu serializes changes to the version, so that the
+	// manifest may be written without holding mu.
+	installMu sync.Mutex
+
+	mu      sync.Mutex
+	next    uint64
+	version *version
+	closed  bool
+
+	compactc chan struct{}
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Open opens the store in the provided directory, creating an empty
+// store if the directory does not contain a manifest. The provided
+// context is used for all reads of the store's maps, and must remain
+// valid until the store is closed.
+func Open(ctx context.Context, dir string, opts Options) (*Store, error) {
+	if opts.LevelSize <= 0 {
+		opts.LevelSize = DefaultLevelSize
+	}
+	if opts.WriteOptions == nil {
+		opts.WriteOptions = []mapio.WriteOption{mapio.BloomFilter(10)}
+	}
+	man, err := readManifest(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, opts: opts, ctx: ctx, next: man.Next}
+	v := &version{refs: 1}
+	for _, meta := range man.Maps {
+		t, err := s.openTable(meta)
+		if err != nil {
+			for _, t := range v.tables {
+				_ = t.file.Close(ctx)
+			}
+			return nil, err
+		}
+		t.refs = 1
+		v.tables = append(v.tables, t)
+	}
+	sortTables(v.tables)
+	s.version = v
+	if opts.AutoCompact {
+		s.compactc = make(chan struct{}, 1)
+		s.done = make(chan struct{})
+		s.wg.Add(1)
+		go s.compactor()
+	}
+	return s, nil
+}
+
+// Close stops the background compactor, if any, and closes the
+// store. Scanners must be closed before the store. Closing a closed
+// store has no effect; Close may be called concurrently.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	closed := s.closed
+	s.closed = true
+	s.mu.Unlock()
+	if closed {
+		return nil
+	}
+	if s.done != nil {
+		close(s.done)
+		s.wg.Wait()
+	}
+	s.installMu.Lock()
+	defer s.installMu.Unlock()
+	s.mu.Lock()
+	v := s.version
+	s.version = nil
+	s.mu.Unlock()
+	return s.release(v)
+}
+
+var errClosed = errors.E(errors.Precondition, "lsm: store closed")
+
+// checkOpen returns an error if the store has been closed.
+func (s *Store) checkOpen() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errClosed
+	}
+	return nil
+}
+
+// Write commits the provided batch to the store as a new level-0
+// map. Write returns an error if the store is closed.
+func (s *Store) Write(ctx context.Context, b *Batch) error {
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	sort.Stable(batchSorter{b})
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	num := s.reserve()
+	meta := tableMeta{Name: tableName(num), Level: 0, Seq: num}
+	err := s.writeTable(ctx, meta.Name, func(w *mapio.Writer) error {
+		for i := range b.keys {
+			if i+1 < len(b.keys) && bytes.Equal(b.keys[i], b.keys[i+1]) {
+				continue
+			}
+			if err := w.Append(b.keys[i], b.values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	t, err := s.openTable(meta)
+	if err != nil {
+		s.discardTable(ctx, meta.Name)
+		return err
+	}
+	if err := s.install(ctx, nil, t); err != nil {
+		return err
+	}
+	if s.compactc != nil {
+		select {
+		case s.compactc <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Get returns the current value of the provided key. Get returns
+// false if the key is not present in the store.
+func (s *Store) Get(key []byte) (value []byte, ok bool, err error) {
+	v := s.acquire()
+	defer func() {
+		if e := s.release(v); e != nil && err == nil {
+			err = e
+		}
+	}()
+	for i := len(v.tables) - 1; i >= 0; i-- {
+		value, ok, err = v.tables[i].m.Get(key)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			continue
+		}
+		if len(value) == 0 {
+			return nil, false, errors.E(errors.Integrity, "lsm: invalid entry for key", string(key))
+		}
+		if value[0] == kindDelete {
+			return nil, false, nil
+		}
+		return value[1:], true, nil
+	}
+	return nil, false, nil
+}
+
+// Seek returns a scanner over the store's current contents, beginning
+// at the first key >= the provided key. Subsequent writes to the store
+// are not visible to the scanner. The scanner must be closed after
+// use.
+func (s *Store) Seek(key []byte) *Scanner {
+	v := s.acquire()
+	merged := make(mapio.Merged, len(v.tables))
+	for i, t := range v.tables {
+		merged[i] = t.m
+	}
+	return &Scanner{store: s, version: v, merged: merged.Seek(key)}
+}
+
+// Compact compacts each level of the store that contains
+// Options.LevelSize or more maps, lowest level first. Compact
+// returns an error if the store is closed.
+func (s *Store) Compact(ctx context.Context) error {
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+	for level := 0; ; level++ {
+		v := s.acquire()
+		var (
+			inputs []*table
+			bottom = true
+		)
+		for _, t := range v.tables {
+			switch {
+			case t.Level == level:
+				inputs = append(inputs, t)
+			case t.Level > level:
+				bottom = false
+			}
+		}
+		if len(inputs) == 0 && bottom {
+			return s.release(v)
+		}
+		var err error
+		if len(inputs) >= s.opts.LevelSize {
+			err = s.compact(ctx, level, inputs, bottom)
+		}
+		if e := s.release(v); e != nil && err == nil {
+			err = e
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// compact merges the provided input tables, which comprise level
+// level, into a new table in the next level. Since maps in lower
+// levels are always newer than those in higher ones, the output map is
+// newer than any existing map in the next level. If bottom is true,
+// there are no maps older than the inputs, and tombstones are dropped.
+func (s *Store) compact(ctx context.Context, level int, inputs []*table, bottom bool) error {
+	merged := make(mapio.Merged, len(inputs))
+	for i, t := range inputs {
+		merged[i] = t.m
+	}
+	meta := tableMeta{
+		Name:  tableName(s.reserve()),
+		Level: level + 1,
+		Seq:   inputs[len(inputs)-1].Seq,
+	}
+	var n int
+	err := s.writeTable(ctx, meta.Name, func(w *mapio.Writer) error {
+		var (
+			scan = merged.Seek(nil)
+			prev []byte
+		)
+		for i := 0; scan.Scan(); i++ {
+			key, value := scan.Key(), scan.Value()
+			if i > 0 && bytes.Equal(key, prev) {
+				// Shadowed by a newer entry.
+				continue
+			}
+			prev = append(prev[:0], key...)
+			if bottom && len(value) > 0 && value[0] == kindDelete {
+				continue
+			}
+			if err := w.Append(key, value); err != nil {
+				return err
+			}
+			n++
+		}
+		return scan.Err()
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// Everything was deleted; there is no need to keep the map.
+		if err := file.Remove(ctx, file.Join(s.dir, meta.Name)); err != nil {
+			return err
+		}
+		return s.install(ctx, inputs, nil)
+	}
+	t, err := s.openTable(meta)
+	if err != nil {
+		s.discardTable(ctx, meta.Name)
+		return err
+	}
+	return s.install(ctx, inputs, t)
+}
+
+// compactor runs background compactions until the store is closed.
+func (s *Store) compactor() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.compactc:
+			if err := s.Compact(s.ctx); err != nil && err != errClosed {
+				log.Error.Printf("lsm %s: compaction failed: %v", s.dir, err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// reserve reserves a new number for a map.
+func (s *Store) reserve() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	num := s.next
+	s.next++
+	return num
+}
+
+// writeTable writes a new map with the provided name, whose contents
+// are produced by fn.
+func (s *Store) writeTable(ctx context.Context, name string, fn func(w *mapio.Writer) error) error {
+	f, err := file.Create(ctx, file.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	w := mapio.NewWriter(f.Writer(ctx), s.opts.WriteOptions...)
+	if err := fn(w); err != nil {
+		f.Discard(ctx)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		f.Discard(ctx)
+		return err
+	}
+	return f.Close(ctx)
+}
+
+// discardTable removes the map with the provided name, which was
+// written but could not be installed in the manifest. Failures are
+// logged: the map is not live, so they are not fatal, but the map is
+// then left behind in the store's directory.
+func (s *Store) discardTable(ctx context.Context, name string) {
+	path := file.Join(s.dir, name)
+	if err := file.Remove(ctx, path); err != nil {
+		log.Error.Printf("lsm %s: removing uninstalled map %s: %v", s.dir, path, err)
+	}
+}
+
+// openTable opens the map described by the provided metadata.
+func (s *Store) openTable(meta tableMeta) (*table, error) {
+	path := file.Join(s.dir, meta.Name)
+	f, err := file.Open(s.ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	var opts []mapio.OpenOption
+	if s.opts.Cache != nil {
+		opts = append(opts, mapio.Cache(s.opts.Cache))
+	}
+	m, err := mapio.New(f.Reader(s.ctx), opts...)
+	if err != nil {
+		_ = f.Close(s.ctx)
+		return nil, errors.E("lsm: open", path, err)
+	}
+	return &table{tableMeta: meta, file: f, m: m}, nil
+}
+
+// install replaces the tables in remove with the table add (if not
+// nil) in the current version, and writes the resulting manifest.
+// If the store is closed or the manifest cannot be written, the
+// current version is unchanged, and add is discarded. The manifest
+// is written under installMu, so that readers may acquire the
+// current version meanwhile; mu is held only to swap versions.
+func (s *Store) install(ctx context.Context, remove []*table, add *table) error {
+	s.installMu.Lock()
+	defer s.installMu.Unlock()
+	s.mu.Lock()
+	old, next := s.version, s.next
+	s.mu.Unlock()
+	err := errClosed
+	if old != nil {
+		err = s.writeVersion(ctx, old, next, remove, add)
+	}
+	if err != nil && add != nil {
+		_ = add.file.Close(ctx)
+		s.discardTable(ctx, add.Name)
+	}
+	return err
+}
+
+// writeVersion writes the manifest for the version derived from old
+// by replacing the tables in remove with add, and makes it the current
+// version. It must be called with installMu held.
+func (s *Store) writeVersion(ctx context.Context, old *version, next uint64, remove []*table, add *table) error {
+	removed := make(map[*table]bool)
+	for _, t := range remove {
+		removed[t] = true
+	}
+	v := &version{refs: 1}
+	for _, t := range old.tables {
+		if !removed[t] {
+			v.tables = append(v.tables, t)
+		}
+	}
+	if add != nil {
+		v.tables = append(v.tables, add)
+		sortTables(v.tables)
+	}
+	man := manifest{Next: next}
+	for _, t := range v.tables {
+		man.Maps = append(man.Maps, t.tableMeta)
+	}
+	if err := writeManifest(ctx, s.dir, man); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for _, t := range v.tables {
+		t.refs++
+	}
+	for _, t := range remove {
+		t.obsolete = true
+	}
+	s.version = v
+	s.mu.Unlock()
+	return s.release(old)
+}
+
+// acquire returns the current version, which must be released after
+// use.
+func (s *Store) acquire() *version {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version.refs++
+	return s.version
+}
+
+// release releases a reference to the provided version. Tables that
+// are no longer part of any version are closed, and removed if they
+// are obsolete.
+func (s *Store) release(v *version) error {
+	var unused []*table
+	s.mu.Lock()
+	v.refs--
+	if v.refs == 0 {
+		for _, t := range v.tables {
+			t.refs--
+			if t.refs == 0 {
+				unused = append(unused, t)
+			}
+		}
+	}
+	s.mu.Unlock()
+	var err error
+	for _, t := range unused {
+		if e := t.file.Close(s.ctx); e != nil && err == nil {
+			err = e
+		}
+		if t.obsolete {
+			if e := file.Remove(s.ctx, file.Join(s.dir, t.Name)); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+func sortTables(tables []*table) {
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Seq < tables[j].Seq })
+}
+
+// Scanner scans a snapshot of a store's contents in key order.
+type Scanner struct {
+	store   *Store
+	version *version
+	merged  *mapio.MergedScanner
+
+	started    bool
+	key, value []byte
+	err        error
+}
+
+// Scan scans the next live entry, returning true on success. When
+// Scan returns false, the caller should inspect Err to distinguish
+// between scan completion and scan error.
+func (s *Scanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	for s.merged.Scan() {
+		key, value := s.merged.Key(), s.merged.Value()
+		if s.started && bytes.Equal(key, s.key) {
+			// Shadowed by a newer entry.
+			continue
+		}
+		s.started = true
+		s.key = append(s.key[:0], key...)
+		if len(value) == 0 {
+			s.err = errors.E(errors.Integrity, "lsm: invalid entry for key", string(key))
+			return false
+		}
+		if value[0] == kindDelete {
+			continue
+		}
+		s.value = value[1:]
+		return true
+	}
+	s.err = s.merged.Err()
+	return false
+}
+
+// Key returns the key that was last scanned.
+func (s *Scanner) Key() []byte { return s.key }
+
+// Value returns the value that was last scanned. It is valid until
+// the next call to Scan, and must not be modified.
+func (s *Scanner) Value() []byte { return s.value }
+
+// Err returns the last error encountered while scanning.
+func (s *Scanner) Err() error { return s.err }
+
+// Close releases the scanner's snapshot.
+func (s *Scanner) Close() error {
+	if s.version == nil {
+		return nil
+	}
+	err := s.store.release(s.version)
+	s.version = nil
+	return err
+}
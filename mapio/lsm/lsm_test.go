@@ -0,0 +1,251 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package lsm
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/traverse"
+	"github.com/grailbio/testutil"
+)
+
+// checkStore verifies that the store's contents match want.
+func checkStore(t *testing.T, s *Store, want map[string]string) {
+	t.Helper()
+	var keys []string
+	for key := range want {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	scan := s.Seek(nil)
+	for _, key := range keys {
+		if !scan.Scan() {
+			t.Fatalf("short scan: %v", scan.Err())
+		}
+		if got := string(scan.Key()); got != key {
+			t.Fatalf("got key %q, want %q", got, key)
+		}
+		if got := string(scan.Value()); got != want[key] {
+			t.Errorf("key %s: got %q, want %q", key, got, want[key])
+		}
+	}
+	if scan.Scan() {
+		t.Errorf("unexpected key %q", scan.Key())
+	}
+	if err := scan.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := scan.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("%04d", i)
+		value, ok, err := s.Get([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantValue, wantOK := want[key]
+		if ok != wantOK || string(value) != wantValue {
+			t.Errorf("get %s: got %q, %v, want %q, %v", key, value, ok, wantValue, wantOK)
+		}
+	}
+}
+
+// mapFiles returns the names of the map files in dir.
+func mapFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*.map"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestStore(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+
+	s, err := Open(ctx, dir, Options{LevelSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		rnd  = rand.New(rand.NewSource(0))
+		want = make(map[string]string)
+	)
+	for i := 0; i < 50; i++ {
+		var b Batch
+		for j := 0; j < 20; j++ {
+			key := fmt.Sprintf("%04d", rnd.Intn(100))
+			if rnd.Intn(4) == 0 {
+				b.Delete([]byte(key))
+				delete(want, key)
+			} else {
+				value := fmt.Sprintf("%d.%d", i, j)
+				b.Put([]byte(key), []byte(value))
+				want[key] = value
+			}
+		}
+		if err := s.Write(ctx, &b); err != nil {
+			t.Fatal(err)
+		}
+		if i%7 == 0 {
+			checkStore(t, s, want)
+		}
+		if i%5 == 4 {
+			if err := s.Compact(ctx); err != nil {
+				t.Fatal(err)
+			}
+			checkStore(t, s, want)
+		}
+	}
+	// Compaction bounds the number of maps per level.
+	levels := make(map[int]int)
+	for _, tab := range s.version.tables {
+		levels[tab.Level]++
+	}
+	for level, n := range levels {
+		if n >= 3 {
+			t.Errorf("level %d: %d maps", level, n)
+		}
+	}
+	// Obsolete maps are removed.
+	if got, want := len(mapFiles(t, dir)), len(s.version.tables); got != want {
+		t.Errorf("got %d map files, want %d", got, want)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(ctx, dir, Options{LevelSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStore(t, s, want)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreSnapshot(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+
+	s, err := Open(ctx, dir, Options{LevelSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b Batch
+	b.Put([]byte("a"), []byte("1"))
+	b.Put([]byte("b"), []byte("1"))
+	if err := s.Write(ctx, &b); err != nil {
+		t.Fatal(err)
+	}
+	scan := s.Seek(nil)
+
+	b = Batch{}
+	b.Delete([]byte("a"))
+	b.Put([]byte("b"), []byte("2"))
+	b.Put([]byte("b"), []byte("3"))
+	if err := s.Write(ctx, &b); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Compact(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The tombstone for "a" is dropped, since there are no older maps.
+	if got, want := len(s.version.tables), 1; got != want {
+		t.Fatalf("got %d tables, want %d", got, want)
+	}
+	checkStore(t, s, map[string]string{"b": "3"})
+
+	// The snapshot still sees the first map, which is not removed until
+	// the scanner is closed.
+	if got, want := len(mapFiles(t, dir)), 2; got != want {
+		t.Errorf("got %d map files, want %d", got, want)
+	}
+	var got []string
+	for scan.Scan() {
+		got = append(got, string(scan.Key())+"="+string(scan.Value()))
+	}
+	if err := scan.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[a=1 b=1]" {
+		t.Errorf("got %v", got)
+	}
+	if err := scan.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(mapFiles(t, dir)), 1; got != want {
+		t.Errorf("got %d map files, want %d", got, want)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreAutoCompact(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+
+	s, err := Open(ctx, dir, Options{LevelSize: 2, AutoCompact: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		var b Batch
+		key, value := fmt.Sprintf("%04d", i), fmt.Sprint(i)
+		b.Put([]byte(key), []byte(value))
+		want[key] = value
+		if err := s.Write(ctx, &b); err != nil {
+			t.Fatal(err)
+		}
+		checkStore(t, s, want)
+	}
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		s.mu.Lock()
+		n := len(s.version.tables)
+		s.mu.Unlock()
+		if n <= 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("store not compacted: %d tables", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Concurrent and repeated calls to Close are safe.
+	if err := traverse.Each(4, func(int) error { return s.Close() }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var b Batch
+	b.Put([]byte("x"), []byte("y"))
+	if err := s.Write(ctx, &b); !errors.Is(errors.Precondition, err) {
+		t.Errorf("got %v, want precondition error", err)
+	}
+	s, err = Open(ctx, dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStore(t, s, want)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
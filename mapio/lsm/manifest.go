@@ -0,0 +1,72 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package lsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/file"
+)
+
+// manifestName is the name of the manifest file in a store's
+// directory.
+const manifestName = "MANIFEST"
+
+// A manifest lists the live maps of a store. It is stored as JSON in
+// the store's directory, and is replaced atomically (by file.Create)
+// on every change.
+type manifest struct {
+	// Next is the next number to be used for a map's file name or
+	// sequence number.
+	Next uint64 `json:"next"`
+	// Maps lists the live maps, in no particular order.
+	Maps []tableMeta `json:"maps"`
+}
+
+// tableMeta describes a single map in the store.
+type tableMeta struct {
+	// Name is the map's file name, relative to the store's directory.
+	Name string `json:"name"`
+	// Level is the map's level. Maps written by Store.Write are in
+	// level 0; compacting level L produces a map in level L+1.
+	Level int `json:"level"`
+	// Seq is the map's sequence number. Entries in maps with
+	// higher sequence numbers override those in maps with lower ones.
+	Seq uint64 `json:"seq"`
+}
+
+// tableName returns the file name of the map with the provided number.
+func tableName(num uint64) string {
+	return fmt.Sprintf("%06d.map", num)
+}
+
+// readManifest reads the manifest in the provided directory. An
+// empty manifest is returned if none exists.
+func readManifest(ctx context.Context, dir string) (manifest, error) {
+	var m manifest
+	p, err := file.ReadFile(ctx, file.Join(dir, manifestName))
+	if err != nil {
+		if errors.Is(errors.NotExist, err) {
+			return manifest{Next: 1}, nil
+		}
+		return m, err
+	}
+	if err := json.Unmarshal(p, &m); err != nil {
+		return m, errors.E(errors.Invalid, "lsm: invalid manifest", err)
+	}
+	return m, nil
+}
+
+// writeManifest replaces the manifest in the provided directory.
+func writeManifest(ctx context.Context, dir string, m manifest) error {
+	p, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return file.WriteFile(ctx, file.Join(dir, manifestName), p)
+}
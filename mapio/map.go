@@ -5,6 +5,7 @@
 package mapio
 
 import (
+	"bytes"
 	"errors"
 	"io"
 	"sync"
@@ -14,80 +15,230 @@ import (
 // on-disk layout of maps are described by the package documentation.
 // Maps support both lookup and (ordered) iteration. A Map instance
 // maintains a current position, starting out at the first entry.
+//
+// A Map is safe for concurrent use. If the underlying reader
+// implements io.ReaderAt (as do *os.File and *bytes.Reader), reads
+// are issued concurrently; otherwise they are serialized.
 type Map struct {
-	mu    sync.Mutex
-	r     io.ReadSeeker
-	index block
+	mu     sync.Mutex
+	r      io.ReadSeeker
+	ra     io.ReaderAt
+	size   int64
+	index  block
+	filter bloomFilter
+
+	cache *BlockCache
+	id    uint64
+}
+
+// OpenOption represents a tunable map parameter.
+type OpenOption func(*Map)
+
+// Cache configures the map to cache decoded data blocks in the
+// provided cache, which may be shared among many maps. By default,
+// maps do not cache blocks, and every scan reads blocks from the
+// underlying reader.
+func Cache(c *BlockCache) OpenOption {
+	return func(m *Map) {
+		m.cache = c
+	}
 }
 
 // New opens the map at the provided io.ReadSeeker (usually a file).
-func New(r io.ReadSeeker) (*Map, error) {
+func New(r io.ReadSeeker, opts ...OpenOption) (*Map, error) {
 	m := &Map{r: r}
-	return m, m.init()
+	if ra, ok := r.(io.ReaderAt); ok {
+		m.ra = ra
+	}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return m, err
+	}
+	return m.init(size, opts)
 }
 
-func (m *Map) init() error {
-	if _, err := m.r.Seek(-mapTrailerSize, io.SeekEnd); err != nil {
-		return err
+// NewReaderAt opens the map at the provided io.ReaderAt, whose
+// contents are size bytes long.
+func NewReaderAt(r io.ReaderAt, size int64, opts ...OpenOption) (*Map, error) {
+	m := &Map{ra: r}
+	return m.init(size, opts)
+}
+
+func (m *Map) init(size int64, opts []OpenOption) (*Map, error) {
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.cache != nil {
+		m.id = m.cache.newID()
+	}
+	if size < mapTrailerSize {
+		return m, errors.New("map too small")
 	}
 	trailer := make([]byte, mapTrailerSize)
-	if _, err := io.ReadFull(m.r, trailer); err != nil {
-		return err
+	if err := m.readAt(trailer, size-mapTrailerSize); err != nil {
+		return m, err
 	}
 	metaAddr, _ := getBlockAddr(trailer)
-	if metaAddr != (blockAddr{}) {
-		return errors.New("non-empty meta block index")
-	}
 	indexAddr, _ := getBlockAddr(trailer[maxBlockAddrSize:])
 	magic := order.Uint64(trailer[len(trailer)-8:])
 	if magic != mapTrailerMagic {
-		return errors.New("wrong magic")
+		return m, errors.New("wrong magic")
+	}
+	if metaAddr != (blockAddr{}) {
+		if err := m.readMeta(metaAddr); err != nil {
+			return m, err
+		}
 	}
 	if err := m.readBlock(indexAddr, &m.index); err != nil {
-		return err
+		return m, err
 	}
 	if !m.index.Scan() {
-		return errors.New("empty index")
+		return m, errors.New("empty index")
+	}
+	return m, nil
+}
+
+// readAt reads len(p) bytes at the provided offset of the map.
+func (m *Map) readAt(p []byte, off int64) error {
+	if m.ra != nil {
+		n, err := m.ra.ReadAt(p, off)
+		if n == len(p) {
+			// ReadAt may return io.EOF along with the last bytes.
+			return nil
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.r.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(m.r, p)
+	return err
+}
+
+// readMeta reads the meta block index at the provided address, and
+// then the meta blocks that it references. Unknown meta blocks are
+// ignored.
+func (m *Map) readMeta(addr blockAddr) error {
+	var index block
+	if err := m.readBlock(addr, &index); err != nil {
+		return err
+	}
+	for index.Scan() {
+		if string(index.Key()) != bloomFilterName {
+			continue
+		}
+		var meta block
+		addr, _ := getBlockAddr(index.Value())
+		if err := m.readBlock(addr, &meta); err != nil {
+			return err
+		}
+		if !meta.Scan() {
+			return errors.New("empty bloom filter block")
+		}
+		m.filter = bloomFilter(meta.Value())
+		if err := m.filter.validate(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// readBlock reads and initializes the block at the provided address.
+// The block's buffer is reused if it is large enough.
 func (m *Map) readBlock(addr blockAddr, block *block) error {
 	if block.p != nil && cap(block.p) >= int(addr.len) {
 		block.p = block.p[:addr.len]
 	} else {
 		block.p = make([]byte, addr.len)
 	}
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	if _, err := m.r.Seek(int64(addr.off), io.SeekStart); err != nil {
+	if err := m.readAt(block.p, int64(addr.off)); err != nil {
 		return err
 	}
-	if _, err := io.ReadFull(m.r, block.p); err != nil {
+	return block.init()
+}
+
+// readDataBlock reads the data block at the provided address,
+// consulting the map's cache, if any. Blocks obtained from the cache
+// share their contents with it, so their buffers are never reused.
+func (m *Map) readDataBlock(addr blockAddr, b *block) error {
+	if m.cache == nil {
+		return m.readBlock(addr, b)
+	}
+	key := cacheKey{m.id, addr.off}
+	if cached, ok := m.cache.get(key); ok {
+		*b = cached
+		return nil
+	}
+	*b = block{}
+	if err := m.readBlock(addr, b); err != nil {
 		return err
 	}
-	return block.init()
+	m.cache.put(key, *b)
+	return nil
 }
 
 // Seek returns a map scanner beginning at the first key in the map
 // >= the provided key.
 func (m *Map) Seek(key []byte) *MapScanner {
 	s := &MapScanner{parent: m, index: m.index}
+	// The scanner's index shares its contents with the map's, but it
+	// needs its own key buffer.
+	s.index.key = nil
 	s.index.Seek(key)
 	if s.index.Scan() {
 		addr, _ := getBlockAddr(s.index.Value())
-		if s.err = m.readBlock(addr, &s.data); s.err == nil {
+		if s.err = m.readDataBlock(addr, &s.data); s.err == nil {
 			s.data.Seek(key)
 		}
 	}
 	return s
 }
 
+// SeekPrefix returns a map scanner over the entries whose keys begin
+// with the provided prefix, in order.
+func (m *Map) SeekPrefix(prefix []byte) *MapScanner {
+	s := m.Seek(prefix)
+	s.prefix = prefix
+	if s.prefix == nil {
+		s.prefix = []byte{}
+	}
+	return s
+}
+
+// Get returns the value of the first entry in the map with the
+// provided key. Get returns false if the map does not contain the
+// key. If the map was written with a bloom filter (see BloomFilter),
+// Get usually returns without reading any data for absent keys. The
+// returned value is owned by the caller.
+func (m *Map) Get(key []byte) (value []byte, ok bool, err error) {
+	if m.filter != nil && !m.filter.MayContain(bloomHash(key)) {
+		return nil, false, nil
+	}
+	s := m.Seek(key)
+	if !s.Scan() {
+		return nil, false, s.Err()
+	}
+	if !bytes.Equal(s.Key(), key) {
+		return nil, false, nil
+	}
+	return append([]byte(nil), s.Value()...), true, nil
+}
+
 // MapScanner implements ordered iteration over a map.
 type MapScanner struct {
 	parent      *Map
 	err         error
 	data, index block
+	// prefix, if non-nil, is the key prefix to which the scan is
+	// restricted.
+	prefix []byte
+	// rank orders scanners with equal keys in a MergedScanner.
+	rank int
 }
 
 // Scan scans the next entry, returning true on success. When Scan
@@ -99,9 +250,18 @@ func (m *MapScanner) Scan() bool {
 			return false
 		}
 		addr, _ := getBlockAddr(m.index.Value())
-		m.err = m.parent.readBlock(addr, &m.data)
+		m.err = m.parent.readDataBlock(addr, &m.data)
+	}
+	if m.err != nil {
+		return false
+	}
+	if m.prefix != nil && !bytes.HasPrefix(m.data.Key(), m.prefix) {
+		// Keys are sorted, so no subsequent key can have the prefix.
+		m.data = block{}
+		m.index = block{}
+		return false
 	}
-	return m.err == nil
+	return true
 }
 
 // Err returns the last error encountered while scanning.
@@ -114,7 +274,8 @@ func (m *MapScanner) Key() []byte {
 	return m.data.Key()
 }
 
-// Value returns the value that was last scanned.
+// Value returns the value that was last scanned. The value may be
+// shared with a block cache; callers must not modify it.
 func (m *MapScanner) Value() []byte {
 	return m.data.Value()
 }
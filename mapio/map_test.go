@@ -6,6 +6,9 @@ package mapio
 
 import (
 	"bytes"
+	"io"
+	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -60,3 +63,163 @@ func TestEmptyMap(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// countingReader counts the number of reads issued to the underlying
+// reader.
+type countingReader struct {
+	*bytes.Reader
+	nread int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.nread++
+	return r.Reader.Read(p)
+}
+
+func writeMap(t *testing.T, entries []entry, opts ...WriteOption) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := NewWriter(&b, opts...)
+	for i := range entries {
+		if err := w.Append(entries[i].Key, entries[i].Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestMapGet(t *testing.T) {
+	const N = 5000
+	entries := makeEntries(N)
+	for _, opts := range [][]WriteOption{
+		{BlockSize(1024)},
+		{BlockSize(1024), BloomFilter(10)},
+	} {
+		r := &countingReader{Reader: bytes.NewReader(writeMap(t, entries, opts...))}
+		m, err := New(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testSeeker(t, entries, mapSeeker{m})
+		for i := range entries {
+			value, ok, err := m.Get(entries[i].Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Fatalf("key %d not found", i)
+			}
+			// Get returns the first entry for duplicate keys.
+			if i > 0 && bytes.Equal(entries[i-1].Key, entries[i].Key) {
+				continue
+			}
+			if !bytes.Equal(value, entries[i].Value) {
+				t.Errorf("key %d: wrong value", i)
+			}
+		}
+		r.nread = 0
+		for i := 0; i < N; i++ {
+			key := append([]byte("absent"), entries[i].Key...)
+			if _, ok, err := m.Get(key); err != nil || ok {
+				t.Fatalf("absent key %d: %v %v", i, ok, err)
+			}
+		}
+		if m.filter == nil {
+			continue
+		}
+		// With 10 bits per key, the false positive rate is about 1%.
+		if r.nread > N/20 {
+			t.Errorf("too many reads for absent keys: %d", r.nread)
+		}
+	}
+}
+
+func TestMapSeekPrefix(t *testing.T) {
+	var entries []entry
+	for _, k := range []string{"a", "ab", "abc", "abd", "ac", "b", "ba"} {
+		entries = append(entries, entry{[]byte(k), []byte("v" + k)})
+	}
+	m, err := New(bytes.NewReader(writeMap(t, entries, BlockSize(8))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"a", "ab", "abc", "abd", "ac", "b", "ba"}},
+		{"a", []string{"a", "ab", "abc", "abd", "ac"}},
+		{"ab", []string{"ab", "abc", "abd"}},
+		{"abd", []string{"abd"}},
+		{"b", []string{"b", "ba"}},
+		{"bb", nil},
+		{"c", nil},
+	} {
+		s := m.SeekPrefix([]byte(c.prefix))
+		var got []string
+		for s.Scan() {
+			got = append(got, string(s.Key()))
+		}
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("prefix %q: got %v, want %v", c.prefix, got, c.want)
+		}
+		if s.Scan() {
+			t.Errorf("prefix %q: scan after EOF", c.prefix)
+		}
+	}
+}
+
+func TestMapCompressionCache(t *testing.T) {
+	const N = 5000
+	entries := makeEntries(N)
+	for i := range entries {
+		// Make values compressible.
+		entries[i].Value = bytes.Repeat(entries[i].Value, 4)
+	}
+	plain := writeMap(t, entries, BlockSize(1024))
+	compressed := writeMap(t, entries, BlockSize(1024), BlockCompression(ZstdCompression))
+	if got, want := len(compressed), len(plain)*3/4; got > want {
+		t.Errorf("compressed map too large: got %v, want <= %v", got, want)
+	}
+
+	cache := NewBlockCache(1 << 20)
+	var maps []*Map
+	for _, p := range [][]byte{plain, compressed} {
+		m, err := New(bytes.NewReader(p), Cache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		maps = append(maps, m)
+		// Also open the map through an io.ReaderAt without a Seeker.
+		m, err = NewReaderAt(readerAt{bytes.NewReader(p)}, int64(len(p)), Cache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		maps = append(maps, m)
+	}
+	var wg sync.WaitGroup
+	for _, m := range maps {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(m *Map) {
+				defer wg.Done()
+				testSeeker(t, append([]entry(nil), entries...), mapSeeker{m})
+			}(m)
+		}
+	}
+	wg.Wait()
+	if size := cache.Size(); size == 0 || size > 1<<20 {
+		t.Errorf("invalid cache size %v", size)
+	}
+}
+
+// readerAt hides all methods but ReadAt.
+type readerAt struct{ r io.ReaderAt }
+
+func (r readerAt) ReadAt(p []byte, off int64) (int, error) { return r.r.ReadAt(p, off) }
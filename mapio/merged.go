@@ -12,8 +12,10 @@ import (
 var scanSentinel = new(MapScanner)
 
 // Merged represents the merged contents of multiple underlying maps.
-// Like Map, Merged presents a sorted, scannable map, but it does not
-// guarantee that the order of traversal is stable.
+// Like Map, Merged presents a sorted, scannable map. Entries with
+// equal keys in different maps are scanned in order of decreasing
+// map index: if maps are listed from oldest to newest, the newest
+// entry for each key is scanned first.
 type Merged []*Map
 
 // Seek returns a scanner for the merged map that starts at the first
@@ -22,6 +24,7 @@ func (m Merged) Seek(key []byte) *MergedScanner {
 	merged := make(MergedScanner, 0, len(m)+1)
 	for i := range m {
 		s := m[i].Seek(key)
+		s.rank = i
 		if !s.Scan() {
 			if err := s.Err(); err != nil {
 				return &MergedScanner{s}
@@ -46,7 +49,12 @@ type MergedScanner []*MapScanner
 func (m MergedScanner) Len() int { return len(m) }
 
 // Less implements heap.Interface
-func (m MergedScanner) Less(i, j int) bool { return bytes.Compare(m[i].Key(), m[j].Key()) < 0 }
+func (m MergedScanner) Less(i, j int) bool {
+	if c := bytes.Compare(m[i].Key(), m[j].Key()); c != 0 {
+		return c < 0
+	}
+	return m[i].rank > m[j].rank
+}
 
 // Swap implements heap.Interface
 func (m MergedScanner) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
@@ -45,3 +45,42 @@ func TestMerged(t *testing.T) {
 
 	testSeeker(t, entries, mergedSeeker{merged})
 }
+
+func TestMergedOrder(t *testing.T) {
+	const M = 5
+	merged := make(Merged, M)
+	for i := range merged {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		for _, key := range []string{"a", "b", "c"} {
+			if err := w.Append([]byte(key), []byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		merged[i], err = New(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := merged.Seek(nil)
+	for _, key := range []string{"a", "b", "c"} {
+		for i := M - 1; i >= 0; i-- {
+			if !s.Scan() {
+				t.Fatal("short scan", s.Err())
+			}
+			if got, want := string(s.Key()), key; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if got, want := int(s.Value()[0]), i; got != want {
+				t.Errorf("key %s: got map %d, want map %d", key, got, want)
+			}
+		}
+	}
+	if s.Scan() {
+		t.Error("expected end of scan")
+	}
+}
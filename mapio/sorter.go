@@ -0,0 +1,341 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package mapio
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+
+	"github.com/grailbio/base/psort"
+)
+
+// DefaultSpillSize is the default size, in bytes, of the entries a
+// Sorter buffers in memory before spilling them to disk.
+const DefaultSpillSize = 256 << 20
+
+// A CombineFunc combines two values with the same key into one.
+// Value1 was appended before value2. The function may not retain its
+// arguments; it may return a slice that aliases value1.
+type CombineFunc func(key, value1, value2 []byte) []byte
+
+// A Sorter is a write buffer for maps that, unlike Buf, is not
+// limited by the available memory. Entries are buffered in memory
+// until their total size exceeds the sorter's spill size; the
+// buffered entries are then sorted and written to a temporary file
+// as a sorted run. WriteTo merges the runs into a Writer.
+//
+// Entries with equal keys are written in the order in which they
+// were appended, unless the sorter has a combiner, in which case
+// they are combined into a single entry.
+type Sorter struct {
+	spillSize   int
+	tempDir     string
+	combine     CombineFunc
+	parallelism int
+
+	entries []sortEntry
+	size    int
+	runs    []*os.File
+	err     error
+}
+
+type sortEntry struct{ key, value []byte }
+
+// A SorterOption configures a Sorter.
+type SorterOption func(*Sorter)
+
+// SpillSize sets the size, in bytes, of the entries that the sorter
+// buffers in memory before spilling them to a temporary file.
+func SpillSize(size int) SorterOption {
+	return func(s *Sorter) {
+		s.spillSize = size
+	}
+}
+
+// TempDir sets the directory in which the sorter stores its
+// temporary files. By default, the system's temporary directory is
+// used.
+func TempDir(dir string) SorterOption {
+	return func(s *Sorter) {
+		s.tempDir = dir
+	}
+}
+
+// Combiner sets the function used to combine entries with equal keys.
+func Combiner(combine CombineFunc) SorterOption {
+	return func(s *Sorter) {
+		s.combine = combine
+	}
+}
+
+// SortParallelism sets the number of goroutines used to sort each
+// run in memory. By default, runtime.NumCPU() is used.
+func SortParallelism(n int) SorterOption {
+	return func(s *Sorter) {
+		s.parallelism = n
+	}
+}
+
+// NewSorter returns a new Sorter configured by the provided options.
+func NewSorter(opts ...SorterOption) *Sorter {
+	s := &Sorter{
+		spillSize:   DefaultSpillSize,
+		parallelism: runtime.NumCPU(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.parallelism < 1 {
+		s.parallelism = 1
+	}
+	return s
+}
+
+// Append appends the given entry to the sorter. If the buffered
+// entries exceed the sorter's spill size, they are spilled to a
+// temporary file.
+func (s *Sorter) Append(key, value []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	p := make([]byte, len(key)+len(value))
+	copy(p, key)
+	copy(p[len(key):], value)
+	s.entries = append(s.entries, sortEntry{p[:len(key):len(key)], p[len(key):]})
+	s.size += len(p)
+	if s.size >= s.spillSize {
+		s.err = s.spill()
+	}
+	return s.err
+}
+
+// Size returns the size, in bytes, of the entries currently buffered
+// in memory.
+func (s *Sorter) Size() int { return s.size }
+
+// WriteTo writes all of the sorter's entries, in order, to the
+// provided writer, and then releases the sorter's temporary files.
+func (s *Sorter) WriteTo(w *Writer) error {
+	if s.err != nil {
+		return s.err
+	}
+	defer s.Close() // nolint: errcheck
+	if len(s.runs) == 0 {
+		s.sort()
+		return s.writeSorted(w.Append)
+	}
+	if len(s.entries) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	return s.merge(w.Append)
+}
+
+// Close discards the sorter's entries and removes its temporary
+// files. Close need not be called after WriteTo.
+func (s *Sorter) Close() error {
+	var err error
+	for _, f := range s.runs {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+		if e := os.Remove(f.Name()); e != nil && err == nil {
+			err = e
+		}
+	}
+	s.runs = nil
+	s.entries = nil
+	s.size = 0
+	return err
+}
+
+// sort sorts the in-memory entries by key. Entries with equal keys
+// remain in the order in which they were appended.
+func (s *Sorter) sort() {
+	entries := s.entries
+	psort.Slice(entries, func(i, j int) bool {
+		// psort.Slice calls less with indices into the original slice,
+		// so breaking ties by index makes the sort stable.
+		if c := bytes.Compare(entries[i].key, entries[j].key); c != 0 {
+			return c < 0
+		}
+		return i < j
+	}, s.parallelism)
+}
+
+// writeSorted writes the sorted in-memory entries to fn, combining
+// entries with equal keys if the sorter has a combiner.
+func (s *Sorter) writeSorted(fn func(key, value []byte) error) error {
+	for i := 0; i < len(s.entries); {
+		key, value := s.entries[i].key, s.entries[i].value
+		i++
+		if s.combine != nil {
+			for ; i < len(s.entries) && bytes.Equal(s.entries[i].key, key); i++ {
+				value = s.combine(key, value, s.entries[i].value)
+			}
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spill sorts the in-memory entries and writes them to a new run.
+func (s *Sorter) spill() error {
+	f, err := ioutil.TempFile(s.tempDir, "mapiosort")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+	s.sort()
+	var (
+		w   = bufio.NewWriter(f)
+		buf [2 * binary.MaxVarintLen64]byte
+	)
+	err = s.writeSorted(func(key, value []byte) error {
+		n := binary.PutUvarint(buf[:], uint64(len(key)))
+		n += binary.PutUvarint(buf[n:], uint64(len(value)))
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		if _, err := w.Write(key); err != nil {
+			return err
+		}
+		_, err := w.Write(value)
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("mapio: spill to %s: %v", f.Name(), err)
+	}
+	s.entries = nil
+	s.size = 0
+	return nil
+}
+
+// merge performs a k-way merge of the sorter's runs into fn,
+// combining entries with equal keys if the sorter has a combiner.
+func (s *Sorter) merge(fn func(key, value []byte) error) error {
+	var h runHeap
+	for i, f := range s.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r := &runReader{r: bufio.NewReader(f), index: i}
+		if r.next() {
+			h = append(h, r)
+		} else if r.err != nil {
+			return r.err
+		}
+	}
+	heap.Init(&h)
+	var (
+		key, value []byte
+		pending    bool
+	)
+	for len(h) > 0 {
+		r := h[0]
+		switch {
+		case pending && bytes.Equal(r.key, key):
+			value = s.combine(key, value, r.value)
+		case s.combine == nil:
+			if err := fn(r.key, r.value); err != nil {
+				return err
+			}
+		default:
+			if pending {
+				if err := fn(key, value); err != nil {
+					return err
+				}
+			}
+			key = append(key[:0], r.key...)
+			value = append([]byte(nil), r.value...)
+			pending = true
+		}
+		if r.next() {
+			heap.Fix(&h, 0)
+		} else if r.err != nil {
+			return r.err
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	if pending {
+		return fn(key, value)
+	}
+	return nil
+}
+
+// A runReader reads entries from a sorted run.
+type runReader struct {
+	r          *bufio.Reader
+	index      int
+	key, value []byte
+	err        error
+}
+
+// next reads the next entry in the run, returning false at the end
+// of the run or on error.
+func (r *runReader) next() bool {
+	keyLen, err := binary.ReadUvarint(r.r)
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		r.err = err
+		return false
+	}
+	valueLen, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		r.err = err
+		return false
+	}
+	n := int(keyLen + valueLen)
+	if cap(r.key) < n {
+		r.key = make([]byte, n)
+	}
+	p := r.key[:n]
+	if _, err := io.ReadFull(r.r, p); err != nil {
+		r.err = err
+		return false
+	}
+	r.key, r.value = p[:keyLen], p[keyLen:]
+	return true
+}
+
+// runHeap is a heap of runs ordered by their current key, and then
+// by the order in which the runs were written.
+type runHeap []*runReader
+
+func (h runHeap) Len() int { return len(h) }
+
+func (h runHeap) Less(i, j int) bool {
+	if c := bytes.Compare(h[i].key, h[j].key); c != 0 {
+		return c < 0
+	}
+	return h[i].index < h[j].index
+}
+
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+
+func (h *runHeap) Pop() interface{} {
+	n := len(*h)
+	r := (*h)[n-1]
+	*h = (*h)[:n-1]
+	return r
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package mapio
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/grailbio/testutil"
+)
+
+func TestSorter(t *testing.T) {
+	const N = 1000
+	entries := makeEntries(N)
+	rand.Shuffle(len(entries), func(i, j int) {
+		entries[i], entries[j] = entries[j], entries[i]
+	})
+	for _, spillSize := range []int{1 << 30, 1 << 10, 1} {
+		dir, cleanup := testutil.TempDir(t, "", "")
+		s := NewSorter(SpillSize(spillSize), TempDir(dir), SortParallelism(4))
+		for _, e := range entries {
+			if err := s.Append(e.Key, e.Value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var b bytes.Buffer
+		w := NewWriter(&b, BlockSize(1<<10), RestartInterval(10))
+		if err := s.WriteTo(w); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// Temporary files are removed.
+		if infos, err := ioutil.ReadDir(dir); err != nil || len(infos) != 0 {
+			t.Errorf("spill size %d: temporary files %v remain (%v)", spillSize, infos, err)
+		}
+		cleanup()
+		m, err := New(bytes.NewReader(b.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sorted := append([]entry(nil), entries...)
+		sortEntries(sorted)
+		testSeeker(t, sorted, mapSeeker{m})
+	}
+}
+
+func TestSorterDuplicates(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	const (
+		N    = 5000
+		Keys = 100
+	)
+	sum := func(key, value1, value2 []byte) []byte {
+		x, _ := strconv.Atoi(string(value1))
+		y, _ := strconv.Atoi(string(value2))
+		return strconv.AppendInt(value1[:0], int64(x+y), 10)
+	}
+	for _, combine := range []CombineFunc{nil, sum} {
+		for _, spillSize := range []int{1 << 30, 1 << 10} {
+			s := NewSorter(SpillSize(spillSize), TempDir(dir), Combiner(combine))
+			for i := 0; i < N; i++ {
+				key := fmt.Sprintf("%03d", (i*7)%Keys)
+				if err := s.Append([]byte(key), []byte(fmt.Sprint(i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var b bytes.Buffer
+			w := NewWriter(&b)
+			if err := s.WriteTo(w); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			m, err := New(bytes.NewReader(b.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var (
+				scan  = m.Seek(nil)
+				last  = map[string]int{}
+				sums  = map[string]int{}
+				count int
+			)
+			for scan.Scan() {
+				key := string(scan.Key())
+				value, _ := strconv.Atoi(string(scan.Value()))
+				if prev, ok := last[key]; ok && prev >= value {
+					t.Errorf("key %s: value %d follows %d", key, value, prev)
+				}
+				last[key] = value
+				sums[key] += value
+				count++
+			}
+			if err := scan.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if combine == nil {
+				if got, want := count, N; got != want {
+					t.Errorf("got %d entries, want %d", got, want)
+				}
+				continue
+			}
+			if got, want := count, Keys; got != want {
+				t.Errorf("got %d entries, want %d", got, want)
+			}
+			for i := 0; i < Keys; i++ {
+				key := fmt.Sprintf("%03d", i)
+				want := 0
+				for j := 0; j < N; j++ {
+					if (j*7)%Keys == i {
+						want += j
+					}
+				}
+				if got := sums[key]; got != want {
+					t.Errorf("key %s: got %d, want %d", key, got, want)
+				}
+			}
+		}
+	}
+}
@@ -48,6 +48,9 @@ type Writer struct {
 
 	blockSize int
 	off       int
+
+	bloomBitsPerKey int
+	hashes          []uint64
 }
 
 const (
@@ -77,6 +80,26 @@ func RestartInterval(iv int) WriteOption {
 	}
 }
 
+// BlockCompression sets the compression algorithm for the map's data
+// blocks. A block is stored uncompressed if compression does not
+// shrink it appreciably. By default, blocks are not compressed.
+func BlockCompression(c Compression) WriteOption {
+	return func(w *Writer) {
+		w.data.compression = c
+	}
+}
+
+// BloomFilter configures the writer to store a bloom filter over the
+// map's keys, using the provided number of bits per key. The filter
+// lets Map.Get skip lookups of most absent keys without reading any
+// data blocks. 10 bits per key yield a false positive rate of about
+// 1%. By default, no filter is written.
+func BloomFilter(bitsPerKey int) WriteOption {
+	return func(w *Writer) {
+		w.bloomBitsPerKey = bitsPerKey
+	}
+}
+
 // NewWriter returns a new Writer that writes a map to the provided
 // io.Writer. BlockSize specifies the target block size, while
 // restartInterval determines the frequency of key restart points,
@@ -105,6 +128,9 @@ func (w *Writer) Append(key, value []byte) error {
 		w.lastKey = w.lastKey[:len(key)]
 	}
 	copy(w.lastKey, key)
+	if w.bloomBitsPerKey > 0 {
+		w.hashes = append(w.hashes, bloomHash(key))
+	}
 	if w.data.Len() > w.blockSize {
 		return w.Flush()
 	}
@@ -115,21 +141,17 @@ func (w *Writer) Append(key, value []byte) error {
 // creation of a new block, and overrides the Writer's block size
 // parameter.
 func (w *Writer) Flush() error {
-	w.data.Finish()
-	n, err := w.w.Write(w.data.Bytes())
+	addr, err := w.writeBlock(&w.data)
 	if err != nil {
 		return err
 	}
-	w.data.Reset()
-	off := w.off
-	w.off += n
 
 	// TODO(marius): we can get more clever about key compression here:
 	// We need to guarantee that the lastKey <= indexKey < firstKey,
 	// where firstKey is the first key in the next block. We can thus
 	// construct a more minimal key to store in the index.
 	b := make([]byte, maxBlockAddrSize)
-	n = putBlockAddr(b, blockAddr{uint64(off), uint64(n)})
+	n := putBlockAddr(b, addr)
 	w.index.Append(w.lastKey, b[:n])
 
 	return nil
@@ -141,19 +163,44 @@ func (w *Writer) Close() error {
 	if err := w.Flush(); err != nil {
 		return err
 	}
-	w.index.Finish()
-	n, err := w.w.Write(w.index.Bytes())
+	var metaIndexAddr blockAddr
+	if w.bloomBitsPerKey > 0 {
+		var meta, metaIndex blockBuffer
+		meta.Append([]byte(bloomFilterName), newBloomFilter(w.bloomBitsPerKey, w.hashes))
+		w.hashes = nil
+		addr, err := w.writeBlock(&meta)
+		if err != nil {
+			return err
+		}
+		b := make([]byte, maxBlockAddrSize)
+		metaIndex.Append([]byte(bloomFilterName), b[:putBlockAddr(b, addr)])
+		if metaIndexAddr, err = w.writeBlock(&metaIndex); err != nil {
+			return err
+		}
+	}
+	indexAddr, err := w.writeBlock(&w.index)
 	if err != nil {
 		return err
 	}
-	w.index.Reset()
-	indexAddr := blockAddr{uint64(w.off), uint64(n)}
-	w.off += n
 
 	trailer := make([]byte, mapTrailerSize)
-	putBlockAddr(trailer, blockAddr{}) // address of meta block index. tbd.
+	putBlockAddr(trailer, metaIndexAddr)
 	putBlockAddr(trailer[maxBlockAddrSize:], indexAddr)
 	order.PutUint64(trailer[len(trailer)-8:], mapTrailerMagic)
 	_, err = w.w.Write(trailer)
 	return err
 }
+
+// writeBlock finishes the provided block, writes it to the underlying
+// writer, and resets it. It returns the address of the written block.
+func (w *Writer) writeBlock(b *blockBuffer) (blockAddr, error) {
+	b.Finish()
+	n, err := w.w.Write(b.Bytes())
+	if err != nil {
+		return blockAddr{}, err
+	}
+	b.Reset()
+	addr := blockAddr{uint64(w.off), uint64(n)}
+	w.off += n
+	return addr, nil
+}
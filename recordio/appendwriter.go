@@ -0,0 +1,165 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package recordio
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/recordio/internal"
+)
+
+// AppendFile is the subset of *os.File needed by NewAppendWriter.
+type AppendFile interface {
+	io.ReadWriteSeeker
+	// Truncate changes the size of the file.
+	Truncate(size int64) error
+}
+
+// NewAppendWriter reopens an existing v2 recordio file for appending. It reads
+// and validates the header, scans the blocks that follow, and truncates the
+// file after the last complete data block. Data blocks written later by the
+// returned writer are appended there. If the file is empty, or contains only a
+// partially written header, NewAppendWriter behaves like NewWriter.
+//
+// A block whose write was interrupted (e.g., by a crash) is discarded. If the
+// file contains a trailer, the trailer is also removed, and it is rewritten by
+// Finish unless the application calls SetTrailer. Any other corruption is
+// reported as an error. NewAppendWriter reads the whole file, so its cost is
+// proportional to the file size.
+//
+// The header of the existing file is preserved, and AddHeader must not be
+// called on the returned writer. If opts.Transformers is nil, the transformers
+// recorded in the header are used. Otherwise, they must match the header
+// exactly. opts.KeyTrailer may be set only if the file was created with
+// KeyTrailer. opts.SkipHeader is ignored.
+//
+// Locations passed to opts.Index are relative to the start of the file, so
+// they are interchangeable with those produced when the file was first
+// written.
+func NewAppendWriter(f AppendFile, opts WriterOpts) (Writer, error) {
+	header, trailer, off, err := scanForAppend(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(off); err != nil {
+		return nil, err
+	}
+	if err := internal.Seek(f, off); err != nil {
+		return nil, err
+	}
+	if off == 0 {
+		opts.SkipHeader = false
+		return NewWriter(f, opts), nil
+	}
+	transformers, err := header.transformers()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Transformers == nil {
+		opts.Transformers = transformers
+	} else if !equalStrings(opts.Transformers, transformers) {
+		return nil, fmt.Errorf("recordio: transformers %v do not match the file's transformers %v",
+			opts.Transformers, transformers)
+	}
+	if opts.KeyTrailer && !header.HasTrailer() {
+		return nil, fmt.Errorf("recordio: KeyTrailer set, but the file was created without key '%v'", KeyTrailer)
+	}
+	opts.SkipHeader = true
+	w := NewWriter(f, opts).(*writerv2)
+	w.header = header
+	w.prevTrailer = trailer
+	w.fq.wr = internal.NewChunkWriterAt(f, off, &w.err)
+	return w, w.Err()
+}
+
+// scanForAppend reads the header and all the blocks in the given file. It
+// returns the parsed header, the untransformed trailer payload (nil if the file
+// has no trailer), and the offset just after the last complete data block. If
+// the file is empty or contains only a partial header block, it returns offset
+// 0.
+func scanForAppend(f AppendFile) (header ParsedHeader, trailer []byte, off int64, err error) {
+	errp := errors.Once{Ignored: []error{io.EOF}}
+	sc := internal.NewChunkScanner(f, &errp)
+	if err = errp.Err(); err != nil {
+		return
+	}
+	if sc.FileSize() == 0 {
+		return
+	}
+	if !sc.Scan() {
+		if sc.IsPartialBlock(0) {
+			return
+		}
+		if err = errp.Err(); err == nil {
+			err = fmt.Errorf("recordio: failed to read header block")
+		}
+		return
+	}
+	magic, chunks := sc.Block()
+	if magic != internal.MagicHeader {
+		err = fmt.Errorf("recordio: not a v2 recordio file, found magic %v", magic)
+		return
+	}
+	var rawItems rawItemList
+	if err = parseChunksToItems(&rawItems, chunks, idTransform); err != nil {
+		return
+	}
+	if rawItems.len() != 1 {
+		err = fmt.Errorf("recordio: wrong # of items in header block, %d", rawItems.len())
+		return
+	}
+	if err = header.unmarshal(rawItems.item(0)); err != nil {
+		return
+	}
+	transformers, err := header.transformers()
+	if err != nil {
+		return
+	}
+	untransform, err := registry.GetUntransformer(transformers)
+	if err != nil {
+		return
+	}
+
+	off = sc.Tell()
+	for sc.Scan() {
+		magic, chunks := sc.Block()
+		if magic == internal.MagicPacked {
+			off = sc.Tell()
+			continue
+		}
+		if magic != internal.MagicTrailer {
+			err = fmt.Errorf("recordio: invalid magic number %v at offset %d", magic, off)
+			return
+		}
+		rawItems.clear()
+		if err = parseChunksToItems(&rawItems, chunks, untransform); err != nil {
+			return
+		}
+		if rawItems.len() != 1 {
+			err = fmt.Errorf("recordio: expect exactly one trailer item, but found %d", rawItems.len())
+			return
+		}
+		trailer = append([]byte{}, rawItems.item(0)...)
+		return
+	}
+	if errp.Err() != nil && !sc.IsPartialBlock(off) {
+		err = fmt.Errorf("recordio: corrupt block at offset %d: %v", off, errp.Err())
+	}
+	return
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
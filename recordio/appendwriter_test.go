@@ -0,0 +1,136 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package recordio_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grailbio/base/recordio"
+	"github.com/grailbio/base/recordio/internal"
+	"github.com/grailbio/testutil"
+	"github.com/grailbio/testutil/assert"
+	"github.com/grailbio/testutil/expect"
+)
+
+func readAllFile(t *testing.T, path string) (recordio.ParsedHeader, []string, string) {
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	return readAllV2(t, bytes.NewBuffer(data))
+}
+
+func appendToFile(t *testing.T, path string, opts recordio.WriterOpts, items ...string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	assert.NoError(t, err)
+	defer f.Close()
+	w, err := recordio.NewAppendWriter(f, opts)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		w.Append(item)
+	}
+	return w.Finish()
+}
+
+func TestAppendWriter(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	path := filepath.Join(dir, "test.rio")
+
+	opts := recordio.WriterOpts{
+		Marshal:      marshalString,
+		Transformers: []string{"zstd"},
+	}
+	// Appending to a nonexistent file creates a new one.
+	assert.NoError(t, appendToFile(t, path, opts, "F0", "F1"))
+	assert.NoError(t, appendToFile(t, path, opts, "F2"))
+	// Transformers are taken from the header if unset.
+	assert.NoError(t, appendToFile(t, path, recordio.WriterOpts{Marshal: marshalString}, "F3"))
+
+	header, body, trailer := readAllFile(t, path)
+	expect.EQ(t, recordio.ParsedHeader{recordio.KeyValue{recordio.KeyTransformer, "zstd"}}, header)
+	expect.EQ(t, []string{"F0", "F1", "F2", "F3"}, body)
+	expect.EQ(t, "", trailer)
+
+	opts.Transformers = []string{"flate"}
+	expect.HasSubstr(t, appendToFile(t, path, opts), "do not match")
+	opts.Transformers = nil
+	opts.KeyTrailer = true
+	expect.HasSubstr(t, appendToFile(t, path, opts), "created without key")
+}
+
+func TestAppendWriterTrailer(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	path := filepath.Join(dir, "test.rio")
+
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	w := recordio.NewWriter(f, recordio.WriterOpts{Marshal: marshalString, KeyTrailer: true})
+	w.Append("F0")
+	w.SetTrailer([]byte("T0"))
+	assert.NoError(t, w.Finish())
+	assert.NoError(t, f.Close())
+
+	// The old trailer is rewritten by Finish.
+	assert.NoError(t, appendToFile(t, path, recordio.WriterOpts{Marshal: marshalString}, "F1"))
+	_, body, trailer := readAllFile(t, path)
+	expect.EQ(t, []string{"F0", "F1"}, body)
+	expect.EQ(t, "T0", trailer)
+
+	// SetTrailer replaces the old trailer.
+	f, err = os.OpenFile(path, os.O_RDWR, 0)
+	assert.NoError(t, err)
+	w, err = recordio.NewAppendWriter(f, recordio.WriterOpts{Marshal: marshalString})
+	assert.NoError(t, err)
+	w.Append("F2")
+	w.SetTrailer([]byte("T1"))
+	assert.NoError(t, w.Finish())
+	assert.NoError(t, f.Close())
+	_, body, trailer = readAllFile(t, path)
+	expect.EQ(t, []string{"F0", "F1", "F2"}, body)
+	expect.EQ(t, "T1", trailer)
+}
+
+func TestAppendWriterPartialBlock(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	path := filepath.Join(dir, "test.rio")
+
+	index := map[string]recordio.ItemLocation{}
+	opts := recordio.WriterOpts{
+		Marshal: marshalString,
+		Index: func(loc recordio.ItemLocation, v interface{}) error {
+			index[v.(string)] = loc
+			return nil
+		},
+	}
+	assert.NoError(t, appendToFile(t, path, opts, "F0"))
+	assert.NoError(t, appendToFile(t, path, opts, "X"))
+	// Simulate a crash in the middle of writing the last block.
+	assert.NoError(t, os.Truncate(path, 2*internal.ChunkSize+internal.ChunkSize/2))
+
+	assert.NoError(t, appendToFile(t, path, opts, "F1"))
+	_, body, _ := readAllFile(t, path)
+	expect.EQ(t, []string{"F0", "F1"}, body)
+	expect.EQ(t, uint64(2*internal.ChunkSize), index["F1"].Block)
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	sc := recordio.NewScanner(bytes.NewReader(data), recordio.ScannerOpts{Unmarshal: unmarshalString})
+	sc.Seek(index["F1"])
+	assert.True(t, sc.Scan())
+	expect.EQ(t, "F1", sc.Get().(string))
+	assert.NoError(t, sc.Finish())
+
+	// Corruption in the middle of the file is reported.
+	data[internal.ChunkSize+internal.ChunkHeaderSize] ^= 0xff
+	assert.NoError(t, ioutil.WriteFile(path, data, 0600))
+	expect.HasSubstr(t, appendToFile(t, path, opts, "F2"), "corrupt block")
+}
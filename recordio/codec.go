@@ -0,0 +1,109 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package recordio
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+)
+
+// Codec serializes items of type T. It is used by TypedWriter and
+// TypedScanner. A Codec must be thread safe.
+type Codec[T any] interface {
+	// Name identifies the serialization format, e.g., "json". It is recorded
+	// in the header under KeyCodec.
+	Name() string
+	// Schema describes the type of the items, e.g., the fully qualified Go
+	// type name. Its fingerprint is recorded in the header under KeySchema.
+	// If Schema returns "", the fingerprint is not recorded.
+	Schema() string
+	// Marshal serializes v. Parameter scratch is passed as a performance hint,
+	// as in MarshalFunc.
+	Marshal(scratch []byte, v T) ([]byte, error)
+	// Unmarshal deserializes an item produced by Marshal.
+	Unmarshal(data []byte) (T, error)
+}
+
+// SchemaFingerprint computes the value stored under KeySchema for the given
+// schema description.
+func SchemaFingerprint(schema string) string {
+	sum := sha256.Sum256([]byte(schema))
+	return hex.EncodeToString(sum[:8])
+}
+
+// typeName returns the fully qualified name of type T.
+func typeName[T any]() string {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Name() != "" && typ.PkgPath() != "" {
+		return typ.PkgPath() + "." + typ.Name()
+	}
+	return typ.String()
+}
+
+// BytesCodec stores []byte items as is.
+type BytesCodec struct{}
+
+// Name implements Codec.
+func (BytesCodec) Name() string { return "bytes" }
+
+// Schema implements Codec.
+func (BytesCodec) Schema() string { return "" }
+
+// Marshal implements Codec.
+func (BytesCodec) Marshal(scratch []byte, v []byte) ([]byte, error) { return v, nil }
+
+// Unmarshal implements Codec. The returned slice is a copy of data.
+func (BytesCodec) Unmarshal(data []byte) ([]byte, error) {
+	return append([]byte(nil), data...), nil
+}
+
+// GobCodec stores items using encoding/gob. Each item is encoded
+// independently, so it carries its own type description.
+type GobCodec[T any] struct{}
+
+// Name implements Codec.
+func (GobCodec[T]) Name() string { return "gob" }
+
+// Schema implements Codec. It returns the fully qualified Go type name of T.
+func (GobCodec[T]) Schema() string { return typeName[T]() }
+
+// Marshal implements Codec.
+func (GobCodec[T]) Marshal(scratch []byte, v T) ([]byte, error) {
+	buf := bytes.NewBuffer(scratch[:0])
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal implements Codec.
+func (GobCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// JSONCodec stores items using encoding/json.
+type JSONCodec[T any] struct{}
+
+// Name implements Codec.
+func (JSONCodec[T]) Name() string { return "json" }
+
+// Schema implements Codec. It returns the fully qualified Go type name of T.
+func (JSONCodec[T]) Schema() string { return typeName[T]() }
+
+// Marshal implements Codec.
+func (JSONCodec[T]) Marshal(scratch []byte, v T) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal implements Codec.
+func (JSONCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
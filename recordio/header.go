@@ -22,6 +22,15 @@ const (
 
 	// KeyTransformer defines transformer functions used to encode blocks.
 	KeyTransformer = "transformer"
+
+	// KeyCodec is the name of the Codec used by TypedWriter to serialize items.
+	// value type: string
+	KeyCodec = "codec"
+
+	// KeySchema is the fingerprint of the item schema, as computed by
+	// SchemaFingerprint(Codec.Schema()).
+	// value type: string
+	KeySchema = "schema"
 )
 
 // KeyValue defines one entry stored in a recordio header block
@@ -252,3 +261,29 @@ func (h *ParsedHeader) HasTrailer() bool {
 	}
 	return false
 }
+
+// transformers returns the values of the "transformer" entries, in order.
+func (h ParsedHeader) transformers() ([]string, error) {
+	transformers := []string{}
+	for _, kv := range h {
+		if kv.Key == KeyTransformer {
+			str, ok := kv.Value.(string)
+			if !ok {
+				return nil, fmt.Errorf("Expect string value for key %v, but found %v", kv.Key, kv.Value)
+			}
+			transformers = append(transformers, str)
+		}
+	}
+	return transformers, nil
+}
+
+// value returns the value of the first entry with the given key, or nil if
+// there is no such entry.
+func (h ParsedHeader) value(key string) interface{} {
+	for _, kv := range h {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return nil
+}
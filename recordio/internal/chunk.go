@@ -158,6 +158,14 @@ func NewChunkWriter(w io.Writer, err *errors.Once) *ChunkWriter {
 	return &ChunkWriter{w: w, err: err, crc: crc32.New(IEEECRC)}
 }
 
+// NewChunkWriterAt creates a new chunk writer for a file that already contains
+// "off" bytes of chunk data. Writes are appended at the current position of
+// "w", and Len reports offsets relative to the start of the file. Any error is
+// reported through "err".
+func NewChunkWriterAt(w io.Writer, off int64, err *errors.Once) *ChunkWriter {
+	return &ChunkWriter{w: w, err: err, crc: crc32.New(IEEECRC), nWritten: off}
+}
+
 // ChunkScanner reads a sequence of chunks and reconstructs a logical
 // block. Thread compatible.
 type ChunkScanner struct {
@@ -202,12 +210,23 @@ func (r *ChunkScanner) LimitShard(start, limit, nshard int) {
 	numChunks := (r.fileSize - r.off) / ChunkSize
 	chunksPerShard := float64(numChunks) / float64(nshard)
 	startOff := r.off
-	r.off = startOff + int64(float64(start)*chunksPerShard)*ChunkSize
-	r.limit = startOff + int64(float64(limit)*chunksPerShard)*ChunkSize
-	if start == 0 {
-		// No more work to do. We assume LimitShard is called on a block boundary.
+	r.LimitRange(
+		startOff+int64(float64(start)*chunksPerShard)*ChunkSize,
+		startOff+int64(float64(limit)*chunksPerShard)*ChunkSize)
+}
+
+// LimitRange limits this scanner to scan the blocks that begin in the byte
+// range [start,limit) of the file. The scanner moves to the first block
+// boundary at or after start. If start is at or before the scanner's current
+// offset, which must be on a block boundary, the scanner stays there.
+func (r *ChunkScanner) LimitRange(start, limit int64) {
+	r.limit = limit
+	if start <= r.off {
+		// No more work to do. We assume LimitRange is called on a block boundary.
 		return
 	}
+	// Chunks are aligned at ChunkSize boundaries.
+	r.off = (start + ChunkSize - 1) / ChunkSize * ChunkSize
 	r.err.Set(Seek(r.r, r.off))
 	if r.err.Err() != nil {
 		return
@@ -225,7 +244,7 @@ func (r *ChunkScanner) LimitShard(start, limit, nshard int) {
 		return
 	}
 	// We're in the middle of a block. The current block belongs to the
-	// previous shard, so we forward to the next block boundary.
+	// previous range, so we forward to the next block boundary.
 	total := header.TotalChunks()
 	if total <= index {
 		r.err.Set(errors.New("invalid chunk header"))
@@ -235,6 +254,43 @@ func (r *ChunkScanner) LimitShard(start, limit, nshard int) {
 	r.err.Set(Seek(r.r, r.off))
 }
 
+// FileSize returns the size of the file, as computed when the scanner was
+// created.
+func (r *ChunkScanner) FileSize() int64 {
+	return r.fileSize
+}
+
+// IsPartialBlock reports whether the data in range [off, FileSize()) can be
+// explained as a prefix of a single block, i.e., the result of a block write
+// that was interrupted midway. The read pointer is at an undefined position
+// after the call, so the user must call Seek() explicitly. Errors are not
+// reported through r.Err().
+func (r *ChunkScanner) IsPartialBlock(off int64) bool {
+	remaining := r.fileSize - off
+	if remaining <= 0 {
+		return false
+	}
+	if remaining < ChunkHeaderSize {
+		return true
+	}
+	if err := Seek(r.r, off); err != nil {
+		return false
+	}
+	var header chunkHeader
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return false
+	}
+	var magic MagicBytes
+	copy(magic[:], header[:])
+	if magic != MagicHeader && magic != MagicPacked && magic != MagicTrailer {
+		return false
+	}
+	if header.Index() != 0 {
+		return false
+	}
+	return remaining < int64(header.TotalChunks())*ChunkSize
+}
+
 // Tell returns the file offset of the next block to be read.
 // Any error is reported in r.Err()
 func (r *ChunkScanner) Tell() int64 {
@@ -0,0 +1,139 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package recordioencrypt provides the "aes-gcm" transformer. It encrypts and
+// authenticates blocks using AES-GCM, with keys managed by the
+// crypto/encryption package. To use:
+//
+// - Call recordioencrypt.Init() when the process starts.
+//
+// - Add "aes-gcm <registry>/<keyid>" to WriterOpts.Transformers, where
+//   <registry> names a registry installed by encryption.Register, and <keyid>
+//   is the hex-encoded key ID. The transformer string, hence the key ID, is
+//   recorded in the header, so the scanner finds the key automatically.
+//
+// Encryption should be the last transformer, since encrypted data does not
+// compress: e.g., Transformers: []string{"zstd", "aes-gcm <registry>/<keyid>"}.
+package recordioencrypt
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/grailbio/base/crypto/encryption"
+	"github.com/grailbio/base/recordio"
+	"github.com/grailbio/base/recordio/recordioiov"
+)
+
+// Name is the registered name of the AES-GCM transformer.
+const Name = "aes-gcm"
+
+// Config returns the transformer string, to be added to
+// WriterOpts.Transformers, that encrypts blocks with the given key.
+func Config(kd encryption.KeyDescriptor) string {
+	return fmt.Sprintf("%s %s/%s", Name, kd.Registry, hex.EncodeToString(kd.ID))
+}
+
+// parseConfig parses a "<registry>/<keyid>" config string.
+func parseConfig(config string) (kd encryption.KeyDescriptor, err error) {
+	i := strings.LastIndex(config, "/")
+	if i < 0 {
+		return kd, fmt.Errorf("recordioencrypt: config %q is not of form <registry>/<keyid>", config)
+	}
+	kd.Registry = config[:i]
+	if kd.ID, err = hex.DecodeString(config[i+1:]); err != nil {
+		return kd, fmt.Errorf("recordioencrypt: invalid key ID in config %q: %v", config, err)
+	}
+	return kd, nil
+}
+
+// newAEAD creates the AEAD cipher for the key named by config.
+func newAEAD(config string) (cipher.AEAD, error) {
+	kd, err := parseConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	reg, err := encryption.Lookup(kd.Registry)
+	if err != nil {
+		return nil, err
+	}
+	_, block, err := reg.NewBlock(kd.ID)
+	if err != nil {
+		return nil, err
+	}
+	return reg.NewGCM(block)
+}
+
+// Each encrypted block is laid out as
+//
+//   nonce [aead.NonceSize()]
+//   ciphertext, including the authentication tag
+func encrypt(aead cipher.AEAD, scratch []byte, in [][]byte) ([]byte, error) {
+	plaintext := recordioiov.Slice(scratch, recordioiov.TotalBytes(in))
+	n := 0
+	for _, b := range in {
+		n += copy(plaintext[n:], b)
+	}
+	out := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, out); err != nil {
+		return nil, fmt.Errorf("recordioencrypt: failed to generate nonce: %v", err)
+	}
+	return aead.Seal(out, out, plaintext, nil), nil
+}
+
+func decrypt(aead cipher.AEAD, scratch []byte, in [][]byte) ([]byte, error) {
+	var ciphertext []byte
+	if len(in) == 1 {
+		ciphertext = in[0]
+	} else {
+		ciphertext = recordioiov.Slice(nil, recordioiov.TotalBytes(in))
+		n := 0
+		for _, b := range in {
+			n += copy(ciphertext[n:], b)
+		}
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("recordioencrypt: block too short (%d bytes)", len(ciphertext))
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	out, err := aead.Open(scratch[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("recordioencrypt: %v", err)
+	}
+	return out, nil
+}
+
+var once = sync.Once{}
+
+// Init installs the AES-GCM transformer in recordio. It can be called multiple
+// times, but 2nd and later calls have no effect.
+func Init() {
+	once.Do(func() {
+		recordio.RegisterTransformer(
+			Name,
+			func(config string) (recordio.TransformFunc, error) {
+				aead, err := newAEAD(config)
+				if err != nil {
+					return nil, err
+				}
+				return func(scratch []byte, in [][]byte) ([]byte, error) {
+					return encrypt(aead, scratch, in)
+				}, nil
+			},
+			func(config string) (recordio.TransformFunc, error) {
+				aead, err := newAEAD(config)
+				if err != nil {
+					return nil, err
+				}
+				return func(scratch []byte, in [][]byte) ([]byte, error) {
+					return decrypt(aead, scratch, in)
+				}, nil
+			})
+	})
+}
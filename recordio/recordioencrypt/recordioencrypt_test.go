@@ -0,0 +1,102 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package recordioencrypt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"testing"
+
+	"github.com/grailbio/base/crypto/encryption"
+	"github.com/grailbio/base/recordio"
+	"github.com/grailbio/base/recordio/internal"
+	"github.com/grailbio/base/recordio/recordioencrypt"
+	"github.com/grailbio/base/recordio/recordiozstd"
+	"github.com/grailbio/testutil/assert"
+	"github.com/grailbio/testutil/encryptiontest"
+	"github.com/grailbio/testutil/expect"
+)
+
+const registryName = "recordioencrypt-test"
+
+func init() {
+	recordiozstd.Init()
+	recordioencrypt.Init()
+	if err := encryption.Register(registryName, encryptiontest.NewFakeAESRegistry()); err != nil {
+		panic(err)
+	}
+}
+
+func roundTrip(t *testing.T, transformers []string, items []string) ([]byte, []string) {
+	buf := &bytes.Buffer{}
+	w := recordio.NewWriter(buf, recordio.WriterOpts{
+		Transformers: transformers,
+		KeyTrailer:   true,
+		MaxItems:     10,
+	})
+	for _, item := range items {
+		w.Append([]byte(item))
+	}
+	w.SetTrailer([]byte("trailer"))
+	assert.NoError(t, w.Finish())
+
+	sc := recordio.NewScanner(bytes.NewReader(buf.Bytes()), recordio.ScannerOpts{})
+	expect.EQ(t, "trailer", string(sc.Trailer()))
+	var got []string
+	for sc.Scan() {
+		got = append(got, string(sc.Get().([]byte)))
+	}
+	assert.NoError(t, sc.Finish())
+	return buf.Bytes(), got
+}
+
+func TestEncrypt(t *testing.T) {
+	config := recordioencrypt.Config(encryption.KeyDescriptor{Registry: registryName, ID: encryptiontest.TestID})
+	expect.EQ(t, "aes-gcm recordioencrypt-test/30313233343536373839616263646566", config)
+
+	var items []string
+	for i := 0; i < 100; i++ {
+		items = append(items, fmt.Sprintf("secret item %d", i))
+	}
+	for _, transformers := range [][]string{
+		{config},
+		{recordiozstd.Name, config},
+	} {
+		data, got := roundTrip(t, transformers, items)
+		expect.EQ(t, items, got)
+		expect.False(t, bytes.Contains(data, []byte("secret")))
+	}
+}
+
+func TestEncryptErrors(t *testing.T) {
+	for _, config := range []string{
+		"aes-gcm noslash",
+		"aes-gcm " + registryName + "/nothex",
+		"aes-gcm nonexistent/3031",
+		"aes-gcm " + registryName + "/3031", // unknown key ID
+	} {
+		w := recordio.NewWriter(&bytes.Buffer{}, recordio.WriterOpts{Transformers: []string{config}})
+		expect.NotNil(t, w.Err(), config)
+	}
+
+	// Corrupt the ciphertext of the data block.
+	config := recordioencrypt.Config(encryption.KeyDescriptor{Registry: registryName, ID: encryptiontest.TestID})
+	buf := &bytes.Buffer{}
+	w := recordio.NewWriter(buf, recordio.WriterOpts{Transformers: []string{config}})
+	w.Append([]byte("item"))
+	assert.NoError(t, w.Finish())
+	data := buf.Bytes()
+	// Flip a bit in the payload of the data block, which starts at the second
+	// chunk, and fix up the chunk checksum so that only decryption fails.
+	chunk := data[internal.ChunkSize : 2*internal.ChunkSize]
+	size := binary.LittleEndian.Uint32(chunk[16:])
+	chunk[internal.ChunkHeaderSize+20] ^= 1
+	binary.LittleEndian.PutUint32(chunk[8:], crc32.ChecksumIEEE(chunk[12:internal.ChunkHeaderSize+size]))
+	sc := recordio.NewScanner(bytes.NewReader(data), recordio.ScannerOpts{})
+	expect.False(t, sc.Scan())
+	expect.HasSubstr(t, sc.Finish(), "authentication failed")
+}
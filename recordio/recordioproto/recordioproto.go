@@ -0,0 +1,43 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package recordioproto provides a recordio.Codec for protobuf messages. It is
+// kept separate from the recordio package so that recordio does not depend on
+// the proto library.
+package recordioproto
+
+import (
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Name is the codec name recorded in the recordio header.
+const Name = "proto"
+
+// Codec stores protobuf messages. T must be a pointer to a generated message
+// struct, e.g., *pb.Foo. It implements recordio.Codec[T].
+type Codec[T proto.Message] struct{}
+
+func (Codec[T]) new() T {
+	var v T
+	return reflect.New(reflect.TypeOf(v).Elem()).Interface().(T)
+}
+
+// Name implements recordio.Codec.
+func (Codec[T]) Name() string { return Name }
+
+// Schema implements recordio.Codec. It returns the fully qualified message
+// name.
+func (c Codec[T]) Schema() string { return proto.MessageName(c.new()) }
+
+// Marshal implements recordio.Codec.
+func (Codec[T]) Marshal(scratch []byte, v T) ([]byte, error) { return proto.Marshal(v) }
+
+// Unmarshal implements recordio.Codec.
+func (c Codec[T]) Unmarshal(data []byte) (T, error) {
+	v := c.new()
+	err := proto.Unmarshal(data, v)
+	return v, err
+}
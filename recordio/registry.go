@@ -137,6 +137,9 @@ func (r *registerer) GetUntransformer(h []string) (TransformFunc, error) {
 					data = data[:1]
 					data[0] = out
 				}
+				// The next transformer reads from out, which may be backed by
+				// scratch, so scratch cannot be reused.
+				scratch = nil
 			}
 			if len(data) != 1 { // At least one transformer should have run.
 				panic(data)
@@ -231,10 +231,52 @@ func NewShardScanner(in io.ReadSeeker, opts ScannerOpts, start, limit, nshard in
 		}
 		return newLegacyScannerAdapter(in, opts)
 	}
-	return newScanner(in, start, limit, nshard, opts)
+	return newScanner(in, opts, func(sc *internal.ChunkScanner) { sc.LimitShard(start, limit, nshard) })
 }
 
-func newScanner(in io.ReadSeeker, start, limit, nshard int, opts ScannerOpts) Scanner {
+// NewRangeScanner creates a new recordio scanner that reads the blocks that
+// begin in the byte range [start,limit) of the recordio file at the
+// ReadSeeker in. The scanner begins at the first block boundary at or after
+// start. Given a partition of the file into disjoint byte ranges, every item
+// is read by exactly one range scanner. Ranges are only supported for v2
+// recordio files; a legacy file can be read only if the range covers the
+// whole file.
+func NewRangeScanner(in io.ReadSeeker, opts ScannerOpts, start, limit int64) Scanner {
+	if opts.Unmarshal == nil {
+		opts.Unmarshal = idUnmarshal
+	}
+	size, err := in.Seek(0, io.SeekEnd)
+	if err != nil {
+		return &errorScanner{err}
+	}
+	if err := internal.Seek(in, 0); err != nil {
+		return &errorScanner{err}
+	}
+	if start < 0 || start > limit {
+		return &errorScanner{fmt.Errorf("invalid range [%d,%d)", start, limit)}
+	}
+	if start >= size || start == limit {
+		return &errorScanner{io.EOF}
+	}
+	var magic internal.MagicBytes
+	if _, err := io.ReadFull(in, magic[:]); err != nil {
+		return &errorScanner{err}
+	}
+	if err := internal.Seek(in, 0); err != nil {
+		return &errorScanner{err}
+	}
+	if magic != internal.MagicHeader {
+		if start != 0 || limit < size {
+			return &errorScanner{errors.New("legacy record IOs do not support sharding")}
+		}
+		return newLegacyScannerAdapter(in, opts)
+	}
+	return newScanner(in, opts, func(sc *internal.ChunkScanner) { sc.LimitRange(start, limit) })
+}
+
+// newScanner creates a v2 scanner. After reading the header, it calls limit to
+// restrict the range of blocks to scan.
+func newScanner(in io.ReadSeeker, opts ScannerOpts, limit func(*internal.ChunkScanner)) Scanner {
 	s := scannerFreePool.Get().(*scannerv2)
 	if s == nil {
 		panic("newScannerV2")
@@ -253,7 +295,7 @@ func newScanner(in io.ReadSeeker, start, limit, nshard int, opts ScannerOpts) Sc
 	}
 	// Technically, we shouldn't be reading the trailer again, but
 	// the block scanner just ignores it anyway.
-	s.sc.LimitShard(start, limit, nshard)
+	limit(s.sc)
 	return s
 }
 
@@ -289,18 +331,11 @@ func (s *scannerv2) readHeader() {
 		s.err.Set(err)
 		return
 	}
-	transformers := []string{}
-	for _, h := range s.header {
-		if h.Key == KeyTransformer {
-			str, ok := h.Value.(string)
-			if !ok {
-				s.err.Set(fmt.Errorf("Expect string value for key %v, but found %v", h.Key, h.Value))
-				return
-			}
-			transformers = append(transformers, str)
-		}
+	transformers, err := s.header.transformers()
+	if err != nil {
+		s.err.Set(err)
+		return
 	}
-	var err error
 	s.untransform, err = registry.GetUntransformer(transformers)
 	s.err.Set(err)
 }
@@ -0,0 +1,162 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package recordio
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/file"
+)
+
+// FileRange is a byte range [Start,Limit) of a recordio file. A range
+// consists of the blocks that begin in it; see NewRangeScanner.
+type FileRange struct {
+	// Path is the file's path, as passed to file.Open.
+	Path string
+	// Start and Limit are file offsets in bytes.
+	Start, Limit int64
+}
+
+// Shard is a list of file ranges to be read, in order, by one worker.
+type Shard []FileRange
+
+// PlanShards divides the given recordio files into n shards of roughly equal
+// total byte size. The files are conceptually concatenated in the given order
+// and cut at n-1 evenly spaced byte offsets, so a shard may span many small
+// files or a part of a large file. Every item in the files is read by
+// exactly one shard. Some shards may be empty if the files are small.
+//
+// File sizes are obtained by file.Stat.
+func PlanShards(ctx context.Context, paths []string, n int) ([]Shard, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("recordio.PlanShards: invalid number of shards %d", n)
+	}
+	sizes := make([]int64, len(paths))
+	var total int64
+	for i, path := range paths {
+		info, err := file.Stat(ctx, path)
+		if err != nil {
+			return nil, errors.E(err, "recordio.PlanShards", path)
+		}
+		sizes[i] = info.Size()
+		total += sizes[i]
+	}
+	shards := make([]Shard, n)
+	var (
+		fileIndex int
+		fileStart int64 // Offset of paths[fileIndex] in the concatenated files.
+	)
+	for i := range shards {
+		// Shard i covers [start, limit) of the concatenated files.
+		start := int64(float64(total) * float64(i) / float64(n))
+		limit := int64(float64(total) * float64(i+1) / float64(n))
+		if i == n-1 {
+			limit = total
+		}
+		for start < limit {
+			for fileIndex < len(paths) && fileStart+sizes[fileIndex] <= start {
+				fileStart += sizes[fileIndex]
+				fileIndex++
+			}
+			fileLimit := fileStart + sizes[fileIndex]
+			r := FileRange{Path: paths[fileIndex], Start: start - fileStart, Limit: sizes[fileIndex]}
+			if limit < fileLimit {
+				r.Limit = limit - fileStart
+			}
+			shards[i] = append(shards[i], r)
+			start = fileStart + r.Limit
+		}
+	}
+	return shards, nil
+}
+
+// MultiScanner reads the items in a Shard. Thread compatible.
+type MultiScanner struct {
+	ctx   context.Context
+	shard Shard
+	opts  ScannerOpts
+
+	next int // Index of the next range in shard to open.
+	path string
+	f    file.File
+	sc   Scanner
+	err  errors.Once
+}
+
+// NewMultiScanner creates a scanner that reads the ranges in the given shard
+// in order. Files are opened by file.Open one at a time, as they are reached.
+// Each file is read using NewRangeScanner with the given options.
+func NewMultiScanner(ctx context.Context, shard Shard, opts ScannerOpts) *MultiScanner {
+	return &MultiScanner{ctx: ctx, shard: shard, opts: opts}
+}
+
+// Scan returns true if a new item was read, false otherwise. It will return
+// false on encountering an error; the error may be retrieved using the Err
+// method.
+func (s *MultiScanner) Scan() bool {
+	for s.err.Err() == nil {
+		if s.sc != nil && s.sc.Scan() {
+			return true
+		}
+		if !s.closeCurrent() || s.next >= len(s.shard) {
+			return false
+		}
+		r := s.shard[s.next]
+		s.next++
+		f, err := file.Open(s.ctx, r.Path)
+		if err != nil {
+			s.err.Set(err)
+			return false
+		}
+		s.path, s.f = r.Path, f
+		s.sc = NewRangeScanner(f.Reader(s.ctx), s.opts, r.Start, r.Limit)
+	}
+	return false
+}
+
+// closeCurrent finishes the current scanner and closes its file. It returns
+// false on error.
+func (s *MultiScanner) closeCurrent() bool {
+	if s.sc != nil {
+		if err := s.sc.Finish(); err != nil {
+			s.err.Set(errors.E(err, s.path))
+		}
+		s.sc = nil
+	}
+	if s.f != nil {
+		if err := s.f.Close(s.ctx); err != nil {
+			s.err.Set(errors.E(err, s.path))
+		}
+		s.f = nil
+	}
+	return s.err.Err() == nil
+}
+
+// Get returns the current item as read by a prior call to Scan.
+//
+// REQUIRES: Preceding Scan calls have returned true.
+func (s *MultiScanner) Get() interface{} { return s.sc.Get() }
+
+// Path returns the path of the file being read.
+//
+// REQUIRES: Preceding Scan calls have returned true.
+func (s *MultiScanner) Path() string { return s.path }
+
+// Header returns the header of the file being read.
+//
+// REQUIRES: Preceding Scan calls have returned true.
+func (s *MultiScanner) Header() ParsedHeader { return s.sc.Header() }
+
+// Err returns any error encountered by the scanner.
+func (s *MultiScanner) Err() error { return s.err.Err() }
+
+// Finish closes the current file and returns the value of Err(). It should be
+// called exactly once, after the application has finished using the scanner.
+func (s *MultiScanner) Finish() error {
+	s.closeCurrent()
+	return s.err.Err()
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package recordio_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/grailbio/base/recordio"
+	"github.com/grailbio/testutil"
+	"github.com/grailbio/testutil/assert"
+	"github.com/grailbio/testutil/expect"
+)
+
+func TestPlanShards(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+
+	rnd := rand.New(rand.NewSource(0))
+	var (
+		paths []string
+		items []string
+	)
+	for i, nitems := range []int{0, 3, 1000, 10, 0, 5000, 1} {
+		path := filepath.Join(dir, fmt.Sprintf("%d.rio", i))
+		paths = append(paths, path)
+		buf := &bytes.Buffer{}
+		w := recordio.NewWriter(buf, recordio.WriterOpts{Marshal: marshalString, MaxItems: 100})
+		for j := 0; j < nitems; j++ {
+			item := fmt.Sprintf("%d.%d.%s", i, j, randomString(rnd.Intn(1000), rnd))
+			items = append(items, item)
+			w.Append(item)
+		}
+		assert.NoError(t, w.Finish())
+		assert.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0600))
+	}
+
+	for _, n := range []int{1, 2, 3, 7, 50, 500} {
+		shards, err := recordio.PlanShards(ctx, paths, n)
+		assert.NoError(t, err)
+		assert.EQ(t, len(shards), n)
+		var got []string
+		for _, shard := range shards {
+			sc := recordio.NewMultiScanner(ctx, shard, recordio.ScannerOpts{Unmarshal: unmarshalString})
+			for sc.Scan() {
+				got = append(got, sc.Get().(string))
+			}
+			assert.NoError(t, sc.Finish())
+		}
+		expect.EQ(t, len(got), len(items), "nshard=%d", n)
+		for i := range got {
+			if got[i] != items[i] {
+				t.Fatalf("nshard=%d, item %d: got %.10s, want %.10s", n, i, got[i], items[i])
+			}
+		}
+	}
+
+	_, err := recordio.PlanShards(ctx, []string{filepath.Join(dir, "nonexistent")}, 1)
+	expect.NotNil(t, err)
+}
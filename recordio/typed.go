@@ -0,0 +1,129 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package recordio
+
+import (
+	"fmt"
+	"io"
+)
+
+// TypedWriter is a Writer for items of type T. Items are serialized using a
+// Codec, whose name and schema fingerprint are recorded in the header so that
+// TypedScanner can verify that it decodes the right type. Thread safe.
+type TypedWriter[T any] struct {
+	w Writer
+}
+
+// NewTypedWriter creates a new writer for items of type T. Field opts.Marshal
+// is ignored; items are serialized using codec. Unless opts.SkipHeader is set,
+// the codec name and the schema fingerprint are added to the header.
+func NewTypedWriter[T any](wr io.Writer, codec Codec[T], opts WriterOpts) *TypedWriter[T] {
+	opts.Marshal = func(scratch []byte, v interface{}) ([]byte, error) {
+		return codec.Marshal(scratch, v.(T))
+	}
+	w := NewWriter(wr, opts)
+	if !opts.SkipHeader {
+		w.AddHeader(KeyCodec, codec.Name())
+		if schema := codec.Schema(); schema != "" {
+			w.AddHeader(KeySchema, SchemaFingerprint(schema))
+		}
+	}
+	return &TypedWriter[T]{w}
+}
+
+// AddHeader adds an arbitrary metadata to the file. See Writer.AddHeader.
+func (w *TypedWriter[T]) AddHeader(key string, value interface{}) { w.w.AddHeader(key, value) }
+
+// Append writes one item. See Writer.Append.
+func (w *TypedWriter[T]) Append(v T) { w.w.Append(v) }
+
+// Flush schedules to flush the current block. See Writer.Flush.
+func (w *TypedWriter[T]) Flush() { w.w.Flush() }
+
+// Wait blocks the caller until all the prior Flush calls finish.
+func (w *TypedWriter[T]) Wait() { w.w.Wait() }
+
+// SetTrailer adds an arbitrary data at the end of the file. See
+// Writer.SetTrailer.
+func (w *TypedWriter[T]) SetTrailer(data []byte) { w.w.SetTrailer(data) }
+
+// Err returns any error encountered by the writer.
+func (w *TypedWriter[T]) Err() error { return w.w.Err() }
+
+// Finish must be called at the end of writing. See Writer.Finish.
+func (w *TypedWriter[T]) Finish() error { return w.w.Finish() }
+
+// TypedScanner is a Scanner for items of type T. Thread safe.
+type TypedScanner[T any] struct {
+	sc  Scanner
+	err error
+}
+
+// NewTypedScanner creates a new scanner for items of type T. Field
+// opts.Unmarshal is ignored; items are deserialized using codec.
+//
+// If the header records a codec name or a schema fingerprint (see KeyCodec,
+// KeySchema), they must match the codec, or the scanner reports an error
+// through Err. Files that lack these entries, e.g., those written by a plain
+// Writer, are read without verification.
+func NewTypedScanner[T any](in io.ReadSeeker, codec Codec[T], opts ScannerOpts) *TypedScanner[T] {
+	opts.Unmarshal = func(data []byte) (interface{}, error) {
+		return codec.Unmarshal(data)
+	}
+	s := &TypedScanner[T]{sc: NewScanner(in, opts)}
+	header := s.sc.Header()
+	if v := header.value(KeyCodec); v != nil && v != codec.Name() {
+		s.err = fmt.Errorf("recordio: file was written with codec %v, but reading with codec %v", v, codec.Name())
+		return s
+	}
+	if v := header.value(KeySchema); v != nil {
+		if got := SchemaFingerprint(codec.Schema()); v != got {
+			s.err = fmt.Errorf("recordio: schema fingerprint mismatch: file has %v, but %q has %v",
+				v, codec.Schema(), got)
+		}
+	}
+	return s
+}
+
+// Header returns the contents of the header block.
+func (s *TypedScanner[T]) Header() ParsedHeader { return s.sc.Header() }
+
+// Scan reads the next item. See Scanner.Scan.
+func (s *TypedScanner[T]) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	return s.sc.Scan()
+}
+
+// Get returns the current item as read by a prior call to Scan.
+//
+// REQUIRES: Preceding Scan calls have returned true.
+func (s *TypedScanner[T]) Get() T { return s.sc.Get().(T) }
+
+// Err returns any error encountered by the scanner.
+func (s *TypedScanner[T]) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.sc.Err()
+}
+
+// Seek moves the read pointer so that the next Scan moves to the given
+// location. See Scanner.Seek.
+func (s *TypedScanner[T]) Seek(loc ItemLocation) { s.sc.Seek(loc) }
+
+// Trailer returns the trailer block contents. See Scanner.Trailer.
+func (s *TypedScanner[T]) Trailer() []byte { return s.sc.Trailer() }
+
+// Finish should be called exactly once, after the application has finished
+// using the scanner. It returns the value of Err().
+func (s *TypedScanner[T]) Finish() error {
+	err := s.sc.Finish()
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package recordio_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/grailbio/base/recordio"
+	"github.com/grailbio/base/recordio/recordioproto"
+	"github.com/grailbio/testutil/assert"
+	"github.com/grailbio/testutil/expect"
+)
+
+type typedItem struct {
+	Name  string
+	Count int
+}
+
+func typedRoundTrip[T any](t *testing.T, codec recordio.Codec[T], items []T) []T {
+	buf := &bytes.Buffer{}
+	w := recordio.NewTypedWriter(buf, codec, recordio.WriterOpts{Transformers: []string{"zstd"}})
+	for _, item := range items {
+		w.Append(item)
+	}
+	assert.NoError(t, w.Finish())
+
+	sc := recordio.NewTypedScanner(bytes.NewReader(buf.Bytes()), codec, recordio.ScannerOpts{})
+	expect.EQ(t, codec.Name(), sc.Header()[1].Value)
+	var got []T
+	for sc.Scan() {
+		got = append(got, sc.Get())
+	}
+	assert.NoError(t, sc.Finish())
+	return got
+}
+
+func TestTypedCodecs(t *testing.T) {
+	items := []typedItem{{"a", 1}, {"b", 2}}
+	expect.EQ(t, items, typedRoundTrip[typedItem](t, recordio.GobCodec[typedItem]{}, items))
+	expect.EQ(t, items, typedRoundTrip[typedItem](t, recordio.JSONCodec[typedItem]{}, items))
+
+	raw := [][]byte{[]byte("x"), []byte("yz")}
+	expect.EQ(t, raw, typedRoundTrip[[]byte](t, recordio.BytesCodec{}, raw))
+
+	protos := typedRoundTrip[*wrappers.StringValue](t, recordioproto.Codec[*wrappers.StringValue]{},
+		[]*wrappers.StringValue{{Value: "p0"}, {Value: "p1"}})
+	assert.EQ(t, len(protos), 2)
+	expect.EQ(t, "p0", protos[0].Value)
+	expect.EQ(t, "p1", protos[1].Value)
+}
+
+func TestTypedSchemaMismatch(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := recordio.NewTypedWriter[typedItem](buf, recordio.JSONCodec[typedItem]{}, recordio.WriterOpts{})
+	w.Append(typedItem{"a", 1})
+	assert.NoError(t, w.Finish())
+	expect.EQ(t, recordio.ParsedHeader{
+		{recordio.KeyCodec, "json"},
+		{recordio.KeySchema, recordio.SchemaFingerprint("github.com/grailbio/base/recordio_test.typedItem")},
+	}, recordio.NewScanner(bytes.NewReader(buf.Bytes()), recordio.ScannerOpts{}).Header())
+
+	sc := recordio.NewTypedScanner[typedItem](bytes.NewReader(buf.Bytes()), recordio.GobCodec[typedItem]{}, recordio.ScannerOpts{})
+	expect.False(t, sc.Scan())
+	expect.HasSubstr(t, sc.Finish(), "codec json")
+
+	sc2 := recordio.NewTypedScanner[map[string]int](bytes.NewReader(buf.Bytes()), recordio.JSONCodec[map[string]int]{}, recordio.ScannerOpts{})
+	expect.False(t, sc2.Scan())
+	expect.HasSubstr(t, sc2.Finish(), "schema fingerprint mismatch")
+
+	// Files written without a codec are read without verification.
+	buf.Reset()
+	pw := recordio.NewWriter(buf, recordio.WriterOpts{})
+	pw.Append([]byte("raw"))
+	assert.NoError(t, pw.Finish())
+	sc3 := recordio.NewTypedScanner[[]byte](bytes.NewReader(buf.Bytes()), recordio.BytesCodec{}, recordio.ScannerOpts{})
+	assert.True(t, sc3.Scan())
+	expect.EQ(t, "raw", string(sc3.Get()))
+	assert.NoError(t, sc3.Finish())
+}
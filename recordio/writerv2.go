@@ -84,6 +84,11 @@ type WriterOpts struct {
 	//  If " N" part is omitted or N=-1, the default compression level is used.
 	//  To use flate, import the 'recordioflate' package and call
 	//  'recordioflate.Init()' in an init() function.
+	//
+	//  "aes-gcm R/K": AES-GCM encryption using key ID K (hex-encoded) in the
+	//  crypto/encryption key registry R. To use aes-gcm, import the
+	//  'recordioencrypt' package and call 'recordioencrypt.Init()' in an init()
+	//  function.
 	Transformers []string
 
 	// MaxItems is the maximum number of items to pack into a single record.
@@ -230,6 +235,10 @@ type writerv2 struct {
 	state        writerState
 	header       ParsedHeader
 	curBodyBlock *writerv2Block
+
+	// prevTrailer is the trailer of the file being appended to. It is written
+	// by Finish unless SetTrailer is called. Set only by NewAppendWriter.
+	prevTrailer []byte
 }
 
 // For serializing block writes. Thread safe.
@@ -564,6 +573,9 @@ func (w *writerv2) Finish() error {
 		w.startFlushHeader()
 		w.state = wStateWritingBody
 	}
+	if w.state == wStateWritingBody && w.prevTrailer != nil {
+		w.SetTrailer(w.prevTrailer)
+	}
 	if w.state == wStateWritingBody {
 		if w.curBodyBlock != nil {
 			w.startFlushBodyBlock()
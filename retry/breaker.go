@@ -0,0 +1,234 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package retry
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/errors"
+)
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// Closed is the normal state, in which requests are allowed.
+	Closed BreakerState = iota
+	// Open is the state in which requests fail fast, entered after
+	// repeated failures.
+	Open
+	// HalfOpen is the state, entered after the breaker has been open for a
+	// while, in which a limited number of trial requests are allowed. A
+	// successful trial closes the breaker; a failed one opens it again.
+	HalfOpen
+)
+
+// String implements fmt.Stringer.
+func (s BreakerState) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerOpts configures a CircuitBreaker.
+type BreakerOpts struct {
+	// Failures is the number of consecutive failures after which the
+	// breaker opens. It defaults to 5.
+	Failures int
+	// OpenFor is the time for which the breaker stays open before it
+	// becomes half-open. It defaults to 30 seconds.
+	OpenFor time.Duration
+	// Trials is the number of concurrent trial requests allowed while the
+	// breaker is half-open. It defaults to 1.
+	Trials int
+	// IsFailure classifies the errors of requests. Errors for which it
+	// returns false, and nil errors, count as successes. By default, all
+	// errors are failures except those that are not the service's fault,
+	// such as invalid arguments, nonexistent resources or cancellation.
+	IsFailure func(error) bool
+}
+
+// A CircuitBreaker stops requests to a service that is failing, so that
+// clients fail fast instead of adding load to it, and lets requests through
+// again once the service recovers.
+//
+// Each request must first be allowed by Allow, and its outcome then
+// reported with the record function that Allow returns; Do does both.
+// WithCircuitBreaker applies a CircuitBreaker to a retry Policy.
+// CircuitBreakers are safe for concurrent use.
+type CircuitBreaker struct {
+	opts BreakerOpts
+	// now is used for faking time in tests.
+	now func() time.Time
+
+	mu    sync.Mutex
+	state BreakerState
+	// failures is the number of consecutive failures while closed.
+	failures int
+	// openedAt is the time at which the breaker last opened.
+	openedAt time.Time
+	// trials is the number of trial requests in progress while half-open.
+	trials int
+	// generation is incremented at every change of state, so that the
+	// outcomes of requests allowed in an earlier state are ignored.
+	generation uint64
+}
+
+// NewCircuitBreaker returns a new, closed, CircuitBreaker configured by
+// opts.
+func NewCircuitBreaker(opts BreakerOpts) *CircuitBreaker {
+	if opts.Failures <= 0 {
+		opts.Failures = 5
+	}
+	if opts.OpenFor <= 0 {
+		opts.OpenFor = 30 * time.Second
+	}
+	if opts.Trials <= 0 {
+		opts.Trials = 1
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = isServiceFailure
+	}
+	return &CircuitBreaker{opts: opts, now: time.Now}
+}
+
+// isServiceFailure tells whether err indicates a failure of the service,
+// rather than of the request or the caller.
+func isServiceFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch errors.Recover(err).Kind {
+	case errors.Canceled, errors.NotExist, errors.NotAllowed, errors.NotSupported,
+		errors.Exists, errors.Invalid, errors.Precondition:
+		return false
+	}
+	return true
+}
+
+// State returns the breaker's current state.
+func (c *CircuitBreaker) State() BreakerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stateLocked()
+}
+
+func (c *CircuitBreaker) stateLocked() BreakerState {
+	if c.state == Open && c.now().Sub(c.openedAt) >= c.opts.OpenFor {
+		c.setStateLocked(HalfOpen)
+		c.trials = 0
+	}
+	return c.state
+}
+
+// Allow tells whether a request may be made. If it may, Allow returns a
+// function with which the outcome of the request must be reported, once.
+// Otherwise, it returns an error of kind errors.Unavailable.
+//
+// Outcomes are ignored if the breaker has changed state since the request
+// was allowed: for example, a request allowed while closed that completes
+// after the breaker has become half-open is not taken for a trial.
+func (c *CircuitBreaker) Allow() (record func(err error), err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.stateLocked() {
+	case Open:
+		return nil, errors.E(errors.Unavailable, "circuit breaker open")
+	case HalfOpen:
+		if c.trials >= c.opts.Trials {
+			return nil, errors.E(errors.Unavailable, "circuit breaker half-open: trial in progress")
+		}
+		c.trials++
+	}
+	generation := c.generation
+	return func(err error) { c.record(generation, err) }, nil
+}
+
+// record records the outcome of a request allowed in the given generation.
+func (c *CircuitBreaker) record(generation uint64, err error) {
+	failed := c.opts.IsFailure(err)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stateLocked()
+	if generation != c.generation {
+		return
+	}
+	switch c.state {
+	case Closed:
+		if !failed {
+			c.failures = 0
+			return
+		}
+		c.failures++
+		if c.failures >= c.opts.Failures {
+			c.openLocked()
+		}
+	case HalfOpen:
+		if c.trials > 0 {
+			c.trials--
+		}
+		if failed {
+			c.openLocked()
+		} else {
+			c.setStateLocked(Closed)
+			c.failures = 0
+		}
+	}
+}
+
+func (c *CircuitBreaker) openLocked() {
+	c.setStateLocked(Open)
+	c.openedAt = c.now()
+	c.failures = 0
+}
+
+func (c *CircuitBreaker) setStateLocked(state BreakerState) {
+	c.state = state
+	c.generation++
+}
+
+// Do calls fn if the breaker allows it, and records its outcome. It returns
+// fn's error, or the breaker's if fn is not called.
+func (c *CircuitBreaker) Do(fn func() error) error {
+	record, err := c.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	record(err)
+	return err
+}
+
+type breakerPolicy struct {
+	policy  Policy
+	breaker *CircuitBreaker
+}
+
+// WithCircuitBreaker returns a policy that permits a retry only if breaker
+// is not open, and policy permits it, so that clients stop retrying requests
+// to a service that is failing.
+//
+// The returned policy only consults the breaker's state; it does not
+// report the outcomes of requests to it. Callers must make each attempt
+// through the breaker, for example with CircuitBreaker.Do, for failures to
+// open it.
+func WithCircuitBreaker(policy Policy, breaker *CircuitBreaker) Policy {
+	return &breakerPolicy{policy, breaker}
+}
+
+func (b *breakerPolicy) Retry(retries int) (bool, time.Duration) {
+	if b.breaker.State() == Open {
+		return false, 0
+	}
+	return b.policy.Retry(retries)
+}
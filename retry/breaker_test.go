@@ -0,0 +1,109 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package retry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/errors"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := newFakeClock()
+	c := NewCircuitBreaker(BreakerOpts{Failures: 2, OpenFor: time.Minute})
+	c.now = clock.Now
+	var (
+		errTemp = errors.E(errors.Unavailable, "unavailable")
+		errPerm = errors.E(errors.NotExist, "no such thing")
+	)
+	check := func(want BreakerState) {
+		t.Helper()
+		if got := c.State(); got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	fail := func(err error) error { return c.Do(func() error { return err }) }
+
+	// Permanent errors and successes do not open the breaker.
+	_ = fail(errTemp)
+	_ = fail(errPerm)
+	_ = fail(errTemp)
+	_ = fail(nil)
+	_ = fail(errTemp)
+	check(Closed)
+	_ = fail(errTemp)
+	check(Open)
+	if err := fail(nil); !errors.Is(errors.Unavailable, err) {
+		t.Errorf("got %v, want Unavailable", err)
+	}
+
+	// After OpenFor, a single trial is allowed; its failure reopens the
+	// breaker.
+	clock.Add(time.Minute)
+	check(HalfOpen)
+	record, err := c.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Allow(); !errors.Is(errors.Unavailable, err) {
+		t.Errorf("got %v, want Unavailable", err)
+	}
+	record(errTemp)
+	check(Open)
+
+	// A successful trial closes it.
+	clock.Add(time.Minute)
+	if err := fail(nil); err != nil {
+		t.Fatal(err)
+	}
+	check(Closed)
+}
+
+func TestWithCircuitBreaker(t *testing.T) {
+	clock := newFakeClock()
+	c := NewCircuitBreaker(BreakerOpts{Failures: 1, IsFailure: func(err error) bool { return err != nil }})
+	c.now = clock.Now
+	policy := WithCircuitBreaker(Backoff(time.Second, time.Second, 1), c)
+	if ok, wait := policy.Retry(0); !ok || wait != time.Second {
+		t.Fatalf("got %v, %v", ok, wait)
+	}
+	_ = c.Do(func() error { return errors.New("failed") })
+	if ok, _ := policy.Retry(1); ok {
+		t.Fatal("retry permitted while open")
+	}
+	clock.Add(time.Hour)
+	if ok, _ := policy.Retry(2); !ok {
+		t.Fatal("retry not permitted while half-open")
+	}
+}
+
+func TestCircuitBreakerGenerations(t *testing.T) {
+	clock := newFakeClock()
+	c := NewCircuitBreaker(BreakerOpts{Failures: 1, OpenFor: time.Minute})
+	c.now = clock.Now
+	errTemp := errors.E(errors.Unavailable, "unavailable")
+	// A request is allowed while closed, but completes only after the
+	// breaker has opened and become half-open.
+	stale, err := c.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Do(func() error { return errTemp })
+	clock.Add(time.Minute)
+	stale(nil)
+	if got, want := c.State(), HalfOpen; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// The trial is still available.
+	record, err := c.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(nil)
+	if got, want := c.State(), Closed; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
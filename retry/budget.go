@@ -0,0 +1,112 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package retry
+
+import (
+	"sync"
+	"time"
+)
+
+// budgetSlots is the number of slots into which a Budget's window is
+// divided. Counts expire one slot at a time.
+const budgetSlots = 10
+
+// A Budget caps the number of retries made by a client to a fraction of
+// its recent successful requests, so that, under a systemic outage,
+// retries do not multiply the load on the failing service.
+//
+// A Budget is shared by the requests it governs: they report successes
+// with Success, and retry only if TryRetry permits it. WithBudget applies
+// a Budget to a Policy. Budgets are safe for concurrent use.
+type Budget struct {
+	ratio      float64
+	minRetries int
+	slot       time.Duration
+	// now is used for faking time in tests.
+	now func() time.Time
+
+	mu sync.Mutex
+	// successes and retries are the counts in each slot of the window, a
+	// ring indexed by slot number.
+	successes, retries [budgetSlots]int
+	// current is the number of the current slot, since the zero time.
+	current int64
+}
+
+// NewBudget returns a Budget that permits, within any period of the given
+// window, minRetries retries, plus ratio retries for each successful
+// request. For example, NewBudget(0.1, 10, time.Minute) permits retrying
+// one in ten successful requests, and 10 retries per minute even when no
+// requests succeed.
+func NewBudget(ratio float64, minRetries int, window time.Duration) *Budget {
+	if window < budgetSlots {
+		panic("retry.NewBudget: window too small")
+	}
+	return &Budget{ratio: ratio, minRetries: minRetries, slot: window / budgetSlots, now: time.Now}
+}
+
+// Success records a successful request.
+func (b *Budget) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.successes[b.advanceLocked()]++
+}
+
+// TryRetry reports whether a retry is within the budget, and if so, records
+// it.
+func (b *Budget) TryRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := b.advanceLocked()
+	var successes, retries int
+	for j := range b.successes {
+		successes += b.successes[j]
+		retries += b.retries[j]
+	}
+	if float64(retries) >= float64(b.minRetries)+b.ratio*float64(successes) {
+		return false
+	}
+	b.retries[i]++
+	return true
+}
+
+// advanceLocked expires the slots that have fallen out of the window, and
+// returns the index of the current slot.
+func (b *Budget) advanceLocked() int {
+	slot := b.now().UnixNano() / int64(b.slot)
+	if n := slot - b.current; n > 0 {
+		if n > budgetSlots {
+			n = budgetSlots
+		}
+		for i := int64(1); i <= n; i++ {
+			j := (slot - n + i) % budgetSlots
+			b.successes[j] = 0
+			b.retries[j] = 0
+		}
+		b.current = slot
+	}
+	return int(b.current % budgetSlots)
+}
+
+type budgetPolicy struct {
+	policy Policy
+	budget *Budget
+}
+
+// WithBudget returns a policy that permits a retry only if policy permits
+// it, and it is within budget. Only retries that policy permits are charged
+// to the budget, so that requests that give up do not deplete it for
+// others.
+func WithBudget(policy Policy, budget *Budget) Policy {
+	return &budgetPolicy{policy, budget}
+}
+
+func (b *budgetPolicy) Retry(retries int) (bool, time.Duration) {
+	keepgoing, wait := b.policy.Retry(retries)
+	if !keepgoing || !b.budget.TryRetry() {
+		return false, 0
+	}
+	return true, wait
+}
@@ -0,0 +1,92 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package retry
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable clock for tests.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time      { return c.t }
+func (c *fakeClock) Add(d time.Duration) { c.t = c.t.Add(d) }
+func newFakeClock() *fakeClock           { return &fakeClock{time.Unix(1600000000, 0)} }
+
+func TestBudget(t *testing.T) {
+	clock := newFakeClock()
+	b := NewBudget(0.5, 1, 10*time.Second)
+	b.now = clock.Now
+	if !b.TryRetry() {
+		t.Fatal("minimum retry not permitted")
+	}
+	if b.TryRetry() {
+		t.Fatal("retry over budget permitted")
+	}
+	for i := 0; i < 4; i++ {
+		b.Success()
+	}
+	// 1 + 0.5*4 = 3 retries are permitted.
+	for i := 0; i < 2; i++ {
+		if !b.TryRetry() {
+			t.Fatalf("retry %d not permitted", i)
+		}
+	}
+	if b.TryRetry() {
+		t.Fatal("retry over budget permitted")
+	}
+	// 1 + 0.5*8 = 5 retries are permitted.
+	clock.Add(5 * time.Second)
+	for i := 0; i < 4; i++ {
+		b.Success()
+	}
+	for i := 0; i < 2; i++ {
+		if !b.TryRetry() {
+			t.Fatalf("retry %d not permitted", i)
+		}
+	}
+	if b.TryRetry() {
+		t.Fatal("retry over budget permitted")
+	}
+	// The first 4 successes and 3 retries expire from the window, leaving
+	// 2 of 3 retries.
+	clock.Add(6 * time.Second)
+	if !b.TryRetry() {
+		t.Fatal("retry not permitted")
+	}
+	if b.TryRetry() {
+		t.Fatal("retry over budget permitted")
+	}
+	clock.Add(time.Hour)
+	if !b.TryRetry() {
+		t.Fatal("retry not permitted after window")
+	}
+}
+
+func TestWithBudget(t *testing.T) {
+	b := NewBudget(0, 2, time.Minute)
+	policy := WithBudget(MaxRetries(nil, 10), b)
+	for retries := 0; retries < 2; retries++ {
+		if ok, _ := policy.Retry(retries); !ok {
+			t.Fatalf("retry %d not permitted", retries)
+		}
+	}
+	if ok, _ := policy.Retry(2); ok {
+		t.Fatal("retry over budget permitted")
+	}
+
+	// Retries that the wrapped policy refuses are not charged.
+	b = NewBudget(0, 1, time.Minute)
+	policy = WithBudget(MaxRetries(nil, 1), b)
+	for i := 0; i < 3; i++ {
+		if ok, _ := policy.Retry(1); ok {
+			t.Fatal("retry over policy limit permitted")
+		}
+	}
+	if ok, _ := policy.Retry(0); !ok {
+		t.Fatal("retry within budget not permitted")
+	}
+}
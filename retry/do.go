@@ -0,0 +1,147 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package retry
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grailbio/base/errors"
+)
+
+// Option is an option for Do.
+type Option func(*doOpts)
+
+type doOpts struct {
+	isRetriable    func(error) bool
+	onRetry        func(retry int, err error, wait time.Duration)
+	attemptTimeout time.Duration
+	maxElapsed     time.Duration
+}
+
+// Retriable makes Do retry only errors for which fn returns true. By
+// default, Do retries all errors.
+func Retriable(fn func(error) bool) Option {
+	return func(o *doOpts) { o.isRetriable = fn }
+}
+
+// OnRetry makes Do call fn before it waits to retry, with the retry number,
+// the error of the failed attempt, and the time it will wait. It may be used
+// for logging or metrics.
+func OnRetry(fn func(retry int, err error, wait time.Duration)) Option {
+	return func(o *doOpts) { o.onRetry = fn }
+}
+
+// AttemptTimeout makes Do give each attempt a context that times out after
+// d. An attempt that times out may be retried.
+func AttemptTimeout(d time.Duration) Option {
+	return func(o *doOpts) { o.attemptTimeout = d }
+}
+
+// MaxElapsed makes Do give up once d has elapsed since its first attempt,
+// including the time spent in attempts and waiting between them.
+func MaxElapsed(d time.Duration) Option {
+	return func(o *doOpts) { o.maxElapsed = d }
+}
+
+// Do calls fn until it succeeds, retrying its errors as permitted by policy,
+// and returns its result. Do gives up when policy does not permit another
+// retry, when an error is not retriable, or when ctx is done, and then
+// returns an error that has the kind of the reason it gave up, and wraps
+// the errors of all attempts, as Errors.
+//
+// Do replaces WaitForFn, e.g.:
+//
+//	obj, err := retry.Do(ctx, policy, func(ctx context.Context) (*Object, error) {
+//		return client.Get(ctx, key)
+//	}, retry.Retriable(errors.IsTemporary))
+func Do[T any](ctx context.Context, policy Policy, fn func(context.Context) (T, error), opts ...Option) (T, error) {
+	var o doOpts
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.maxElapsed > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.maxElapsed)
+		defer cancel()
+	}
+	var (
+		zero T
+		errs Errors
+	)
+	for retries := 0; ; retries++ {
+		v, err := attempt(ctx, fn, o.attemptTimeout)
+		if err == nil {
+			return v, nil
+		}
+		errs = append(errs, err)
+		if o.isRetriable != nil && !o.isRetriable(err) {
+			e := errors.Recover(err)
+			return zero, errors.E(e.Kind, e.Severity, fmt.Sprintf("gave up after %d attempts: error not retriable", len(errs)), errs)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return zero, errors.E(errors.Recover(ctxErr).Kind, fmt.Sprintf("gave up after %d attempts", len(errs)), errs)
+		}
+		keepgoing, wait := policy.Retry(retries)
+		if keepgoing && o.onRetry != nil {
+			o.onRetry(retries, err, wait)
+		}
+		if werr := waitFor(ctx, retries, keepgoing, wait); werr != nil {
+			return zero, errors.E(errors.Recover(werr).Kind, fmt.Sprintf("gave up after %d attempts", len(errs)), errs)
+		}
+	}
+}
+
+// attempt calls fn once, with a context that times out after timeout, if
+// it is positive.
+func attempt[T any](ctx context.Context, fn func(context.Context) (T, error), timeout time.Duration) (T, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return fn(ctx)
+}
+
+// Errors are the errors of the attempts of a retried operation, in order.
+// Errors unwraps to the last error, and the standard library's errors.Is
+// reports whether any of the errors matches its target.
+type Errors []error
+
+// Error implements error.
+func (e Errors) Error() string {
+	var b strings.Builder
+	b.WriteString("[")
+	for i, err := range e {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "attempt %d: %v", i+1, err)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// Unwrap returns the last error.
+func (e Errors) Unwrap() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e[len(e)-1]
+}
+
+// Is tells whether any of the errors matches target, as determined by the
+// standard library's errors.Is.
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if stderrors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package retry
+
+import (
+	"context"
+	stderrors "errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDo(t *testing.T) {
+	var (
+		calls   int
+		retries []int
+	)
+	v, err := Do(context.Background(), MaxRetries(nil, 5), func(ctx context.Context) (string, error) {
+		calls++
+		if calls < 3 {
+			return "", errors.E(errors.Unavailable, "try again")
+		}
+		return "ok", nil
+	}, OnRetry(func(retry int, err error, wait time.Duration) {
+		require.True(t, errors.Is(errors.Unavailable, err))
+		retries = append(retries, retry)
+	}))
+	require.NoError(t, err)
+	require.Equal(t, "ok", v)
+	require.Equal(t, 3, calls)
+	require.Equal(t, []int{0, 1}, retries)
+}
+
+func TestDoTooManyTries(t *testing.T) {
+	var calls int
+	_, err := Do(context.Background(), MaxRetries(nil, 2), func(ctx context.Context) (int, error) {
+		calls++
+		if calls == 1 {
+			return 0, os.ErrNotExist
+		}
+		return 0, errors.E(errors.Unavailable, "try again")
+	})
+	require.Equal(t, 3, calls)
+	require.True(t, errors.Is(errors.TooManyTries, err), "got %v", err)
+	var errs Errors
+	require.True(t, stderrors.As(err, &errs))
+	require.Len(t, errs, 3)
+	// All attempts' errors are wrapped.
+	require.True(t, stderrors.Is(err, os.ErrNotExist))
+}
+
+func TestDoNotRetriable(t *testing.T) {
+	var calls int
+	_, err := Do(context.Background(), MaxRetries(nil, 5), func(ctx context.Context) (int, error) {
+		calls++
+		if calls == 1 {
+			return 0, errors.E(errors.Unavailable, "try again", errors.Temporary)
+		}
+		return 0, errors.E(errors.NotExist, "no such thing")
+	}, Retriable(errors.IsTemporary))
+	require.Equal(t, 2, calls)
+	require.True(t, errors.Is(errors.NotExist, err), "got %v", err)
+	require.False(t, errors.IsTemporary(err))
+}
+
+func TestDoAttemptTimeout(t *testing.T) {
+	var calls int
+	v, err := Do(context.Background(), MaxRetries(nil, 3), func(ctx context.Context) (int, error) {
+		calls++
+		if calls == 1 {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		return calls, nil
+	}, AttemptTimeout(10*time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, 2, v)
+}
+
+func TestDoMaxElapsed(t *testing.T) {
+	var calls int
+	start := time.Now()
+	_, err := Do(context.Background(), Backoff(20*time.Millisecond, 20*time.Millisecond, 1), func(ctx context.Context) (int, error) {
+		calls++
+		return 0, errors.E(errors.Unavailable, "try again")
+	}, MaxElapsed(50*time.Millisecond))
+	require.True(t, errors.Is(errors.Timeout, err), "got %v", err)
+	require.True(t, calls >= 2 && calls <= 3, "got %d calls", calls)
+	require.True(t, time.Since(start) < time.Second)
+}
+
+func TestDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := Do(ctx, Backoff(time.Hour, time.Hour, 1), func(ctx context.Context) (int, error) {
+		cancel()
+		return 0, errors.E(errors.Unavailable, "try again")
+	})
+	require.True(t, errors.Is(errors.Canceled, err), "got %v", err)
+}
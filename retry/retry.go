@@ -32,6 +32,12 @@ type Policy interface {
 // next try.
 func Wait(ctx context.Context, policy Policy, retry int) error {
 	keepgoing, wait := policy.Retry(retry)
+	return waitFor(ctx, retry, keepgoing, wait)
+}
+
+// waitFor sleeps for wait, if keepgoing, as Wait does for the policy's
+// decision at the provided retry number.
+func waitFor(ctx context.Context, retry int, keepgoing bool, wait time.Duration) error {
 	if !keepgoing {
 		return errors.E(errors.TooManyTries, fmt.Sprintf("gave up after %d tries", retry))
 	}
@@ -50,6 +56,8 @@ func Wait(ctx context.Context, policy Policy, retry int) error {
 // number and generalizes it for a use of a function. Just like Wait it
 // errors in the cases of extra tries, context cancel, or if its deadline
 // runs out waiting for the next try
+//
+// Deprecated: use Do, which is typed.
 func WaitForFn(ctx context.Context, policy Policy, fn interface{}, params ...interface{}) (result []reflect.Value) {
 	var out []reflect.Value
 	f := reflect.ValueOf(fn)
@@ -0,0 +1,179 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package ctxsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grailbio/base/diagnostic/dump"
+	"github.com/grailbio/base/log"
+)
+
+// deadlockThreshold is the deadlock debugging threshold, in nanoseconds, or
+// 0 if deadlock debugging is disabled.
+var deadlockThreshold int64
+
+var registerDumpOnce sync.Once
+
+// SetDeadlockDebug enables deadlock debugging for all of the primitives in
+// this package, or disables it if threshold is 0. While enabled, each
+// primitive records the stacks of its current holders. When a goroutine
+// waits longer than threshold to acquire a primitive, the stacks of the
+// primitive's holders and of the waiter are logged; they are also included,
+// along with all other current holders and stalled waiters, in the
+// "ctxsync" part of the dump served by package diagnostic/dump.
+//
+// Recording stacks is expensive, so deadlock debugging is intended for
+// development and troubleshooting. Primitives acquired before debugging is
+// enabled are not tracked until they are next acquired.
+func SetDeadlockDebug(threshold time.Duration) {
+	atomic.StoreInt64(&deadlockThreshold, int64(threshold))
+	if threshold > 0 {
+		registerDumpOnce.Do(func() {
+			dump.Register("ctxsync", writeDebugDump)
+		})
+	}
+}
+
+func debugThreshold() time.Duration {
+	return time.Duration(atomic.LoadInt64(&deadlockThreshold))
+}
+
+// holder is a record of an acquisition of a primitive.
+type holder struct {
+	weight int64
+	since  time.Time
+	stack  []byte
+}
+
+// A tracker records the holders of a primitive while deadlock debugging is
+// enabled. The zero value is ready to use.
+type tracker struct {
+	mu      sync.Mutex
+	holders []holder
+}
+
+// trackers contains the trackers that have holders, and their primitives'
+// names; stalled contains the waiters that have exceeded the threshold.
+var (
+	trackersMu sync.Mutex
+	trackers   = map[*tracker]string{}
+	stalled    = map[*stall]bool{}
+)
+
+type stall struct {
+	name  string
+	since time.Time
+	stack []byte
+}
+
+// primitiveName returns a name identifying the primitive p of the given
+// kind.
+func primitiveName(kind string, p interface{}) string {
+	return fmt.Sprintf("%s %p", kind, p)
+}
+
+// acquired records that the calling goroutine acquired weight units of the
+// primitive p of the given kind.
+func (t *tracker) acquired(kind string, p interface{}, weight int64) {
+	if debugThreshold() == 0 {
+		return
+	}
+	name := primitiveName(kind, p)
+	h := holder{weight, time.Now(), debug.Stack()}
+	t.mu.Lock()
+	t.holders = append(t.holders, h)
+	t.mu.Unlock()
+	trackersMu.Lock()
+	trackers[t] = name
+	trackersMu.Unlock()
+}
+
+// released records the release of weight units. Since primitives may be
+// released by goroutines other than those that acquired them, the oldest
+// holder with the given weight is assumed to be released.
+func (t *tracker) released(weight int64) {
+	t.mu.Lock()
+	if len(t.holders) == 0 {
+		t.mu.Unlock()
+		return
+	}
+	for i, h := range t.holders {
+		if h.weight == weight {
+			t.holders = append(t.holders[:i], t.holders[i+1:]...)
+			break
+		}
+	}
+	empty := len(t.holders) == 0
+	t.mu.Unlock()
+	if empty {
+		trackersMu.Lock()
+		delete(trackers, t)
+		trackersMu.Unlock()
+	}
+}
+
+// wait is called before the calling goroutine waits for the primitive p of
+// the given kind. The returned function must be called when the wait ends.
+func (t *tracker) wait(kind string, p interface{}) (done func()) {
+	threshold := debugThreshold()
+	if threshold == 0 {
+		return func() {}
+	}
+	name := primitiveName(kind, p)
+	s := &stall{name: name, since: time.Now(), stack: debug.Stack()}
+	timer := time.AfterFunc(threshold, func() {
+		trackersMu.Lock()
+		stalled[s] = true
+		trackersMu.Unlock()
+		var b strings.Builder
+		fmt.Fprintf(&b, "ctxsync: goroutine waited more than %v for %s\nwaiter:\n%s", threshold, name, s.stack)
+		t.writeHolders(&b)
+		log.Error.Print(b.String())
+	})
+	return func() {
+		timer.Stop()
+		trackersMu.Lock()
+		delete(stalled, s)
+		trackersMu.Unlock()
+	}
+}
+
+func (t *tracker) writeHolders(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, h := range t.holders {
+		fmt.Fprintf(w, "holder (weight %d, held for %v):\n%s", h.weight, time.Since(h.since), h.stack)
+	}
+}
+
+// writeDebugDump writes the holders of all tracked primitives, and all
+// stalled waiters.
+func writeDebugDump(_ context.Context, w io.Writer) error {
+	trackersMu.Lock()
+	defer trackersMu.Unlock()
+	var stalls []*stall
+	for s := range stalled {
+		stalls = append(stalls, s)
+	}
+	sort.Slice(stalls, func(i, j int) bool { return stalls[i].since.Before(stalls[j].since) })
+	for _, s := range stalls {
+		fmt.Fprintf(w, "stalled waiter for %s (waiting for %v):\n%s\n", s.name, time.Since(s.since), s.stack)
+	}
+	for t, name := range trackers {
+		fmt.Fprintf(w, "%s:\n", name)
+		t.writeHolders(w)
+		fmt.Fprintln(w)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package ctxsync
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lockAndHold(mu *Mutex) {
+	if err := mu.Lock(context.Background()); err != nil {
+		panic(err)
+	}
+}
+
+func TestDeadlockDebug(t *testing.T) {
+	SetDeadlockDebug(5 * time.Millisecond)
+	defer SetDeadlockDebug(0)
+	var mu Mutex
+	lockAndHold(&mu)
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+	go func() { errC <- mu.Lock(ctx) }()
+	var dump strings.Builder
+	for !strings.Contains(dump.String(), "stalled waiter") {
+		time.Sleep(time.Millisecond)
+		dump.Reset()
+		require.NoError(t, writeDebugDump(context.Background(), &dump))
+	}
+	assert.Contains(t, dump.String(), "ctxsync.Mutex")
+	// The holder's stack is recorded.
+	assert.Contains(t, dump.String(), "lockAndHold")
+	cancel()
+	assert.Error(t, <-errC)
+
+	mu.Unlock()
+	dump.Reset()
+	require.NoError(t, writeDebugDump(context.Background(), &dump))
+	assert.Empty(t, dump.String())
+}
@@ -16,6 +16,7 @@ import (
 type Mutex struct {
 	initOnce sync.Once
 	lockCh   chan struct{}
+	tracker  tracker
 }
 
 // Lock attempts to exclusively lock m.  If the m is already locked, it will
@@ -25,6 +26,15 @@ func (m *Mutex) Lock(ctx context.Context) error {
 	m.init()
 	select {
 	case m.lockCh <- struct{}{}:
+		m.tracker.acquired("ctxsync.Mutex", m, 1)
+		return nil
+	default:
+	}
+	done := m.tracker.wait("ctxsync.Mutex", m)
+	defer done()
+	select {
+	case m.lockCh <- struct{}{}:
+		m.tracker.acquired("ctxsync.Mutex", m, 1)
 		return nil
 	case <-ctx.Done():
 		return errors.E(ctx.Err(), "waiting for lock")
@@ -37,6 +47,7 @@ func (m *Mutex) Unlock() {
 	m.init()
 	select {
 	case <-m.lockCh:
+		m.tracker.released(1)
 	default:
 		panic("Unlock called on mutex that is not locked")
 	}
@@ -0,0 +1,67 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package ctxsync
+
+import (
+	"context"
+	"sync"
+
+	"github.com/grailbio/base/errors"
+)
+
+// rwMaxReaders is the maximum number of concurrent readers of an RWMutex.
+const rwMaxReaders = 1 << 30
+
+// RWMutex is a context-aware reader/writer mutex. Waiters are served in FIFO
+// order, so a waiting writer blocks readers that arrive after it. It must
+// not be copied. The zero value is ready to use.
+type RWMutex struct {
+	initOnce sync.Once
+	sem      fifoSem
+}
+
+// Lock attempts to exclusively lock m. If m is already locked for reading or
+// writing, it waits until m is unlocked. If ctx is canceled before the lock
+// can be taken, Lock does not take the lock, and a non-nil error is
+// returned.
+func (m *RWMutex) Lock(ctx context.Context) error {
+	m.init()
+	if err := m.sem.acquire(ctx, rwMaxReaders, "ctxsync.RWMutex", m); err != nil {
+		return errors.E(err, "waiting for lock")
+	}
+	return nil
+}
+
+// Unlock unlocks m for writing. It must be called exactly once iff Lock
+// returns nil.
+func (m *RWMutex) Unlock() {
+	m.init()
+	m.sem.release(rwMaxReaders)
+}
+
+// RLock attempts to lock m for reading. If m is locked for writing, or a
+// writer is waiting, it waits until the writer has unlocked m. If ctx is
+// canceled before the lock can be taken, RLock does not take the lock, and
+// a non-nil error is returned.
+func (m *RWMutex) RLock(ctx context.Context) error {
+	m.init()
+	if err := m.sem.acquire(ctx, 1, "ctxsync.RWMutex", m); err != nil {
+		return errors.E(err, "waiting for read lock")
+	}
+	return nil
+}
+
+// RUnlock undoes a single RLock call. It must be called exactly once iff
+// RLock returns nil.
+func (m *RWMutex) RUnlock() {
+	m.init()
+	m.sem.release(1)
+}
+
+func (m *RWMutex) init() {
+	m.initOnce.Do(func() {
+		m.sem.size = rwMaxReaders
+	})
+}
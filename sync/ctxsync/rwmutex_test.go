@@ -0,0 +1,72 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package ctxsync_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/sync/ctxsync"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRWMutex verifies that readers share the lock, and writers hold it
+// exclusively.
+func TestRWMutex(t *testing.T) {
+	var (
+		mu               ctxsync.RWMutex
+		wg               sync.WaitGroup
+		readers, writers int32
+		maxReaders       int32
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, mu.RLock(context.Background()))
+			n := atomic.AddInt32(&readers, 1)
+			if n > atomic.LoadInt32(&maxReaders) {
+				atomic.StoreInt32(&maxReaders, n)
+			}
+			assert.Equal(t, int32(0), atomic.LoadInt32(&writers))
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&readers, -1)
+			mu.RUnlock()
+		}()
+		go func() {
+			defer wg.Done()
+			require.NoError(t, mu.Lock(context.Background()))
+			assert.Equal(t, int32(1), atomic.AddInt32(&writers, 1))
+			assert.Equal(t, int32(0), atomic.LoadInt32(&readers))
+			atomic.AddInt32(&writers, -1)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+// TestRWMutexCancel verifies that lock attempts are abandoned when their
+// contexts are canceled.
+func TestRWMutexCancel(t *testing.T) {
+	var mu ctxsync.RWMutex
+	require.NoError(t, mu.RLock(context.Background()))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, mu.Lock(ctx))
+	// The abandoned writer must not block readers.
+	require.NoError(t, mu.RLock(context.Background()))
+	mu.RUnlock()
+	mu.RUnlock()
+	require.NoError(t, mu.Lock(context.Background()))
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, mu.RLock(ctx))
+	mu.Unlock()
+	assert.Panics(t, mu.Unlock)
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package ctxsync
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/grailbio/base/errors"
+)
+
+// Semaphore is a context-aware weighted semaphore. Waiters are served in
+// FIFO order: a waiter for a large weight blocks later waiters, even if
+// those could be satisfied, so that large acquisitions are not starved.
+type Semaphore struct {
+	sem fifoSem
+}
+
+// NewSemaphore returns a semaphore with the given total weight.
+func NewSemaphore(n int64) *Semaphore {
+	return &Semaphore{sem: fifoSem{size: n}}
+}
+
+// Acquire acquires weight n, blocking until it is available. If ctx is
+// canceled first, Acquire does not acquire any weight, and returns a non-nil
+// error.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	if n > s.sem.size {
+		return errors.E(errors.Invalid, fmt.Sprintf("acquiring %d of semaphore of size %d", n, s.sem.size))
+	}
+	if err := s.sem.acquire(ctx, n, "ctxsync.Semaphore", s); err != nil {
+		return errors.E(err, "waiting for semaphore")
+	}
+	return nil
+}
+
+// TryAcquire acquires weight n without blocking. It returns false, and does
+// not acquire any weight, if n is not immediately available.
+func (s *Semaphore) TryAcquire(n int64) bool {
+	return s.sem.tryAcquire(n, "ctxsync.Semaphore", s)
+}
+
+// Release releases weight n. It panics if more weight is released than is
+// held.
+func (s *Semaphore) Release(n int64) {
+	s.sem.release(n)
+}
+
+// fifoSem implements a weighted semaphore with FIFO waiters. It is the basis
+// of Semaphore and RWMutex.
+type fifoSem struct {
+	size int64
+
+	mu      sync.Mutex
+	cur     int64
+	waiters list.List // of *semWaiter
+	tracker tracker
+}
+
+type semWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+func (s *fifoSem) tryAcquire(n int64, kind string, p interface{}) bool {
+	s.mu.Lock()
+	ok := s.size-s.cur >= n && s.waiters.Len() == 0
+	if ok {
+		s.cur += n
+	}
+	s.mu.Unlock()
+	if ok {
+		s.tracker.acquired(kind, p, n)
+	}
+	return ok
+}
+
+func (s *fifoSem) acquire(ctx context.Context, n int64, kind string, p interface{}) error {
+	if s.tryAcquire(n, kind, p) {
+		return nil
+	}
+	s.mu.Lock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		// Released since tryAcquire.
+		s.cur += n
+		s.mu.Unlock()
+		s.tracker.acquired(kind, p, n)
+		return nil
+	}
+	w := &semWaiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	done := s.tracker.wait(kind, p)
+	defer done()
+	select {
+	case <-w.ready:
+		s.tracker.acquired(kind, p, n)
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// Acquired after cancellation: give it back.
+			s.cur -= n
+			s.notifyLocked()
+		default:
+			isFront := s.waiters.Front() == elem
+			s.waiters.Remove(elem)
+			if isFront {
+				// Waiters behind this one may now be satisfiable.
+				s.notifyLocked()
+			}
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *fifoSem) release(n int64) {
+	s.mu.Lock()
+	s.cur -= n
+	if s.cur < 0 {
+		s.mu.Unlock()
+		panic("ctxsync: released more than held")
+	}
+	s.notifyLocked()
+	s.mu.Unlock()
+	s.tracker.released(n)
+}
+
+// notifyLocked grants weight to waiters, in order, while it is available.
+func (s *fifoSem) notifyLocked() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*semWaiter)
+		if s.size-s.cur < w.n {
+			return
+		}
+		s.cur += w.n
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package ctxsync_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/sync/ctxsync"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSemaphoreLimit verifies that the total weight held never exceeds the
+// semaphore's size.
+func TestSemaphoreLimit(t *testing.T) {
+	const size = 10
+	var (
+		sem       = ctxsync.NewSemaphore(size)
+		wg        sync.WaitGroup
+		held, max int64
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(n int64) {
+			defer wg.Done()
+			require.NoError(t, sem.Acquire(context.Background(), n))
+			cur := atomic.AddInt64(&held, n)
+			for {
+				m := atomic.LoadInt64(&max)
+				if cur <= m || atomic.CompareAndSwapInt64(&max, m, cur) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&held, -n)
+			sem.Release(n)
+		}(int64(1 + i%size))
+	}
+	wg.Wait()
+	assert.True(t, max <= size, "held %d > %d", max, size)
+	assert.True(t, sem.TryAcquire(size))
+}
+
+// TestSemaphoreFIFO verifies that a large waiter is not starved by later,
+// smaller waiters.
+func TestSemaphoreFIFO(t *testing.T) {
+	sem := ctxsync.NewSemaphore(2)
+	require.NoError(t, sem.Acquire(context.Background(), 1))
+	acquired := make(chan struct{})
+	go func() {
+		require.NoError(t, sem.Acquire(context.Background(), 2))
+		close(acquired)
+	}()
+	// Wait for the large waiter to queue.
+	for sem.TryAcquire(1) {
+		sem.Release(1)
+		time.Sleep(time.Millisecond)
+	}
+	assert.False(t, sem.TryAcquire(1), "later waiter jumped the queue")
+	sem.Release(1)
+	<-acquired
+	sem.Release(2)
+}
+
+// TestSemaphoreCancel verifies that a canceled waiter acquires nothing and
+// does not block the waiters behind it.
+func TestSemaphoreCancel(t *testing.T) {
+	sem := ctxsync.NewSemaphore(2)
+	require.NoError(t, sem.Acquire(context.Background(), 1))
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+	go func() { errC <- sem.Acquire(ctx, 2) }()
+	for sem.TryAcquire(1) {
+		sem.Release(1)
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	assert.Error(t, <-errC)
+	assert.True(t, sem.TryAcquire(1))
+	sem.Release(2)
+	assert.Error(t, sem.Acquire(context.Background(), 3))
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package ctxsync
+
+import (
+	"context"
+	"sync"
+
+	"github.com/grailbio/base/errors"
+)
+
+// WaitGroup is a sync.WaitGroup whose Wait honors a context. It must not be
+// copied. The zero value is ready to use.
+type WaitGroup struct {
+	mu    sync.Mutex
+	n     int
+	doneC chan struct{}
+}
+
+// Add adds delta, which may be negative, to the counter. If the counter
+// becomes zero, all goroutines blocked on Wait are released. Add panics if
+// the counter becomes negative.
+func (wg *WaitGroup) Add(delta int) {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	wg.n += delta
+	if wg.n < 0 {
+		panic("ctxsync: negative WaitGroup counter")
+	}
+	if wg.n == 0 && wg.doneC != nil {
+		close(wg.doneC)
+		wg.doneC = nil
+	}
+}
+
+// Done decrements the counter by one.
+func (wg *WaitGroup) Done() {
+	wg.Add(-1)
+}
+
+// Wait blocks until the counter is zero, or ctx is canceled, in which case
+// it returns a non-nil error.
+func (wg *WaitGroup) Wait(ctx context.Context) error {
+	wg.mu.Lock()
+	if wg.n == 0 {
+		wg.mu.Unlock()
+		return nil
+	}
+	if wg.doneC == nil {
+		wg.doneC = make(chan struct{})
+	}
+	doneC := wg.doneC
+	wg.mu.Unlock()
+	select {
+	case <-doneC:
+		return nil
+	case <-ctx.Done():
+		return errors.E(ctx.Err(), "waiting for wait group")
+	}
+}
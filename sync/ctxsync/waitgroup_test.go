@@ -0,0 +1,27 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package ctxsync_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/sync/ctxsync"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitGroup(t *testing.T) {
+	var wg ctxsync.WaitGroup
+	assert.NoError(t, wg.Wait(context.Background()))
+	wg.Add(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, wg.Wait(ctx))
+	go wg.Done()
+	go wg.Done()
+	assert.NoError(t, wg.Wait(context.Background()))
+	assert.Panics(t, wg.Done)
+}
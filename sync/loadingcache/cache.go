@@ -0,0 +1,382 @@
+package loadingcache
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/errors"
+)
+
+// EvictionPolicy determines which entry is evicted when a Cache exceeds its
+// capacity.
+type EvictionPolicy int
+
+const (
+	// LRU evicts the least recently used entry.
+	LRU EvictionPolicy = iota
+	// LFU evicts the least frequently used entry. Ties are broken in favor of
+	// evicting the least recently used.
+	LFU
+)
+
+// CacheOpts configures a Cache. CacheOpts{} is an unbounded cache that
+// caches values until they're deleted, and does not cache errors.
+type CacheOpts[K comparable, V any] struct {
+	// MaxEntries is the maximum number of entries, if > 0.
+	MaxEntries int
+	// MaxSize is the maximum total size of the entries, as computed by Size,
+	// if > 0.
+	MaxSize int64
+	// Size returns the size of an entry. It's required if MaxSize > 0.
+	Size func(K, V) int64
+	// Eviction is the policy used to evict entries when the cache exceeds
+	// MaxEntries or MaxSize.
+	Eviction EvictionPolicy
+	// TTL is the default time for which loaded values are cached; load
+	// functions may override it with LoadOpts. If TTL == 0, values are
+	// cached until they're evicted.
+	TTL time.Duration
+	// StaleFor, if > 0, enables stale-while-revalidate: for up to StaleFor
+	// after a value expires, Get returns the expired value immediately while
+	// a single background load refreshes it.
+	StaleFor time.Duration
+	// ErrorTTL, if > 0, enables negative caching: load errors are cached and
+	// returned by Get for ErrorTTL. Cancellation errors are never cached.
+	ErrorTTL time.Duration
+}
+
+// CacheStats contains a Cache's counters.
+type CacheStats struct {
+	// Hits is the number of Gets served from the cache, including stale
+	// values and cached errors.
+	Hits int64
+	// StaleHits is the number of Gets that returned a stale value while it
+	// was being revalidated.
+	StaleHits int64
+	// ErrorHits is the number of Gets that returned a cached error.
+	ErrorHits int64
+	// Misses is the number of Gets that had to load, or wait for another
+	// caller's load.
+	Misses int64
+	// Loads and LoadErrors are the numbers of loads, and of loads that
+	// failed.
+	Loads, LoadErrors int64
+	// LoadTime is the total time spent loading.
+	LoadTime time.Duration
+	// Evictions is the number of entries evicted to satisfy MaxEntries or
+	// MaxSize.
+	Evictions int64
+}
+
+// CacheLoadFunc loads the value for a key. It should respect cancellation
+// (return with cancellation error). It may use opts to set the value's
+// expiration, as with LoadFunc.
+type CacheLoadFunc[K comparable, V any] func(ctx context.Context, key K, opts *LoadOpts) (V, error)
+
+// Cache is a keyed, size-bounded loading cache. Like Value, it loads each key
+// at most once at a time, even if concurrent callers request it, and respects
+// cancellation both while loading and while waiting for another caller's
+// load. Unlike Map, it evicts entries to bound its size, and may cache
+// errors.
+//
+// Caches are concurrency-safe. They must not be copied.
+type Cache[K comparable, V any] struct {
+	opts CacheOpts[K, V]
+	// now is used for faking time in tests.
+	now func() time.Time
+
+	mu    sync.Mutex
+	m     map[K]*entry[K, V]
+	queue evictionQueue[K, V]
+	size  int64
+	// tick is a logical clock used to order accesses.
+	tick  uint64
+	stats CacheStats
+}
+
+type entry[K comparable, V any] struct {
+	key K
+	// loaded is true if value or err is set.
+	loaded bool
+	value  V
+	err    error
+	// expiresAt is the time of expiration. expiresAt.IsZero() means no
+	// expiration.
+	expiresAt time.Time
+	// loading is non-nil while a load is in progress. It is closed when the
+	// load completes.
+	loading chan struct{}
+
+	size int64
+	// freq and tick are the entry's access frequency and time of last
+	// access, used for eviction.
+	freq, tick uint64
+	// index is the entry's index in the eviction queue, or -1.
+	index int
+}
+
+// NewCache returns a new cache with the given options.
+func NewCache[K comparable, V any](opts CacheOpts[K, V]) *Cache[K, V] {
+	if opts.MaxSize > 0 && opts.Size == nil {
+		panic("loadingcache: MaxSize requires Size")
+	}
+	c := &Cache[K, V]{
+		opts: opts,
+		now:  time.Now,
+		m:    make(map[K]*entry[K, V]),
+	}
+	c.queue.policy = opts.Eviction
+	return c
+}
+
+// Get returns the cached value for key, or else loads it with load. If the
+// value is expired but within the StaleFor window, Get returns it and
+// reloads it in the background. If a previous load failed and errors are
+// cached, Get returns the cached error.
+func (c *Cache[K, V]) Get(ctx context.Context, key K, load CacheLoadFunc[K, V]) (V, error) {
+	c.mu.Lock()
+	missed := false
+	for {
+		e := c.m[key]
+		if e == nil {
+			e = &entry[K, V]{key: key, index: -1}
+			c.m[key] = e
+		}
+		if e.loaded {
+			now := c.now()
+			switch {
+			case e.expiresAt.IsZero() || now.Before(e.expiresAt):
+				if !missed {
+					c.stats.Hits++
+					if e.err != nil {
+						c.stats.ErrorHits++
+					}
+				}
+				c.touchLocked(e)
+				value, err := e.value, e.err
+				c.mu.Unlock()
+				return value, err
+			case e.err == nil && c.opts.StaleFor > 0 && now.Before(e.expiresAt.Add(c.opts.StaleFor)):
+				c.stats.Hits++
+				c.stats.StaleHits++
+				c.touchLocked(e)
+				if e.loading == nil {
+					e.loading = make(chan struct{})
+					go c.load(detached{ctx}, e, load)
+				}
+				value := e.value
+				c.mu.Unlock()
+				return value, nil
+			}
+		}
+		if !missed {
+			c.stats.Misses++
+			missed = true
+		}
+		if e.loading == nil {
+			e.loading = make(chan struct{})
+			c.mu.Unlock()
+			return c.load(ctx, e, load)
+		}
+		loading := e.loading
+		c.mu.Unlock()
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+		c.mu.Lock()
+	}
+}
+
+// load runs the load function for e, stores its result in the cache as
+// appropriate, and returns it.
+func (c *Cache[K, V]) load(ctx context.Context, e *entry[K, V], load CacheLoadFunc[K, V]) (V, error) {
+	opts := LoadOpts{validFor: c.opts.TTL}
+	if opts.validFor == 0 {
+		opts.validFor = -1
+	}
+	var value V
+	start := c.now()
+	err := runNoPanic(func() (err error) {
+		value, err = load(ctx, e.key, &opts)
+		return
+	})
+	elapsed := c.now().Sub(start)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Loads++
+	c.stats.LoadTime += elapsed
+	close(e.loading)
+	e.loading = nil
+	// The entry may have been evicted or deleted during the load, in which
+	// case the result is not cached.
+	inCache := c.m[e.key] == e
+	switch {
+	case err != nil:
+		c.stats.LoadErrors++
+		var zero V
+		if inCache && c.opts.ErrorTTL > 0 && ctx.Err() == nil &&
+			!errors.Is(errors.Canceled, err) && !errors.Is(errors.Timeout, err) {
+			c.setLocked(e, zero, err, c.now().Add(c.opts.ErrorTTL))
+		} else if inCache && !e.loaded {
+			delete(c.m, e.key)
+		}
+		return zero, err
+	case opts.validFor == 0:
+		if inCache && !e.loaded {
+			delete(c.m, e.key)
+		}
+	case inCache:
+		var expiresAt time.Time
+		if opts.validFor > 0 {
+			expiresAt = c.now().Add(opts.validFor)
+		}
+		c.setLocked(e, value, nil, expiresAt)
+	}
+	return value, nil
+}
+
+// setLocked stores a loaded value or error in e, and evicts other entries as
+// needed to make room for it. If e alone exceeds MaxSize, it is not cached.
+func (c *Cache[K, V]) setLocked(e *entry[K, V], value V, err error, expiresAt time.Time) {
+	if e.index >= 0 {
+		heap.Remove(&c.queue, e.index)
+	}
+	c.size -= e.size
+	e.size = 0
+	if err == nil && c.opts.Size != nil {
+		e.size = c.opts.Size(e.key, value)
+	}
+	if c.opts.MaxSize > 0 && e.size > c.opts.MaxSize {
+		e.size = 0
+		c.removeLocked(e)
+		return
+	}
+	e.loaded = true
+	e.value, e.err, e.expiresAt = value, err, expiresAt
+	// e is kept out of the queue while evicting, so that a new entry, which
+	// has the lowest frequency under LFU, is not evicted immediately.
+	for c.queue.Len() > 0 &&
+		(c.opts.MaxEntries > 0 && c.queue.Len()+1 > c.opts.MaxEntries ||
+			c.opts.MaxSize > 0 && c.size+e.size > c.opts.MaxSize) {
+		c.removeLocked(heap.Pop(&c.queue).(*entry[K, V]))
+		c.stats.Evictions++
+	}
+	c.size += e.size
+	c.touchLocked(e)
+	heap.Push(&c.queue, e)
+}
+
+func (c *Cache[K, V]) touchLocked(e *entry[K, V]) {
+	c.tick++
+	e.tick = c.tick
+	e.freq++
+	if e.index >= 0 {
+		heap.Fix(&c.queue, e.index)
+	}
+}
+
+// removeLocked removes e, which is no longer in the eviction queue, from the
+// cache.
+func (c *Cache[K, V]) removeLocked(e *entry[K, V]) {
+	if c.m[e.key] == e {
+		delete(c.m, e.key)
+	}
+	c.size -= e.size
+	e.size = 0
+}
+
+// Delete removes the entry for key, if any. A load in progress for key is
+// not interrupted, but its result is not cached.
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.m[key]
+	if e == nil {
+		return
+	}
+	if e.index >= 0 {
+		heap.Remove(&c.queue, e.index)
+	}
+	c.removeLocked(e)
+}
+
+// Len returns the number of cached values and errors.
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.queue.Len()
+}
+
+// Size returns the total size of the cached values, as computed by
+// CacheOpts.Size.
+func (c *Cache[K, V]) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Stats returns a snapshot of the cache's counters.
+func (c *Cache[K, V]) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// setClock is for testing. It must be called before any Get and is not
+// concurrency-safe.
+func (c *Cache[K, V]) setClock(now func() time.Time) {
+	c.now = now
+}
+
+// evictionQueue is a min-heap of entries, ordered by eviction priority.
+type evictionQueue[K comparable, V any] struct {
+	policy  EvictionPolicy
+	entries []*entry[K, V]
+}
+
+func (q *evictionQueue[K, V]) Len() int { return len(q.entries) }
+
+func (q *evictionQueue[K, V]) Less(i, j int) bool {
+	a, b := q.entries[i], q.entries[j]
+	if q.policy == LFU && a.freq != b.freq {
+		return a.freq < b.freq
+	}
+	return a.tick < b.tick
+}
+
+func (q *evictionQueue[K, V]) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+	q.entries[i].index = i
+	q.entries[j].index = j
+}
+
+func (q *evictionQueue[K, V]) Push(x interface{}) {
+	e := x.(*entry[K, V])
+	e.index = len(q.entries)
+	q.entries = append(q.entries, e)
+}
+
+func (q *evictionQueue[K, V]) Pop() interface{} {
+	n := len(q.entries)
+	e := q.entries[n-1]
+	q.entries[n-1] = nil
+	q.entries = q.entries[:n-1]
+	e.index = -1
+	return e
+}
+
+// detached is a context that carries the values of its parent, but not its
+// cancellation or deadline. It's used for background revalidation, which
+// outlives the Get that triggers it.
+type detached struct{ parent context.Context }
+
+func (d detached) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (d detached) Done() <-chan struct{}             { return nil }
+func (d detached) Err() error                        { return nil }
+func (d detached) Value(key interface{}) interface{} { return d.parent.Value(key) }
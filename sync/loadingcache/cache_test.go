@@ -0,0 +1,224 @@
+package loadingcache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keyLoader returns a load function that returns the key's length, and
+// counts its calls.
+func keyLoader(loads *int32) CacheLoadFunc[string, int] {
+	return func(_ context.Context, key string, _ *LoadOpts) (int, error) {
+		atomic.AddInt32(loads, 1)
+		return len(key), nil
+	}
+}
+
+func cacheFail(context.Context, string, *LoadOpts) (int, error) {
+	panic("unexpected load")
+}
+
+func getAll(t *testing.T, c *Cache[string, int], keys ...string) {
+	t.Helper()
+	var loads int32
+	for _, key := range keys {
+		v, err := c.Get(context.Background(), key, keyLoader(&loads))
+		require.NoError(t, err)
+		require.Equal(t, len(key), v)
+	}
+}
+
+func cached(c *Cache[string, int], key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.m[key]
+	return e != nil && e.loaded
+}
+
+func TestCacheLRU(t *testing.T) {
+	c := NewCache(CacheOpts[string, int]{MaxEntries: 2})
+	getAll(t, c, "a", "bb", "a", "ccc")
+	assert.True(t, cached(c, "a"))
+	assert.False(t, cached(c, "bb"))
+	assert.True(t, cached(c, "ccc"))
+	assert.Equal(t, 2, c.Len())
+	stats := c.Stats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(3), stats.Misses)
+	assert.Equal(t, int64(3), stats.Loads)
+	assert.Equal(t, int64(1), stats.Evictions)
+}
+
+func TestCacheLFU(t *testing.T) {
+	c := NewCache(CacheOpts[string, int]{MaxEntries: 2, Eviction: LFU})
+	getAll(t, c, "a", "a", "a", "bb", "bb", "ccc", "dddd")
+	// "ccc" was used least, though more recently than "a" and "bb".
+	assert.True(t, cached(c, "a"))
+	assert.False(t, cached(c, "bb"))
+	assert.False(t, cached(c, "ccc"))
+	assert.True(t, cached(c, "dddd"))
+}
+
+func TestCacheSize(t *testing.T) {
+	c := NewCache(CacheOpts[string, int]{
+		MaxSize: 5,
+		Size:    func(_ string, v int) int64 { return int64(v) },
+	})
+	getAll(t, c, "a", "bb", "ccc")
+	assert.Equal(t, int64(5), c.Size())
+	assert.False(t, cached(c, "a"))
+	getAll(t, c, "dddddd")
+	// A value larger than MaxSize is not cached, and does not evict others.
+	assert.False(t, cached(c, "dddddd"))
+	assert.Equal(t, int64(5), c.Size())
+	c.Delete("ccc")
+	assert.Equal(t, 1, c.Len())
+	assert.Equal(t, int64(2), c.Size())
+}
+
+func TestCacheTTL(t *testing.T) {
+	var clock fakeClock
+	clock.Set(time.Unix(recentUnixTimestamp, 0))
+	c := NewCache(CacheOpts[string, int]{TTL: time.Hour})
+	c.setClock(clock.Now)
+	var loads int32
+	getAll(t, c, "a")
+	clock.Add(30 * time.Minute)
+	_, err := c.Get(context.Background(), "a", cacheFail)
+	require.NoError(t, err)
+	clock.Add(time.Hour)
+	v, err := c.Get(context.Background(), "a", keyLoader(&loads))
+	require.NoError(t, err)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, int32(1), loads)
+
+	// Load functions may override the TTL.
+	v, err = c.Get(context.Background(), "b", func(_ context.Context, _ string, opts *LoadOpts) (int, error) {
+		opts.CacheFor(0)
+		return 2, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, v)
+	assert.False(t, cached(c, "b"))
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	var clock fakeClock
+	clock.Set(time.Unix(recentUnixTimestamp, 0))
+	c := NewCache(CacheOpts[string, int]{TTL: time.Minute, StaleFor: time.Hour})
+	c.setClock(clock.Now)
+	ctx := context.Background()
+	_, err := c.Get(ctx, "a", func(context.Context, string, *LoadOpts) (int, error) { return 1, nil })
+	require.NoError(t, err)
+	clock.Add(2 * time.Minute)
+
+	var (
+		release = make(chan struct{})
+		loads   int32
+	)
+	reload := func(ctx context.Context, _ string, _ *LoadOpts) (int, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return 2, nil
+	}
+	// Concurrent Gets return the stale value, and start a single reload.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.Get(ctx, "a", reload)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, v)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(10), c.Stats().StaleHits)
+	close(release)
+	for {
+		v, err := c.Get(ctx, "a", cacheFail)
+		require.NoError(t, err)
+		if v == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, int32(1), loads)
+
+	// Past the stale window, Get waits for a load.
+	clock.Add(2 * time.Hour)
+	v, err := c.Get(ctx, "a", func(context.Context, string, *LoadOpts) (int, error) { return 3, nil })
+	require.NoError(t, err)
+	assert.Equal(t, 3, v)
+}
+
+func TestCacheErrors(t *testing.T) {
+	var clock fakeClock
+	clock.Set(time.Unix(recentUnixTimestamp, 0))
+	c := NewCache(CacheOpts[string, int]{ErrorTTL: time.Minute})
+	c.setClock(clock.Now)
+	ctx := context.Background()
+	errLoad := fmt.Errorf("load failed")
+	_, err := c.Get(ctx, "a", func(context.Context, string, *LoadOpts) (int, error) { return 0, errLoad })
+	assert.Equal(t, errLoad, err)
+	_, err = c.Get(ctx, "a", cacheFail)
+	assert.Equal(t, errLoad, err)
+	assert.Equal(t, int64(1), c.Stats().ErrorHits)
+	clock.Add(2 * time.Minute)
+	v, err := c.Get(ctx, "a", func(context.Context, string, *LoadOpts) (int, error) { return 1, nil })
+	require.NoError(t, err)
+	assert.Equal(t, 1, v)
+
+	// Cancellation is not cached.
+	_, err = c.Get(ctx, "b", func(context.Context, string, *LoadOpts) (int, error) {
+		return 0, errors.E(errors.Canceled, "canceled")
+	})
+	assert.Error(t, err)
+	assert.False(t, cached(c, "b"))
+	assert.Equal(t, int64(2), c.Stats().LoadErrors)
+}
+
+func TestCacheConcurrentLoad(t *testing.T) {
+	c := NewCache(CacheOpts[string, int]{})
+	var (
+		loads   int32
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	go func() {
+		_, _ = c.Get(context.Background(), "a", func(context.Context, string, *LoadOpts) (int, error) {
+			atomic.AddInt32(&loads, 1)
+			close(started)
+			<-release
+			return 1, nil
+		})
+	}()
+	<-started
+	// A waiter's cancellation is respected.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := c.Get(ctx, "a", cacheFail)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.Get(context.Background(), "a", cacheFail)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, v)
+		}()
+	}
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), loads)
+}
@@ -26,3 +26,31 @@ func Value(ctx context.Context, key interface{}) *loadingcache.Value {
 	}
 	return m.GetOrCreate(key)
 }
+
+type cacheKeyType[K comparable, V any] struct{}
+
+// WithCache returns a child context which, when passed to Get with the same
+// key and value types, gets and sets in c. As with With, callers control the
+// lifetime of the returned context's cache.
+func WithCache[K comparable, V any](ctx context.Context, c *loadingcache.Cache[K, V]) context.Context {
+	return context.WithValue(ctx, cacheKeyType[K, V]{}, c)
+}
+
+// Cache retrieves the *loadingcache.Cache[K, V] linked to ctx by WithCache,
+// or nil if there is none.
+func Cache[K comparable, V any](ctx context.Context) *loadingcache.Cache[K, V] {
+	c, _ := ctx.Value(cacheKeyType[K, V]{}).(*loadingcache.Cache[K, V])
+	return c
+}
+
+// Get gets the value for key from the cache linked to ctx by WithCache,
+// loading it if needed (see loadingcache.Cache.Get). If ctx didn't come from
+// an earlier WithCache call with the same key and value types, caching is
+// disabled: Get just calls load.
+func Get[K comparable, V any](ctx context.Context, key K, load loadingcache.CacheLoadFunc[K, V]) (V, error) {
+	if c := Cache[K, V](ctx); c != nil {
+		return c.Get(ctx, key, load)
+	}
+	var opts loadingcache.LoadOpts
+	return load(ctx, key, &opts)
+}
@@ -80,3 +80,30 @@ func TestDeleteAll(t *testing.T) {
 	assert.NotSame(t, vA, ctxloadingcache.Value(ctx2, testKeyA{}))
 	assert.NotSame(t, vB, ctxloadingcache.Value(ctx2, testKeyB{}))
 }
+
+func TestCache(t *testing.T) {
+	cache := loadingcache.NewCache(loadingcache.CacheOpts[string, int]{MaxEntries: 1})
+	ctx := ctxloadingcache.WithCache(context.Background(), cache)
+	loads := 0
+	load := func(_ context.Context, key string, _ *loadingcache.LoadOpts) (int, error) {
+		loads++
+		return len(key), nil
+	}
+	for i := 0; i < 3; i++ {
+		v, err := ctxloadingcache.Get(ctx, "abc", load)
+		require.NoError(t, err)
+		assert.Equal(t, 3, v)
+	}
+	assert.Equal(t, 1, loads)
+	assert.Same(t, cache, ctxloadingcache.Cache[string, int](ctx))
+
+	// Caches are distinguished by type.
+	assert.Nil(t, ctxloadingcache.Cache[string, string](ctx))
+
+	// Without a cache, every Get loads.
+	for i := 0; i < 3; i++ {
+		_, err := ctxloadingcache.Get(context.Background(), "abc", load)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 4, loads)
+}
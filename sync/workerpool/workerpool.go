@@ -3,9 +3,12 @@ package workerpool
 import (
 	"context"
 	"sync"
+	"time"
 
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/retry"
+	"github.com/grailbio/base/status"
 	"github.com/grailbio/base/sync/multierror"
-	"v.io/x/lib/vlog"
 )
 
 // Task provides an interface for an individual task. Tasks are executed by
@@ -39,31 +42,62 @@ type Task interface {
 // and go in a TaskGroup until tg.Wait() has been called. All the Tasks
 // in this example are executed by 3 go routines.
 //
-// Note: Each WorkerPool will create a goroutine to keep track of active
-// TaskGroups. Each TaskGroup will create a goroutine to keep track of
-// pending/active tasks.
+// Each TaskGroup has its own queue. Workers take the next task from the
+// group with the highest priority; among groups of equal priority, tasks
+// are taken in proportion to the groups' weights (see Priority and Weight).
+// Within a group, tasks are executed in FIFO order.
 type WorkerPool struct {
 	Ctx         context.Context
 	Concurrency int
-	queue       chan deliverable // Contains Tasks waiting to be executed.
-	ctxCounter  sync.WaitGroup
+	// Status, if set, receives the counters of each TaskGroup created
+	// afterwards, in a status.Group named after the TaskGroup.
+	Status *status.Status
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// ready contains the groups that have queued tasks.
+	ready []*TaskGroup
+	// queued is the number of tasks queued across all groups, and maxQueued
+	// is the number above which Enqueue blocks.
+	queued, maxQueued int
+	// vtime is the virtual time of the last dispatched task; see
+	// TaskGroup.pass.
+	vtime  float64
+	closed bool
+	done   chan struct{}
+
+	ctxCounter sync.WaitGroup
 }
 
 // New creates a WorkerPool with the given concurrency.
 //
 // TODO(pknudsgaard): Should return a closure calling Wait.
 func New(ctx context.Context, concurrency int) *WorkerPool {
-	result := WorkerPool{
+	wp := &WorkerPool{
 		Ctx:         ctx,
 		Concurrency: concurrency,
-		queue:       make(chan deliverable, 10*concurrency),
+		maxQueued:   10 * concurrency,
+		done:        make(chan struct{}),
 	}
-
+	wp.cond = sync.NewCond(&wp.mu)
 	for i := 0; i < concurrency; i++ {
-		go result.worker(result.queue)
+		go wp.worker()
 	}
-
-	return &result
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-wp.done:
+			return
+		}
+		wp.mu.Lock()
+		for _, grp := range wp.ready {
+			grp.dropLocked()
+		}
+		wp.ready = nil
+		wp.cond.Broadcast()
+		wp.mu.Unlock()
+	}()
+	return wp
 }
 
 // TaskGroup is used group Tasks together so the consumer can wait for a
@@ -72,97 +106,294 @@ type TaskGroup struct {
 	Name       string
 	ErrHandler *multierror.Builder
 	Wp         *WorkerPool
-	activity   sync.WaitGroup // Count active tasks
+	// Ctx is the group's context. It is derived from the WorkerPool's
+	// context, and is canceled by Cancel.
+	Ctx context.Context
+
+	cancel   context.CancelFunc
+	priority int
+	weight   int
+	policy   retry.Policy
+	status   *status.Group
+	activity sync.WaitGroup // Count active tasks
+
+	// The following are guarded by Wp.mu.
+
+	queue []*deliverable
+	// pass is the group's virtual time: it advances by 1/weight for each
+	// task dispatched, and the ready group with the smallest pass is
+	// served first. A group that becomes ready starts at the pool's
+	// current virtual time, so that idle groups do not accumulate credit.
+	pass  float64
+	stats Stats
+}
+
+// Stats contains the counters of a TaskGroup.
+type Stats struct {
+	// Queued is the number of tasks waiting to be executed, including tasks
+	// waiting to be retried.
+	Queued int
+	// Running is the number of tasks being executed.
+	Running int
+	// Completed is the number of tasks that succeeded.
+	Completed int
+	// Failed is the number of tasks that failed, after any retries.
+	Failed int
+	// Retried is the number of times tasks were retried.
+	Retried int
+	// Canceled is the number of tasks dropped because the group or pool
+	// was canceled.
+	Canceled int
+}
+
+// A GroupOption configures a TaskGroup.
+type GroupOption func(*TaskGroup)
+
+// Priority sets the group's priority. Tasks from groups with higher priority
+// are always executed before tasks from groups with lower priority. The
+// default priority is 0.
+func Priority(priority int) GroupOption {
+	return func(grp *TaskGroup) { grp.priority = priority }
+}
+
+// Weight sets the group's share of the workers relative to other groups of
+// the same priority: a group with weight 2 is served twice as often as a
+// group with weight 1 while both have queued tasks. The default weight is 1.
+func Weight(weight int) GroupOption {
+	return func(grp *TaskGroup) {
+		if weight > 0 {
+			grp.weight = weight
+		}
+	}
+}
+
+// Retry configures the group to retry tasks that fail with temporary errors
+// (see errors.IsTemporary), waiting between tries as directed by the given
+// policy. While a task waits to be retried, it does not occupy a worker.
+func Retry(policy retry.Policy) GroupOption {
+	return func(grp *TaskGroup) { grp.policy = policy }
 }
 
 // NewTaskGroup creates a TaskGroup for Tasks to be executed in.
 //
-// TODO(pknudsgaard): TaskGroup should have a context.Context which is
-// separate from the WorkerPool context.Context.
-//
 // TODO(pknudsgaard): Should return a closure calling Wait.
-func (wp *WorkerPool) NewTaskGroup(name string, errHandler *multierror.Builder) *TaskGroup {
-	vlog.VI(2).Infof("Creating TaskGroup: %s", name)
-
+func (wp *WorkerPool) NewTaskGroup(name string, errHandler *multierror.Builder, opts ...GroupOption) *TaskGroup {
 	grp := &TaskGroup{
 		Name:       name,
 		ErrHandler: errHandler,
 		Wp:         wp,
+		weight:     1,
+	}
+	grp.Ctx, grp.cancel = context.WithCancel(wp.Ctx)
+	for _, opt := range opts {
+		opt(grp)
+	}
+	if wp.Status != nil {
+		grp.status = wp.Status.Group(name)
 	}
-
 	wp.ctxCounter.Add(1)
 	return grp
 }
 
-// Enqueue puts a Task in the queue. If block is true and the channel is full,
-// then the function blocks. If block is false and the channel is full, then
-// the function returns false.
+// Enqueue puts a Task in the queue. If block is true and the queue is full,
+// then the function blocks. If block is false and the queue is full, then
+// the function returns false. Tasks enqueued after the group or pool has
+// been canceled are dropped.
 func (grp *TaskGroup) Enqueue(t Task, block bool) bool {
-	var success bool
-
-	grp.activity.Add(1)
-	d := deliverable{grp: grp, t: t}
-	if block {
-		grp.Wp.queue <- d
-		success = true
-	} else {
-		select {
-		case grp.Wp.queue <- d:
-			success = true
-		default:
-			success = false
+	wp := grp.Wp
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	for wp.queued >= wp.maxQueued && grp.Ctx.Err() == nil {
+		if !block {
+			return false
 		}
+		wp.cond.Wait()
 	}
-
-	if !success {
+	grp.activity.Add(1)
+	if grp.Ctx.Err() != nil {
+		grp.stats.Canceled++
 		grp.activity.Done()
+		grp.updateStatusLocked()
+		return true
+	}
+	grp.pushLocked(&deliverable{grp: grp, t: t})
+	return true
+}
+
+// Cancel cancels the group's context and drops its queued tasks, without
+// affecting other groups in the pool. Running tasks may observe the
+// cancellation through grp.Ctx.
+func (grp *TaskGroup) Cancel() {
+	grp.cancel()
+	wp := grp.Wp
+	wp.mu.Lock()
+	grp.dropLocked()
+	for i, g := range wp.ready {
+		if g == grp {
+			wp.ready = append(wp.ready[:i], wp.ready[i+1:]...)
+			break
+		}
 	}
+	wp.cond.Broadcast()
+	wp.mu.Unlock()
+}
 
-	return success
+// Stats returns a snapshot of the group's counters.
+func (grp *TaskGroup) Stats() Stats {
+	grp.Wp.mu.Lock()
+	defer grp.Wp.mu.Unlock()
+	return grp.stats
 }
 
 // Wait blocks until all Tasks in this TaskGroup have completed.
 func (grp *TaskGroup) Wait() {
-	// Trigger the director in case we were already at 0.
 	grp.activity.Wait()
+	grp.cancel()
 	grp.Wp.ctxCounter.Done()
 }
 
+// pushLocked appends d to the group's queue, and makes the group ready if
+// needed.
+func (grp *TaskGroup) pushLocked(d *deliverable) {
+	wp := grp.Wp
+	if len(grp.queue) == 0 {
+		if grp.pass < wp.vtime {
+			grp.pass = wp.vtime
+		}
+		wp.ready = append(wp.ready, grp)
+	}
+	grp.queue = append(grp.queue, d)
+	grp.stats.Queued++
+	wp.queued++
+	grp.updateStatusLocked()
+	wp.cond.Broadcast()
+}
+
+// dropLocked drops the group's queued tasks. The caller must remove the
+// group from the pool's ready list.
+func (grp *TaskGroup) dropLocked() {
+	n := len(grp.queue)
+	grp.queue = nil
+	grp.stats.Queued -= n
+	grp.stats.Canceled += n
+	grp.Wp.queued -= n
+	grp.activity.Add(-n)
+	grp.updateStatusLocked()
+}
+
+func (grp *TaskGroup) updateStatusLocked() {
+	s := grp.stats
+	grp.status.Printf("queued: %d, running: %d, completed: %d, failed: %d, retried: %d, canceled: %d",
+		s.Queued, s.Running, s.Completed, s.Failed, s.Retried, s.Canceled)
+}
+
 type deliverable struct {
 	grp *TaskGroup
 	t   Task
+	// retries is the number of times the task has been retried.
+	retries int
+}
+
+// next removes and returns the next task to be executed, blocking until one
+// is available. It returns nil when the pool is done.
+func (wp *WorkerPool) next() *deliverable {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	for len(wp.ready) == 0 {
+		if wp.closed || wp.Ctx.Err() != nil {
+			return nil
+		}
+		wp.cond.Wait()
+	}
+	best := 0
+	for i, grp := range wp.ready[1:] {
+		b := wp.ready[best]
+		if grp.priority > b.priority || grp.priority == b.priority && grp.pass < b.pass {
+			best = i + 1
+		}
+	}
+	grp := wp.ready[best]
+	d := grp.queue[0]
+	grp.queue[0] = nil
+	grp.queue = grp.queue[1:]
+	if len(grp.queue) == 0 {
+		wp.ready = append(wp.ready[:best], wp.ready[best+1:]...)
+	}
+	wp.vtime = grp.pass
+	grp.pass += 1 / float64(grp.weight)
+	grp.stats.Queued--
+	grp.stats.Running++
+	wp.queued--
+	grp.updateStatusLocked()
+	// Wake up a blocked Enqueue.
+	wp.cond.Broadcast()
+	return d
 }
 
 // worker is the goroutine for a worker. It will continue to consume and
-// execute tasks from the queue until the channel is closed or the TaskGroup is
-// Done.
-func (wp *WorkerPool) worker(dlv chan deliverable) {
-	vlog.VI(2).Infof("Starting worker")
-	defer vlog.VI(2).Infof("Ending worker")
+// execute tasks until the pool is done or its context is canceled.
+func (wp *WorkerPool) worker() {
+	for d := wp.next(); d != nil; d = wp.next() {
+		d.grp.run(d)
+	}
+}
 
-	for {
-		select {
-		case <-wp.Ctx.Done():
-			for d := range dlv {
-				d.grp.activity.Done()
-			}
+// run executes the task, and either records its outcome or schedules it to
+// be retried.
+func (grp *TaskGroup) run(d *deliverable) {
+	err := d.t.Do(grp)
+	wp := grp.Wp
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	grp.stats.Running--
+	if err == nil {
+		grp.stats.Completed++
+		grp.updateStatusLocked()
+		grp.activity.Done()
+		return
+	}
+	if grp.policy != nil && errors.IsTemporary(err) && grp.Ctx.Err() == nil {
+		if ok, wait := grp.policy.Retry(d.retries); ok {
+			d.retries++
+			grp.stats.Retried++
+			grp.stats.Queued++
+			grp.updateStatusLocked()
+			time.AfterFunc(wait, func() { grp.requeue(d) })
 			return
-		case d, ok := <-dlv:
-			if !ok {
-				// Channel is closed, quit worker.
-				return
-			}
-			d.grp.ErrHandler.Add(d.t.Do(d.grp))
-			d.grp.activity.Done()
 		}
 	}
+	grp.stats.Failed++
+	grp.updateStatusLocked()
+	if grp.ErrHandler != nil {
+		grp.ErrHandler.Add(err)
+	}
+	grp.activity.Done()
+}
+
+// requeue puts a task that is due to be retried back in the group's queue.
+// Retried tasks do not count toward the pool's queue limit until requeued.
+func (grp *TaskGroup) requeue(d *deliverable) {
+	wp := grp.Wp
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	grp.stats.Queued--
+	if grp.Ctx.Err() != nil {
+		grp.stats.Canceled++
+		grp.updateStatusLocked()
+		grp.activity.Done()
+		return
+	}
+	grp.pushLocked(d)
 }
 
 // Wait blocks until all TaskGroups in the WorkerPool have Waitd.
 func (wp *WorkerPool) Wait() {
-	// Trigger the director in case we were already at 0:
 	wp.ctxCounter.Wait()
-	close(wp.queue)
+	wp.mu.Lock()
+	wp.closed = true
+	wp.cond.Broadcast()
+	wp.mu.Unlock()
+	close(wp.done)
 }
 
 // Err returns the context.Context error to determine if WorkerPool Waitd
@@ -3,11 +3,16 @@ package workerpool_test
 import (
 	"context"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/grailbio/base/errors"
 	"github.com/grailbio/base/grail"
+	"github.com/grailbio/base/retry"
+	"github.com/grailbio/base/status"
+	"github.com/grailbio/base/sync/multierror"
 	"github.com/grailbio/base/sync/workerpool"
 	"github.com/stretchr/testify/assert"
 )
@@ -95,3 +100,121 @@ func TestMain(m *testing.M) {
 	defer shutdown()
 	m.Run()
 }
+
+// FuncTask adapts a function to the Task interface.
+type FuncTask func(grp *workerpool.TaskGroup) error
+
+func (f FuncTask) Do(grp *workerpool.TaskGroup) error { return f(grp) }
+
+// blockPool occupies the single worker of wp until the returned function is
+// called, so that tasks can be queued before any of them runs.
+func blockPool(wp *workerpool.WorkerPool) (release func()) {
+	var (
+		started = make(chan struct{})
+		unblock = make(chan struct{})
+	)
+	grp := wp.NewTaskGroup("block", nil)
+	grp.Enqueue(FuncTask(func(*workerpool.TaskGroup) error {
+		close(started)
+		<-unblock
+		return nil
+	}), true)
+	<-started
+	return func() {
+		close(unblock)
+		grp.Wait()
+	}
+}
+
+func TestPriorityAndWeight(t *testing.T) {
+	wp := workerpool.New(context.Background(), 1)
+	release := blockPool(wp)
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	record := func(name string) workerpool.Task {
+		return FuncTask(func(*workerpool.TaskGroup) error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		})
+	}
+	low := wp.NewTaskGroup("low", nil, workerpool.Weight(1))
+	high := wp.NewTaskGroup("high", nil, workerpool.Weight(2))
+	urgent := wp.NewTaskGroup("urgent", nil, workerpool.Priority(1))
+	for i := 0; i < 4; i++ {
+		assert.True(t, low.Enqueue(record("low"), true))
+		assert.True(t, high.Enqueue(record("high"), true))
+	}
+	assert.True(t, urgent.Enqueue(record("urgent"), true))
+	release()
+	low.Wait()
+	high.Wait()
+	urgent.Wait()
+	wp.Wait()
+	assert.Equal(t, "urgent", order[0])
+	// While both groups have queued tasks, "high" is served twice as often.
+	assert.Equal(t, []string{"low", "high", "high", "low", "high", "high"}, order[1:7])
+}
+
+func TestRetry(t *testing.T) {
+	wp := workerpool.New(context.Background(), 2)
+	errs := multierror.NewBuilder(10)
+	grp := wp.NewTaskGroup("retry", errs, workerpool.Retry(retry.MaxRetries(retry.Backoff(time.Millisecond, time.Millisecond, 1), 3)))
+	var tries, permanentTries int32
+	grp.Enqueue(FuncTask(func(*workerpool.TaskGroup) error {
+		if atomic.AddInt32(&tries, 1) < 3 {
+			return errors.E(errors.Temporary, "flaky")
+		}
+		return nil
+	}), true)
+	grp.Enqueue(FuncTask(func(*workerpool.TaskGroup) error {
+		atomic.AddInt32(&permanentTries, 1)
+		return errors.E(errors.Invalid, "broken")
+	}), true)
+	grp.Wait()
+	wp.Wait()
+	assert.Equal(t, int32(3), tries)
+	assert.Equal(t, int32(1), permanentTries)
+	assert.Error(t, errs.Err())
+	stats := grp.Stats()
+	assert.Equal(t, workerpool.Stats{Completed: 1, Failed: 1, Retried: 2}, stats)
+}
+
+func TestGroupCancel(t *testing.T) {
+	st := new(status.Status)
+	wp := workerpool.New(context.Background(), 1)
+	wp.Status = st
+	release := blockPool(wp)
+	canceled := wp.NewTaskGroup("canceled", nil)
+	other := wp.NewTaskGroup("other", nil)
+	var ran int32
+	task := FuncTask(func(*workerpool.TaskGroup) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	})
+	for i := 0; i < 5; i++ {
+		canceled.Enqueue(task, true)
+		other.Enqueue(task, true)
+	}
+	canceled.Cancel()
+	assert.Error(t, canceled.Ctx.Err())
+	assert.NoError(t, other.Ctx.Err())
+	release()
+	canceled.Wait()
+	other.Wait()
+	wp.Wait()
+	assert.Equal(t, int32(5), ran)
+	assert.Equal(t, workerpool.Stats{Canceled: 5}, canceled.Stats())
+	assert.Equal(t, workerpool.Stats{Completed: 5}, other.Stats())
+	var found bool
+	for _, g := range st.Groups() {
+		if g.Value().Title == "other" {
+			found = true
+			assert.Contains(t, g.Value().Status, "completed: 5")
+		}
+	}
+	assert.True(t, found)
+}
@@ -1,37 +1,46 @@
 package syncqueue
 
 import (
+	"context"
 	"fmt"
 	"sync"
 )
 
-// OrderedQueue is a queue that orders entries on their way out.  An
+// OrderedQueue is a TypedOrderedQueue of interface{} values.
+type OrderedQueue = TypedOrderedQueue[interface{}]
+
+// TypedOrderedQueue is a queue that orders entries on their way out.  An
 // inserter enqueues each entry with an index, and when the receiver
 // dequeues an entry, the entries arrive in the sequential order of
-// their indices.  An OrderedQueue has a maximum size; if the queue
+// their indices.  A TypedOrderedQueue has a maximum size; if the queue
 // contains the next entry, the queue will accept up to maxSize
 // entries.  If the queue does not yet contain the next entry, the
 // queue will block an insert that would make the size equal to
 // maxSize unless the new entry is the next entry to be dequeued.
-type OrderedQueue struct {
+type TypedOrderedQueue[T any] struct {
 	next    int
 	maxSize int
-	pending map[int]interface{}
-	cond    *sync.Cond
+	pending map[int]T
+	mu      sync.Mutex
+	changed signal
 	closed  bool
 	err     error
 }
 
 // Create a new OrderedQueue with size maxSize.
 func NewOrderedQueue(maxSize int) *OrderedQueue {
+	return NewTypedOrderedQueue[interface{}](maxSize)
+}
+
+// NewTypedOrderedQueue creates a new TypedOrderedQueue with size maxSize.
+func NewTypedOrderedQueue[T any](maxSize int) *TypedOrderedQueue[T] {
 	if maxSize < 1 {
 		panic("OrderedQueue must have length at least 1")
 	}
-	return &OrderedQueue{
+	return &TypedOrderedQueue[T]{
 		next:    0,
 		maxSize: maxSize,
-		pending: make(map[int]interface{}),
-		cond:    sync.NewCond(&sync.Mutex{}),
+		pending: make(map[int]T),
 		closed:  false,
 	}
 }
@@ -40,15 +49,24 @@ func NewOrderedQueue(maxSize int) *OrderedQueue {
 // Insert blocks if the insert would make the queue full and the new
 // entry is not the one next one in the output sequence.  The sequence
 // index should start at zero.
-func (q *OrderedQueue) Insert(index int, value interface{}) error {
-	q.cond.L.Lock()
-	defer q.cond.L.Unlock()
+func (q *TypedOrderedQueue[T]) Insert(index int, value T) error {
+	return q.Put(context.Background(), index, value)
+}
 
-	_, haveNext := q.pending[q.next]
-	for q.err == nil && ((haveNext && len(q.pending) == q.maxSize) || (!haveNext && index != q.next && len(q.pending) == q.maxSize-1)) {
-		q.cond.Wait()
-		_, haveNext = q.pending[q.next]
+// Put is Insert, but returns ctx.Err() if ctx is done while Put is
+// blocked.
+func (q *TypedOrderedQueue[T]) Put(ctx context.Context, index int, value T) error {
+	q.mu.Lock()
+	for {
+		_, haveNext := q.pending[q.next]
+		if q.err != nil || !((haveNext && len(q.pending) == q.maxSize) || (!haveNext && index != q.next && len(q.pending) == q.maxSize-1)) {
+			break
+		}
+		if err := q.waitLocked(ctx); err != nil {
+			return err
+		}
 	}
+	defer q.mu.Unlock()
 	if q.err != nil {
 		return q.err
 	}
@@ -58,7 +76,7 @@ func (q *OrderedQueue) Insert(index int, value interface{}) error {
 
 	q.pending[index] = value
 	if index == q.next {
-		q.cond.Broadcast()
+		q.changed.broadcast()
 	}
 	return nil
 }
@@ -69,14 +87,14 @@ func (q *OrderedQueue) Insert(index int, value interface{}) error {
 // The user may close the OrderedQueue prematurely with a non-nil err
 // while some calls to Insert() and/or Next() are blocking; in this
 // case those calls that are blocking will return with err.
-func (q *OrderedQueue) Close(err error) error {
-	q.cond.L.Lock()
-	defer q.cond.L.Unlock()
+func (q *TypedOrderedQueue[T]) Close(err error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 	if q.err == nil {
 		q.err = err
 	}
 	q.closed = true
-	q.cond.Broadcast()
+	q.changed.broadcast()
 	return q.err
 }
 
@@ -84,27 +102,72 @@ func (q *OrderedQueue) Close(err error) error {
 // is available.  Next blocks if the next entry is not yet present.
 // Next returns (nil, false) if there are no more entries, and the
 // queue is closed.
-func (q *OrderedQueue) Next() (value interface{}, ok bool, err error) {
-	q.cond.L.Lock()
-	defer q.cond.L.Unlock()
+func (q *TypedOrderedQueue[T]) Next() (value T, ok bool, err error) {
+	return q.Get(context.Background())
+}
+
+// Get is Next, but returns ctx.Err() if ctx is done while Get is blocked.
+func (q *TypedOrderedQueue[T]) Get(ctx context.Context) (value T, ok bool, err error) {
+	vs, err := q.GetN(ctx, 1)
+	if len(vs) == 0 {
+		return value, false, err
+	}
+	return vs[0], true, nil
+}
 
-	value, found := q.pending[q.next]
-	for q.err == nil && (!found && !q.closed) {
-		q.cond.Wait()
-		value, found = q.pending[q.next]
+// GetN returns up to n consecutive entries in sequence, blocking until at
+// least the next entry is available. It returns an empty slice if there are
+// no more entries and the queue is closed. Errors are as for Get. GetN
+// panics if n < 1.
+func (q *TypedOrderedQueue[T]) GetN(ctx context.Context, n int) ([]T, error) {
+	if n < 1 {
+		panic("OrderedQueue.GetN: n must be at least 1")
+	}
+	q.mu.Lock()
+	for {
+		_, found := q.pending[q.next]
+		if q.err != nil || found || q.closed {
+			break
+		}
+		if err := q.waitLocked(ctx); err != nil {
+			return nil, err
+		}
 	}
+	defer q.mu.Unlock()
 	if q.err != nil {
-		return nil, false, q.err
+		return nil, q.err
 	}
 	if q.closed && len(q.pending) == 0 {
-		return nil, false, nil
+		return nil, nil
 	}
-	if q.closed && !found {
+	var values []T
+	for len(values) < n {
+		value, found := q.pending[q.next]
+		if !found {
+			break
+		}
+		values = append(values, value)
+		delete(q.pending, q.next)
+		q.next++
+	}
+	if len(values) == 0 {
 		panic(fmt.Sprintf("OrderedQueue is closed, but entry %d is not present", q.next))
 	}
+	q.changed.broadcast()
+	return values, nil
+}
 
-	delete(q.pending, q.next)
-	q.next++
-	q.cond.Broadcast()
-	return value, true, nil
+// waitLocked waits for the queue to change or for ctx to be done. It is
+// called with q.mu held, and returns with it held unless it returns an
+// error.
+func (q *TypedOrderedQueue[T]) waitLocked(ctx context.Context) error {
+	ch := q.changed.wait()
+	q.mu.Unlock()
+	select {
+	case <-ch:
+		q.mu.Lock()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,279 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package syncqueue
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/grailbio/base/errors"
+)
+
+// ErrClosed is returned by Put on a queue that has been closed with a nil
+// error.
+var ErrClosed = errors.E(errors.Invalid, "syncqueue: put on closed queue")
+
+// Queue is a bounded producer-consumer queue of values of type T. The order
+// in which values are dequeued depends on the constructor: NewBoundedFIFO,
+// NewBoundedLIFO, or NewPriorityQueue. Thread safe.
+//
+// Put blocks while the queue is full, and Get blocks while it is empty; both
+// return early if their context is canceled. Close has the semantics of
+// OrderedQueue.Close.
+type Queue[T any] struct {
+	capacity int
+
+	mu      sync.Mutex
+	items   store[T]
+	changed signal
+	closed  bool
+	err     error
+}
+
+// NewBoundedFIFO returns a first-in, first-out queue that holds at most
+// capacity values.
+func NewBoundedFIFO[T any](capacity int) *Queue[T] {
+	return newQueue[T](capacity, &fifo[T]{})
+}
+
+// NewBoundedLIFO returns a last-in, first-out queue that holds at most
+// capacity values.
+func NewBoundedLIFO[T any](capacity int) *Queue[T] {
+	return newQueue[T](capacity, &lifo[T]{})
+}
+
+// NewPriorityQueue returns a queue that holds at most capacity values, and
+// dequeues the least value first, as ordered by less. Values that are equal
+// under less are dequeued in unspecified order.
+func NewPriorityQueue[T any](capacity int, less func(a, b T) bool) *Queue[T] {
+	return newQueue[T](capacity, &priority[T]{less: less})
+}
+
+func newQueue[T any](capacity int, items store[T]) *Queue[T] {
+	if capacity < 1 {
+		panic("syncqueue: queue must have capacity at least 1")
+	}
+	return &Queue[T]{capacity: capacity, items: items}
+}
+
+// Put adds v to the queue, blocking while the queue is full. It returns
+// ctx.Err() if ctx is done first, the queue's error if it is closed with an
+// error, and ErrClosed if it is closed with a nil error.
+func (q *Queue[T]) Put(ctx context.Context, v T) error {
+	q.mu.Lock()
+	for {
+		switch {
+		case q.err != nil:
+			err := q.err
+			q.mu.Unlock()
+			return err
+		case q.closed:
+			q.mu.Unlock()
+			return ErrClosed
+		case q.items.len() < q.capacity:
+			q.items.push(v)
+			q.changed.broadcast()
+			q.mu.Unlock()
+			return nil
+		}
+		if err := q.waitLocked(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// Get removes and returns the next value, blocking while the queue is empty.
+// Get returns (v, true, nil) when a value is dequeued, and (zero, false, nil)
+// once the queue is closed with a nil error and drained. It returns a non-nil
+// error if the queue is closed with an error, or if ctx is done first.
+func (q *Queue[T]) Get(ctx context.Context) (v T, ok bool, err error) {
+	vs, err := q.GetN(ctx, 1)
+	if len(vs) == 0 {
+		return v, false, err
+	}
+	return vs[0], true, nil
+}
+
+// GetN removes and returns up to n values, blocking until at least one is
+// available. It returns an empty slice once the queue is closed with a nil
+// error and drained. Errors are as for Get. GetN panics if n < 1.
+func (q *Queue[T]) GetN(ctx context.Context, n int) ([]T, error) {
+	if n < 1 {
+		panic("syncqueue: GetN: n must be at least 1")
+	}
+	q.mu.Lock()
+	for {
+		switch {
+		case q.err != nil:
+			err := q.err
+			q.mu.Unlock()
+			return nil, err
+		case q.items.len() > 0:
+			if l := q.items.len(); n > l {
+				n = l
+			}
+			vs := make([]T, n)
+			for i := range vs {
+				vs[i] = q.items.pop()
+			}
+			q.changed.broadcast()
+			q.mu.Unlock()
+			return vs, nil
+		case q.closed:
+			q.mu.Unlock()
+			return nil, nil
+		}
+		if err := q.waitLocked(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Len returns the number of values in the queue.
+func (q *Queue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.items.len()
+}
+
+// Close closes the queue. In the normal case, err should be nil, and Close
+// tells the queue that no more values will be added: subsequent Puts return
+// ErrClosed, and Gets drain the remaining values. If err is non-nil, the
+// remaining values are discarded, and blocked and subsequent calls to Put
+// and Get return err. Close returns the queue's error, which is the first
+// non-nil error passed to Close.
+func (q *Queue[T]) Close(err error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == nil && err != nil {
+		q.err = err
+		for q.items.len() > 0 {
+			q.items.pop()
+		}
+	}
+	q.closed = true
+	q.changed.broadcast()
+	return q.err
+}
+
+// waitLocked waits for the queue to change or for ctx to be done. It is
+// called with q.mu held, and returns with it held unless it returns an
+// error.
+func (q *Queue[T]) waitLocked(ctx context.Context) error {
+	ch := q.changed.wait()
+	q.mu.Unlock()
+	select {
+	case <-ch:
+		q.mu.Lock()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// signal is a broadcast notification that, unlike sync.Cond, can be waited
+// on together with a context. It must be guarded by a mutex.
+type signal struct {
+	ch chan struct{}
+}
+
+// wait returns a channel that is closed on the next broadcast.
+func (s *signal) wait() <-chan struct{} {
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+func (s *signal) broadcast() {
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
+
+// store is the storage discipline of a Queue.
+type store[T any] interface {
+	len() int
+	push(T)
+	// pop removes and returns the next value. The store must not be empty.
+	pop() T
+}
+
+type fifo[T any] struct {
+	items []T
+	head  int
+}
+
+func (f *fifo[T]) len() int { return len(f.items) - f.head }
+
+func (f *fifo[T]) push(v T) {
+	if f.head > 0 && len(f.items) == cap(f.items) {
+		// Reclaim the space of popped values rather than growing.
+		n := copy(f.items, f.items[f.head:])
+		var zero T
+		for i := n; i < len(f.items); i++ {
+			f.items[i] = zero
+		}
+		f.items = f.items[:n]
+		f.head = 0
+	}
+	f.items = append(f.items, v)
+}
+
+func (f *fifo[T]) pop() T {
+	var zero T
+	v := f.items[f.head]
+	f.items[f.head] = zero
+	f.head++
+	if f.head == len(f.items) {
+		f.items = f.items[:0]
+		f.head = 0
+	}
+	return v
+}
+
+type lifo[T any] struct {
+	items []T
+}
+
+func (l *lifo[T]) len() int { return len(l.items) }
+func (l *lifo[T]) push(v T) { l.items = append(l.items, v) }
+
+func (l *lifo[T]) pop() T {
+	var zero T
+	n := len(l.items)
+	v := l.items[n-1]
+	l.items[n-1] = zero
+	l.items = l.items[:n-1]
+	return v
+}
+
+type priority[T any] struct {
+	items []T
+	less  func(a, b T) bool
+}
+
+func (p *priority[T]) len() int { return len(p.items) }
+func (p *priority[T]) push(v T) { heap.Push((*priorityHeap[T])(p), v) }
+func (p *priority[T]) pop() T   { return heap.Pop((*priorityHeap[T])(p)).(T) }
+
+// priorityHeap implements heap.Interface for priority.
+type priorityHeap[T any] priority[T]
+
+func (h *priorityHeap[T]) Len() int           { return len(h.items) }
+func (h *priorityHeap[T]) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *priorityHeap[T]) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *priorityHeap[T]) Push(x interface{}) { h.items = append(h.items, x.(T)) }
+
+func (h *priorityHeap[T]) Pop() interface{} {
+	var zero T
+	n := len(h.items)
+	v := h.items[n-1]
+	h.items[n-1] = zero
+	h.items = h.items[:n-1]
+	return v
+}
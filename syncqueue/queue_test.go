@@ -0,0 +1,208 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package syncqueue_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/syncqueue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func drain(t *testing.T, q *syncqueue.Queue[int]) []int {
+	t.Helper()
+	var vs []int
+	for {
+		v, ok, err := q.Get(context.Background())
+		require.NoError(t, err)
+		if !ok {
+			return vs
+		}
+		vs = append(vs, v)
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		q    *syncqueue.Queue[int]
+		want []int
+	}{
+		{"fifo", syncqueue.NewBoundedFIFO[int](10), []int{3, 1, 4, 1, 5}},
+		{"lifo", syncqueue.NewBoundedLIFO[int](10), []int{5, 1, 4, 1, 3}},
+		{"priority", syncqueue.NewPriorityQueue(10, func(a, b int) bool { return a < b }), []int{1, 1, 3, 4, 5}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, v := range []int{3, 1, 4, 1, 5} {
+				require.NoError(t, test.q.Put(ctx, v))
+			}
+			assert.Equal(t, 5, test.q.Len())
+			require.NoError(t, test.q.Close(nil))
+			assert.Equal(t, syncqueue.ErrClosed, test.q.Put(ctx, 9))
+			assert.Equal(t, test.want, drain(t, test.q))
+		})
+	}
+}
+
+func TestQueueFIFOWraparound(t *testing.T) {
+	q := syncqueue.NewBoundedFIFO[int](3)
+	ctx := context.Background()
+	var want []int
+	for i := 0; i < 100; i++ {
+		require.NoError(t, q.Put(ctx, i))
+		if q.Len() == 3 {
+			v, _, err := q.Get(ctx)
+			require.NoError(t, err)
+			want = append(want, v)
+		}
+	}
+	_ = q.Close(nil)
+	got := append(want, drain(t, q)...)
+	for i, v := range got {
+		require.Equal(t, i, v)
+	}
+}
+
+func TestQueueBlocking(t *testing.T) {
+	q := syncqueue.NewBoundedFIFO[int](1)
+	ctx := context.Background()
+	require.NoError(t, q.Put(ctx, 0))
+
+	// Put blocks while the queue is full, until ctx is done.
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, q.Put(cctx, 1))
+
+	done := make(chan error)
+	go func() { done <- q.Put(ctx, 1) }()
+	v, ok, err := q.Get(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 0, v)
+	require.NoError(t, <-done)
+	v, _, err = q.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, v)
+
+	// Get blocks while the queue is empty, until ctx is done.
+	cctx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, ok, err = q.Get(cctx)
+	assert.False(t, ok)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestQueueGetN(t *testing.T) {
+	q := syncqueue.NewBoundedFIFO[int](10)
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, q.Put(ctx, i))
+	}
+	vs, err := q.GetN(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, vs)
+	vs, err = q.GetN(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 4}, vs)
+	_ = q.Close(nil)
+	vs, err = q.GetN(ctx, 3)
+	require.NoError(t, err)
+	assert.Empty(t, vs)
+	assert.Panics(t, func() { _, _ = q.GetN(ctx, 0) })
+	assert.Panics(t, func() { _, _ = q.GetN(ctx, -1) })
+}
+
+func TestQueueCloseError(t *testing.T) {
+	q := syncqueue.NewBoundedLIFO[int](1)
+	ctx := context.Background()
+	require.NoError(t, q.Put(ctx, 0))
+	errc := make(chan error)
+	go func() { errc <- q.Put(ctx, 1) }()
+	errFoo := fmt.Errorf("foo")
+	assert.Equal(t, errFoo, q.Close(errFoo))
+	assert.Equal(t, errFoo, <-errc)
+	// Values are discarded, and the first error is retained.
+	assert.Equal(t, errFoo, q.Close(nil))
+	_, ok, err := q.Get(ctx)
+	assert.False(t, ok)
+	assert.Equal(t, errFoo, err)
+}
+
+func TestQueueConcurrent(t *testing.T) {
+	const (
+		nproducer = 8
+		nvalue    = 1000
+	)
+	q := syncqueue.NewBoundedFIFO[int](4)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for p := 0; p < nproducer; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < nvalue; i++ {
+				assert.NoError(t, q.Put(ctx, p*nvalue+i))
+			}
+		}(p)
+	}
+	go func() {
+		wg.Wait()
+		_ = q.Close(nil)
+	}()
+	seen := make([]bool, nproducer*nvalue)
+	last := make([]int, nproducer)
+	for i := range last {
+		last[i] = -1
+	}
+	for {
+		vs, err := q.GetN(ctx, 3)
+		require.NoError(t, err)
+		if len(vs) == 0 {
+			break
+		}
+		for _, v := range vs {
+			require.False(t, seen[v])
+			seen[v] = true
+			// Each producer's values are dequeued in order.
+			p := v / nvalue
+			require.Greater(t, v, last[p])
+			last[p] = v
+		}
+	}
+	for v, ok := range seen {
+		require.True(t, ok, "missing %d", v)
+	}
+}
+
+func TestTypedOrderedQueue(t *testing.T) {
+	q := syncqueue.NewTypedOrderedQueue[string](3)
+	ctx := context.Background()
+	require.NoError(t, q.Put(ctx, 1, "one"))
+	require.NoError(t, q.Put(ctx, 2, "two"))
+
+	// Entry 0 is missing and the queue is full, so other inserts block.
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, q.Put(cctx, 3, "three"))
+	_, _, err := q.Get(cctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	require.NoError(t, q.Put(ctx, 0, "zero"))
+	vs, err := q.GetN(ctx, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"zero", "one", "two"}, vs)
+	assert.Panics(t, func() { _, _ = q.GetN(ctx, 0) })
+	require.NoError(t, q.Close(nil))
+	v, ok, err := q.Get(ctx)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "", v)
+}
@@ -0,0 +1,243 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package traverse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/syncqueue"
+)
+
+// A Pipeline streams items of type T from a reader, through a sequence of
+// transformation stages, to a writer. The reader and writer are invoked
+// serially, and items are passed to the writer in the order in which they
+// were read. Each stage transforms items concurrently, with its own
+// parallelism. The number of items in flight (read but not yet written) is
+// bounded, so that a slow writer or stage applies backpressure to the reader.
+//
+// Stages that change the type of an item can be expressed by using a struct
+// type for T that carries both the input and the output of the stage.
+//
+// Example:
+//
+//	p := traverse.NewPipeline[*record](64).
+//		Stage("parse", 8, parse).
+//		Stage("annotate", 4, annotate)
+//	err := p.Run(ctx, readRecord, writeRecord)
+type Pipeline[T any] struct {
+	// Reporter receives status reports for each run of the pipeline. Task i
+	// is the ith item read; it begins when it is read and ends when it is
+	// written.
+	Reporter Reporter
+	// N is the expected number of items, if known. It is passed to
+	// Reporter.Init.
+	N int
+
+	maxInFlight int
+	stages      []pipelineStage[T]
+}
+
+type pipelineStage[T any] struct {
+	name        string
+	parallelism int
+	fn          func(ctx context.Context, v T) (T, error)
+}
+
+// pipelineItem is an item in flight, along with its sequence number.
+type pipelineItem[T any] struct {
+	index int
+	value T
+}
+
+// NewPipeline returns a new pipeline with no stages that has at most
+// maxInFlight items in flight.
+func NewPipeline[T any](maxInFlight int) *Pipeline[T] {
+	if maxInFlight <= 0 {
+		log.Panicf("traverse.NewPipeline: invalid maxInFlight: %d", maxInFlight)
+	}
+	return &Pipeline[T]{maxInFlight: maxInFlight}
+}
+
+// Stage appends a stage to the pipeline that invokes fn on each item with
+// the given parallelism, and passes fn's result on to the next stage. The
+// name is used in error messages. Stage returns the pipeline.
+func (p *Pipeline[T]) Stage(name string, parallelism int, fn func(ctx context.Context, v T) (T, error)) *Pipeline[T] {
+	if parallelism <= 0 {
+		log.Panicf("traverse.Pipeline.Stage: invalid parallelism: %d", parallelism)
+	}
+	p.stages = append(p.stages, pipelineStage[T]{name, parallelism, fn})
+	return p
+}
+
+// Run runs the pipeline. Read is called repeatedly to produce items until it
+// returns io.EOF; write is called with each transformed item, in order. Run
+// returns when all items have been written, or after the first error from
+// read, a stage, or write, in which case the context passed to the
+// pipeline's functions is canceled and the first error is returned. Run also
+// returns the context's error if ctx is canceled. Panics in stages are
+// propagated to the caller, as in T.Each.
+func (p *Pipeline[T]) Run(ctx context.Context, read func(ctx context.Context) (T, error), write func(ctx context.Context, v T) error) error {
+	if p.Reporter != nil {
+		p.Reporter.Init(p.N)
+		defer p.Reporter.Complete()
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		once errors.Once
+		wg   sync.WaitGroup
+		// Slots bound the number of items in flight: a slot is taken before
+		// an item is read, and returned after it is written.
+		slots = make(chan struct{}, p.maxInFlight)
+		// Since at most maxInFlight items are in flight, inserts into the
+		// queue never block.
+		queue = syncqueue.NewOrderedQueue(p.maxInFlight + 1)
+	)
+	fail := func(err error) {
+		once.Set(err)
+		cancel()
+		_ = queue.Close(err)
+	}
+	// Cancellation of the parent context stops the pipeline even when no
+	// pipeline function observes it.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-parent.Done():
+			fail(parent.Err())
+		case <-done:
+		}
+	}()
+
+	source := make(chan pipelineItem[T])
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(source)
+		for index := 0; ; index++ {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				fail(ctx.Err())
+				return
+			}
+			var v T
+			err := apply(func(int) (err error) {
+				v, err = read(ctx)
+				return
+			}, index)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				fail(err)
+				return
+			}
+			if p.Reporter != nil {
+				p.Reporter.Begin(index)
+			}
+			select {
+			case source <- pipelineItem[T]{index, v}:
+			case <-ctx.Done():
+				fail(ctx.Err())
+				return
+			}
+		}
+	}()
+
+	in := source
+	for _, stage := range p.stages {
+		in = p.runStage(ctx, stage, in, fail, &wg)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for item := range in {
+			if err := queue.Insert(item.index, item); err != nil {
+				return
+			}
+		}
+		_ = queue.Close(nil)
+	}()
+
+	for {
+		v, ok, err := queue.Next()
+		if err != nil || !ok {
+			break
+		}
+		item := v.(pipelineItem[T])
+		if err := apply(func(int) error { return write(ctx, item.value) }, item.index); err != nil {
+			fail(err)
+			break
+		}
+		if p.Reporter != nil {
+			p.Reporter.End(item.index)
+		}
+		<-slots
+	}
+	// Unblock and wait for the remaining goroutines, which drain their
+	// inputs once the context is canceled.
+	cancel()
+	wg.Wait()
+	err := once.Err()
+	if err == nil {
+		return nil
+	}
+	if err, ok := err.(panicErr); ok {
+		panic(fmt.Sprintf("traverse child: %v\n%s", err.v, string(err.stack)))
+	}
+	return err
+}
+
+// runStage starts the workers for a stage reading from in. It returns the
+// stage's output channel, which is closed when all of its workers are done.
+func (p *Pipeline[T]) runStage(ctx context.Context, stage pipelineStage[T], in <-chan pipelineItem[T], fail func(error), wg *sync.WaitGroup) chan pipelineItem[T] {
+	var (
+		out       = make(chan pipelineItem[T])
+		stageDone sync.WaitGroup
+	)
+	stageDone.Add(stage.parallelism)
+	wg.Add(stage.parallelism)
+	for i := 0; i < stage.parallelism; i++ {
+		go func() {
+			defer wg.Done()
+			defer stageDone.Done()
+			for item := range in {
+				if ctx.Err() != nil {
+					// Drain the input so that upstream goroutines exit.
+					continue
+				}
+				err := apply(func(int) (err error) {
+					item.value, err = stage.fn(ctx, item.value)
+					return
+				}, item.index)
+				if err != nil {
+					if _, ok := err.(panicErr); !ok {
+						err = errors.E(err, fmt.Sprintf("pipeline stage %s", stage.name))
+					}
+					fail(err)
+					continue
+				}
+				select {
+				case out <- item:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		stageDone.Wait()
+		close(out)
+	}()
+	return out
+}
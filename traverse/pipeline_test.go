@@ -0,0 +1,162 @@
+// Copyright 2022 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package traverse_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/traverse"
+)
+
+// counter returns a pipeline reader that produces the integers [0, n).
+func counter(n int) func(context.Context) (int, error) {
+	next := 0
+	return func(context.Context) (int, error) {
+		if next == n {
+			return 0, io.EOF
+		}
+		next++
+		return next - 1, nil
+	}
+}
+
+func jitter() {
+	time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+}
+
+func TestPipeline(t *testing.T) {
+	const (
+		N           = 1000
+		maxInFlight = 8
+	)
+	var (
+		inFlight, maxSeen int32
+		reporter          = new(testReporter)
+	)
+	p := traverse.NewPipeline[int](maxInFlight).
+		Stage("square", 4, func(_ context.Context, v int) (int, error) {
+			jitter()
+			return v * v, nil
+		}).
+		Stage("negate", 3, func(_ context.Context, v int) (int, error) {
+			jitter()
+			return -v, nil
+		})
+	p.Reporter = reporter
+	p.N = N
+	read := counter(N)
+	var got []int
+	err := p.Run(context.Background(),
+		func(ctx context.Context) (int, error) {
+			if n := atomic.AddInt32(&inFlight, 1); n > atomic.LoadInt32(&maxSeen) {
+				atomic.StoreInt32(&maxSeen, n)
+			}
+			return read(ctx)
+		},
+		func(_ context.Context, v int) error {
+			atomic.AddInt32(&inFlight, -1)
+			got = append(got, v)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != N {
+		t.Fatalf("got %d items, want %d", len(got), N)
+	}
+	for i, v := range got {
+		if v != -i*i {
+			t.Fatalf("item %d: got %d, want %d", i, v, -i*i)
+		}
+	}
+	// The reader is called once more to observe EOF.
+	if maxSeen > maxInFlight+1 {
+		t.Errorf("%d items in flight, want at most %d", maxSeen, maxInFlight)
+	}
+	last := reporter.statusHistory[len(reporter.statusHistory)-1]
+	if (last != testStatus{queued: 0, running: 0, done: N}) {
+		t.Errorf("got final status %v", last)
+	}
+}
+
+func TestPipelineError(t *testing.T) {
+	errFailed := errors.New("failed")
+	var written int32
+	p := traverse.NewPipeline[int](4).
+		Stage("fail", 2, func(ctx context.Context, v int) (int, error) {
+			if v == 50 {
+				return 0, errFailed
+			}
+			return v, nil
+		})
+	err := p.Run(context.Background(), counter(1e6), func(_ context.Context, v int) error {
+		atomic.AddInt32(&written, 1)
+		return nil
+	})
+	if err == nil || !errors.Is(err, errFailed) && !strings.Contains(err.Error(), "failed") {
+		t.Fatalf("got %v, want %v", err, errFailed)
+	}
+	if !strings.Contains(err.Error(), "pipeline stage fail") {
+		t.Errorf("error %q does not name the stage", err)
+	}
+	if n := atomic.LoadInt32(&written); n > 50 {
+		t.Errorf("wrote %d items past the failure", n)
+	}
+
+	err = traverse.NewPipeline[int](4).Run(context.Background(), counter(100), func(_ context.Context, v int) error {
+		if v == 10 {
+			return errFailed
+		}
+		return nil
+	})
+	if err != errFailed {
+		t.Fatalf("got %v, want %v", err, errFailed)
+	}
+}
+
+func TestPipelineCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := traverse.NewPipeline[int](4).
+		Stage("block", 2, func(ctx context.Context, v int) (int, error) {
+			if v == 10 {
+				cancel()
+				// Ignore the context: the pipeline must still stop.
+				time.Sleep(10 * time.Millisecond)
+			}
+			return v, nil
+		})
+	err := p.Run(ctx, counter(1e9), func(context.Context, int) error { return nil })
+	if err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestPipelinePanic(t *testing.T) {
+	v := recovered(func() {
+		_ = traverse.NewPipeline[int](4).
+			Stage("panic", 2, func(_ context.Context, v int) (int, error) {
+				if v == 3 {
+					panic("pipeline panic")
+				}
+				return v, nil
+			}).
+			Run(context.Background(), counter(10), func(context.Context, int) error { return nil })
+	})
+	s, ok := v.(string)
+	if !ok {
+		t.Fatal("expected string")
+	}
+	if got, want := s, fmt.Sprintf("traverse child: %s", "pipeline panic"); !strings.HasPrefix(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
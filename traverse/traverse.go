@@ -7,14 +7,17 @@
 package traverse
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"runtime"
 	"runtime/debug"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/sync/multierror"
 )
 
 const cachelineSize = 64
@@ -103,6 +106,90 @@ func (t T) Each(n int, fn func(i int) error) error {
 	return err
 }
 
+// An EachOption configures a traversal performed by EachCtx.
+type EachOption func(*eachOptions)
+
+type eachOptions struct {
+	continueOnError bool
+	maxErrors       int
+	itemTimeout     time.Duration
+}
+
+// ContinueOnError configures EachCtx to invoke fn for every index even
+// when some invocations fail. The errors are collected in a
+// multierror.Builder that retains up to maxErrors errors, and its result is
+// returned once all invocations have completed.
+func ContinueOnError(maxErrors int) EachOption {
+	return func(o *eachOptions) {
+		o.continueOnError = true
+		o.maxErrors = maxErrors
+	}
+}
+
+// ItemTimeout configures EachCtx to invoke fn with a context that is
+// canceled after the given duration.
+func ItemTimeout(d time.Duration) EachOption {
+	return func(o *eachOptions) {
+		o.itemTimeout = d
+	}
+}
+
+// EachCtx performs a traversal on fn, as Each does, passing each invocation
+// a context derived from ctx. By default, the derived context is canceled
+// on the first error (or panic), so that in-flight invocations can stop
+// early, and the first error is returned. With ContinueOnError, failures do
+// not stop the traversal, and all errors are returned together. In either
+// case, the traversal stops scheduling new invocations once ctx is done, and
+// ctx's error is returned. Panics are propagated to the caller, as in Each.
+func (t T) EachCtx(ctx context.Context, n int, fn func(ctx context.Context, i int) error, opts ...EachOption) error {
+	var o eachOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var errs *multierror.Builder
+	if o.continueOnError {
+		errs = multierror.NewBuilder(o.maxErrors)
+	}
+	err := t.Each(n, func(i int) (err error) {
+		if err := parent.Err(); err != nil {
+			return err
+		}
+		done := false
+		defer func() {
+			if !done {
+				// fn panicked; Each propagates the panic once in-flight
+				// invocations have completed.
+				cancel()
+			}
+		}()
+		ictx := ctx
+		if o.itemTimeout > 0 {
+			var cancelItem context.CancelFunc
+			ictx, cancelItem = context.WithTimeout(ctx, o.itemTimeout)
+			defer cancelItem()
+		}
+		err = fn(ictx, i)
+		done = true
+		if err == nil {
+			return nil
+		}
+		if errs != nil {
+			errs.Add(errors.E(err, fmt.Sprintf("traverse item %d", i)))
+			return nil
+		}
+		cancel()
+		return err
+	})
+	if errs == nil {
+		return err
+	}
+	errs.Add(err)
+	return errs.Err()
+}
+
 func (t T) each(n int, fn func(i int) error) error {
 	var (
 		errors errors.Once
@@ -270,6 +357,12 @@ func Each(n int, fn func(i int) error) error {
 	return defaultT.Each(n, fn)
 }
 
+// EachCtx performs concurrent traversal over n elements with a context. It
+// is a shorthand for (T{}).EachCtx.
+func EachCtx(ctx context.Context, n int, fn func(ctx context.Context, i int) error, opts ...EachOption) error {
+	return defaultT.EachCtx(ctx, n, fn, opts...)
+}
+
 // CPU calls the function fn for each available system CPU. CPU
 // returns when all calls have completed or on first error.
 func CPU(fn func() error) error {
@@ -5,6 +5,7 @@
 package traverse_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -333,3 +334,87 @@ func BenchmarkInvoke(b *testing.B) {
 		_ = fn(k)
 	}
 }
+
+func TestEachCtxCancel(t *testing.T) {
+	errFailed := errors.New("failed")
+	var canceled int32
+	err := traverse.Limit(4).EachCtx(context.Background(), 4, func(ctx context.Context, i int) error {
+		if i == 0 {
+			return errFailed
+		}
+		<-ctx.Done()
+		atomic.AddInt32(&canceled, 1)
+		return ctx.Err()
+	})
+	if err != errFailed {
+		t.Fatalf("got %v, want %v", err, errFailed)
+	}
+	if got, want := atomic.LoadInt32(&canceled), int32(3); got != want {
+		t.Errorf("got %d canceled invocations, want %d", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = traverse.EachCtx(ctx, 10, func(ctx context.Context, i int) error { return nil })
+	if err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestEachCtxContinueOnError(t *testing.T) {
+	var ran int32
+	err := traverse.Limit(3).EachCtx(context.Background(), 100, func(ctx context.Context, i int) error {
+		atomic.AddInt32(&ran, 1)
+		if ctx.Err() != nil {
+			t.Errorf("item %d: context canceled", i)
+		}
+		if i%10 == 0 {
+			return fmt.Errorf("error %d", i)
+		}
+		return nil
+	}, traverse.ContinueOnError(100))
+	if got, want := atomic.LoadInt32(&ran), int32(100); got != want {
+		t.Errorf("ran %d items, want %d", got, want)
+	}
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for i := 0; i < 100; i += 10 {
+		if !strings.Contains(err.Error(), fmt.Sprintf("error %d", i)) {
+			t.Errorf("error %q is missing item %d", err, i)
+		}
+	}
+}
+
+func TestEachCtxItemTimeout(t *testing.T) {
+	err := traverse.EachCtx(context.Background(), 3, func(ctx context.Context, i int) error {
+		if i == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}, traverse.ItemTimeout(10*time.Millisecond))
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestEachCtxPanic(t *testing.T) {
+	var canceled int32
+	v := recovered(func() {
+		_ = traverse.Limit(2).EachCtx(context.Background(), 2, func(ctx context.Context, i int) error {
+			if i == 0 {
+				panic("panic with context")
+			}
+			<-ctx.Done()
+			atomic.AddInt32(&canceled, 1)
+			return nil
+		})
+	})
+	if s, ok := v.(string); !ok || !strings.HasPrefix(s, "traverse child: panic with context") {
+		t.Errorf("got %v", v)
+	}
+	if atomic.LoadInt32(&canceled) != 1 {
+		t.Error("in-flight invocation was not canceled")
+	}
+}
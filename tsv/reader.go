@@ -1,6 +1,7 @@
 package tsv
 
 import (
+	"encoding"
 	"encoding/csv"
 	"fmt"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/grailbio/base/errors"
@@ -19,6 +21,7 @@ type columnFormat struct {
 	typ        reflect.Type // Go type information of the column.
 	kind       reflect.Kind // type of the column.
 	fmt        string       // Optional format directive for writing this value.
+	layout     string       // Optional time layout for time.Time columns.
 	index      int          // index of this column in a row, 0-based.
 	offset     uintptr      // byte offset of this field within the Go struct.
 }
@@ -27,10 +30,6 @@ type rowFormat []columnFormat
 
 // Reader reads a TSV file. It wraps around the standard csv.Reader and allows
 // parsing row contents into a Go struct directly. Thread compatible.
-//
-// TODO(saito) Support passing a custom bool parser.
-//
-// TODO(saito) Support a custom "NA" detector.
 type Reader struct {
 	*csv.Reader
 
@@ -64,6 +63,27 @@ type Reader struct {
 	// REQUIRES: HasHeaderRow=true AND UseHeaderNames=true
 	IgnoreMissingColumns bool
 
+	// NATokens lists the column values that denote a missing value, e.g.,
+	// "NA" or "". A missing value sets a pointer field to nil, and any other
+	// field to its zero value. It must be set before reading any data.
+	NATokens []string
+
+	// TimeLayouts lists the layouts, in the order tried, used to parse
+	// time.Time fields that do not have a layout option in their tag. If
+	// empty, time.RFC3339 is used. It must be set before reading any data.
+	TimeLayouts []string
+
+	// SkipBadRows causes Read to skip rows that cannot be parsed, instead of
+	// returning an error. Rows are skipped if they are malformed, have the
+	// wrong number of columns, or have values that cannot be parsed; they
+	// are recorded in BadRows. Errors in the header or in the row type
+	// (e.g., columns that are missing from the header) are still returned by
+	// Read.
+	SkipBadRows bool
+
+	// BadRows lists the rows skipped because of SkipBadRows.
+	BadRows []BadRow
+
 	nRow int // # of rows read so far, excluding the header.
 
 	// columnIndex x maps colname -> colindex (0-based). Filled from the header
@@ -72,6 +92,34 @@ type Reader struct {
 
 	cachedRowType   reflect.Type
 	cachedRowFormat rowFormat
+
+	parsers map[string]ParseFunc
+}
+
+// BadRow describes a row that was skipped because it could not be parsed.
+type BadRow struct {
+	// Line is the line number of the row, counting the header row.
+	Line int
+	// Row is the contents of the row. It is nil if the row could not be
+	// split into columns.
+	Row []string
+	// Err is the error encountered while parsing the row.
+	Err error
+}
+
+// ParseFunc parses a column value. Dest is a pointer to the struct field to
+// be filled.
+type ParseFunc func(value string, dest interface{}) error
+
+// RegisterParser registers a custom parser for the named column. The column
+// name is the name of the struct field or the name set in its `tsv` tag. A
+// custom parser takes precedence over all other parsing, except for
+// NATokens. It must be called before reading any data.
+func (r *Reader) RegisterParser(column string, parse ParseFunc) {
+	if r.parsers == nil {
+		r.parsers = map[string]ParseFunc{}
+	}
+	r.parsers[column] = parse
 }
 
 // NewReader creates a new TSV reader that reads from the given input.
@@ -149,7 +197,10 @@ func parseRowFormat(typ reflect.Type) (rowFormat, error) {
 			continue
 		}
 		columnName := f.Name
-		var fmt string
+		var (
+			fmt, layout string
+			flatten     bool
+		)
 		if tag := f.Tag.Get("tsv"); tag != "" {
 			if tag == "-" {
 				continue
@@ -161,15 +212,38 @@ func parseRowFormat(typ reflect.Type) (rowFormat, error) {
 			for _, tag := range tagArray[1:] {
 				if strings.HasPrefix(tag, "fmt=") {
 					fmt = tag[4:]
+				} else if strings.HasPrefix(tag, "layout=") {
+					layout = tag[7:]
+				} else if tag == "flatten" {
+					flatten = true
 				}
 			}
 		}
+		// Fields of nested structs tagged with the flatten option are
+		// flattened into columns named "<column>.<nested column>".
+		if flatten {
+			if f.Type.Kind() != reflect.Struct {
+				return nil, errors.E(errors.Invalid, "field '"+f.Name+"' with flatten option must be a struct, but found "+f.Type.String())
+			}
+			nestedFormat, err := parseRowFormat(reflect.PtrTo(f.Type))
+			if err != nil {
+				return nil, err
+			}
+			for _, col := range nestedFormat {
+				col.columnName = columnName + "." + col.columnName
+				col.offset += f.Offset
+				col.index = len(format)
+				format = append(format, col)
+			}
+			continue
+		}
 		format = append(format, columnFormat{
 			fieldName:  f.Name,
 			columnName: columnName,
 			typ:        f.Type,
 			kind:       f.Type.Kind(),
 			fmt:        fmt,
+			layout:     layout,
 			index:      len(format),
 			offset:     f.Offset,
 		})
@@ -195,135 +269,195 @@ func (r *Reader) fillRow(val interface{}, row []string) error {
 		return fmt.Errorf("extra columns found in %+v", r.cachedRowFormat)
 	}
 
-	for _, col := range r.cachedRowFormat {
-		if len(row) < col.index {
-			return r.wrapError(fmt.Errorf("row has only %d columns", len(row)), col)
-		}
-		colVal := row[col.index]
-		if col.fmt != "" {
-			// Not all format directives are recognized while scanning. Try to
-			// standardize some of the common options.
-			colfmt := col.fmt
-			if strings.ContainsAny(colfmt, "efg") {
-				// Standardize all base 10 floating point number formats to 'g', and
-				// drop precision and width which are not supported while scanning.
-				colfmt = "g"
-			}
-			if len(strings.Fields(colVal)) != 1 {
-				// Scanf functions tokenize by space.
-				return r.wrapError(fmt.Errorf("value with fmt option can not have whitespace"), col)
-			}
-			var (
-				typ1   = col.typ
-				p1     = unsafe.Pointer(uintptr(p) + col.offset)
-				v      = reflect.NewAt(typ1, p1).Interface()
-				n, err = fmt.Sscanf(colVal, "%"+colfmt, v)
-			)
-			if err != nil {
-				return r.wrapError(err, col)
-			}
-			if n != 1 {
-				return r.wrapError(fmt.Errorf("%d objects scanned for %s; expected 1", n, colVal), col)
-			}
-			continue
+	for i := range r.cachedRowFormat {
+		col := &r.cachedRowFormat[i]
+		if len(row) <= col.index {
+			return r.wrapError(fmt.Errorf("row has only %d columns", len(row)), *col)
 		}
-		switch col.kind {
-		case reflect.Bool:
-			var v bool
-			switch colVal {
-			case "Y", "yes":
-				v = true
-			case "N", "no":
-				v = false
-			default:
-				var err error
-				if v, err = strconv.ParseBool(colVal); err != nil {
-					return r.wrapError(err, col)
-				}
-			}
-			*(*bool)(unsafe.Pointer(uintptr(p) + col.offset)) = v
-		case reflect.String:
-			*(*string)(unsafe.Pointer(uintptr(p) + col.offset)) = colVal
-		case reflect.Int8:
-			v, err := strconv.ParseInt(colVal, 0, 8)
-			if err != nil {
-				return r.wrapError(err, col)
-			}
-			*(*int8)(unsafe.Pointer(uintptr(p) + col.offset)) = int8(v)
-		case reflect.Int16:
-			v, err := strconv.ParseInt(colVal, 0, 16)
-			if err != nil {
-				return r.wrapError(err, col)
-			}
-			*(*int16)(unsafe.Pointer(uintptr(p) + col.offset)) = int16(v)
-		case reflect.Int32:
-			v, err := strconv.ParseInt(colVal, 0, 32)
-			if err != nil {
-				return r.wrapError(err, col)
-			}
-			*(*int32)(unsafe.Pointer(uintptr(p) + col.offset)) = int32(v)
-		case reflect.Int64:
-			v, err := strconv.ParseInt(colVal, 0, 64)
-			if err != nil {
-				return r.wrapError(err, col)
-			}
-			*(*int64)(unsafe.Pointer(uintptr(p) + col.offset)) = v
-		case reflect.Int:
-			v, err := strconv.ParseInt(colVal, 0, 64)
-			if err != nil {
-				return r.wrapError(err, col)
-			}
-			*(*int)(unsafe.Pointer(uintptr(p) + col.offset)) = int(v)
-		case reflect.Uint8:
-			v, err := strconv.ParseUint(colVal, 0, 8)
-			if err != nil {
-				return r.wrapError(err, col)
-			}
-			*(*uint8)(unsafe.Pointer(uintptr(p) + col.offset)) = uint8(v)
-		case reflect.Uint16:
-			v, err := strconv.ParseUint(colVal, 0, 16)
-			if err != nil {
-				return r.wrapError(err, col)
-			}
-			*(*uint16)(unsafe.Pointer(uintptr(p) + col.offset)) = uint16(v)
-		case reflect.Uint32:
-			v, err := strconv.ParseUint(colVal, 0, 32)
-			if err != nil {
-				return r.wrapError(err, col)
-
-			}
-			*(*uint32)(unsafe.Pointer(uintptr(p) + col.offset)) = uint32(v)
-		case reflect.Uint64:
-			v, err := strconv.ParseUint(colVal, 0, 64)
-			if err != nil {
-				return r.wrapError(err, col)
+		if err := r.fillColumn(col, row[col.index], unsafe.Pointer(uintptr(p)+col.offset)); err != nil {
+			return r.wrapError(err, *col)
+		}
+	}
+	return nil
+}
 
-			}
-			*(*uint64)(unsafe.Pointer(uintptr(p) + col.offset)) = v
-		case reflect.Uint:
-			v, err := strconv.ParseUint(colVal, 0, 64)
-			if err != nil {
-				return r.wrapError(err, col)
-			}
-			*(*uint)(unsafe.Pointer(uintptr(p) + col.offset)) = uint(v)
+// isNA reports whether the column value is one of the reader's NA tokens.
+func (r *Reader) isNA(colVal string) bool {
+	for _, na := range r.NATokens {
+		if colVal == na {
+			return true
+		}
+	}
+	return false
+}
 
-		case reflect.Float32:
-			v, err := strconv.ParseFloat(colVal, 32)
-			if err != nil {
-				return r.wrapError(err, col)
+// fillColumn parses colVal into the struct field at fp.
+func (r *Reader) fillColumn(col *columnFormat, colVal string, fp unsafe.Pointer) error {
+	if r.isNA(colVal) {
+		field := reflect.NewAt(col.typ, fp).Elem()
+		field.Set(reflect.Zero(col.typ))
+		return nil
+	}
+	if parse, ok := r.parsers[col.columnName]; ok {
+		return parse(colVal, reflect.NewAt(col.typ, fp).Interface())
+	}
+	typ := col.typ
+	if col.kind == reflect.Ptr {
+		// Parse the value into a new pointee, which is set only if parsing
+		// succeeds.
+		elem := reflect.New(typ.Elem())
+		if err := r.parseValue(col, typ.Elem(), colVal, unsafe.Pointer(elem.Pointer())); err != nil {
+			return err
+		}
+		reflect.NewAt(typ, fp).Elem().Set(elem)
+		return nil
+	}
+	return r.parseValue(col, typ, colVal, fp)
+}
 
-			}
-			*(*float32)(unsafe.Pointer(uintptr(p) + col.offset)) = float32(v)
-		case reflect.Float64:
-			v, err := strconv.ParseFloat(colVal, 64)
-			if err != nil {
-				return r.wrapError(err, col)
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
 
+// parseValue parses colVal into the value of type typ at fp.
+func (r *Reader) parseValue(col *columnFormat, typ reflect.Type, colVal string, fp unsafe.Pointer) error {
+	if col.fmt != "" {
+		// Not all format directives are recognized while scanning. Try to
+		// standardize some of the common options.
+		colfmt := col.fmt
+		if strings.ContainsAny(colfmt, "efg") {
+			// Standardize all base 10 floating point number formats to 'g', and
+			// drop precision and width which are not supported while scanning.
+			colfmt = "g"
+		}
+		if len(strings.Fields(colVal)) != 1 {
+			// Scanf functions tokenize by space.
+			return fmt.Errorf("value with fmt option can not have whitespace")
+		}
+		var (
+			v      = reflect.NewAt(typ, fp).Interface()
+			n, err = fmt.Sscanf(colVal, "%"+colfmt, v)
+		)
+		if err != nil {
+			return err
+		}
+		if n != 1 {
+			return fmt.Errorf("%d objects scanned for %s; expected 1", n, colVal)
+		}
+		return nil
+	}
+	if typ == timeType {
+		layouts := r.TimeLayouts
+		if col.layout != "" {
+			layouts = []string{col.layout}
+		} else if len(layouts) == 0 {
+			layouts = []string{time.RFC3339}
+		}
+		var err error
+		for _, layout := range layouts {
+			var t time.Time
+			if t, err = time.Parse(layout, colVal); err == nil {
+				*(*time.Time)(fp) = t
+				return nil
 			}
-			*(*float64)(unsafe.Pointer(uintptr(p) + col.offset)) = v
+		}
+		return err
+	}
+	if reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		return reflect.NewAt(typ, fp).Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(colVal))
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		var v bool
+		switch colVal {
+		case "Y", "yes":
+			v = true
+		case "N", "no":
+			v = false
 		default:
-			return r.wrapError(fmt.Errorf("unsupported type %v", col.kind), col)
+			var err error
+			if v, err = strconv.ParseBool(colVal); err != nil {
+				return err
+			}
+		}
+		*(*bool)(fp) = v
+	case reflect.String:
+		*(*string)(fp) = colVal
+	case reflect.Int8:
+		v, err := strconv.ParseInt(colVal, 0, 8)
+		if err != nil {
+			return err
+		}
+		*(*int8)(fp) = int8(v)
+	case reflect.Int16:
+		v, err := strconv.ParseInt(colVal, 0, 16)
+		if err != nil {
+			return err
+		}
+		*(*int16)(fp) = int16(v)
+	case reflect.Int32:
+		v, err := strconv.ParseInt(colVal, 0, 32)
+		if err != nil {
+			return err
+		}
+		*(*int32)(fp) = int32(v)
+	case reflect.Int64:
+		v, err := strconv.ParseInt(colVal, 0, 64)
+		if err != nil {
+			return err
+		}
+		*(*int64)(fp) = v
+	case reflect.Int:
+		v, err := strconv.ParseInt(colVal, 0, 64)
+		if err != nil {
+			return err
+		}
+		*(*int)(fp) = int(v)
+	case reflect.Uint8:
+		v, err := strconv.ParseUint(colVal, 0, 8)
+		if err != nil {
+			return err
+		}
+		*(*uint8)(fp) = uint8(v)
+	case reflect.Uint16:
+		v, err := strconv.ParseUint(colVal, 0, 16)
+		if err != nil {
+			return err
 		}
+		*(*uint16)(fp) = uint16(v)
+	case reflect.Uint32:
+		v, err := strconv.ParseUint(colVal, 0, 32)
+		if err != nil {
+			return err
+		}
+		*(*uint32)(fp) = uint32(v)
+	case reflect.Uint64:
+		v, err := strconv.ParseUint(colVal, 0, 64)
+		if err != nil {
+			return err
+		}
+		*(*uint64)(fp) = v
+	case reflect.Uint:
+		v, err := strconv.ParseUint(colVal, 0, 64)
+		if err != nil {
+			return err
+		}
+		*(*uint)(fp) = uint(v)
+	case reflect.Float32:
+		v, err := strconv.ParseFloat(colVal, 32)
+		if err != nil {
+			return err
+		}
+		*(*float32)(fp) = float32(v)
+	case reflect.Float64:
+		v, err := strconv.ParseFloat(colVal, 64)
+		if err != nil {
+			return err
+		}
+		*(*float64)(fp) = v
+	default:
+		return fmt.Errorf("unsupported type %v", typ.Kind())
 	}
 	return nil
 }
@@ -382,7 +516,21 @@ const EmptyReadErrStr = "empty file: could not read the header row"
 // The second Read() will return row{"chr2", 20, 950, 15}.
 //
 // Embedded structs are supported, and the default column name for nested
-// fields will be the unqualified name of the field.
+// fields will be the unqualified name of the field. Fields of other
+// (non-embedded) struct types are flattened if their tag has the flatten
+// option, e.g., `tsv:"pos,flatten"`: the column name of each nested field is
+// then "<outer column>.<nested column>". Otherwise, a struct field is read
+// from a single column, which requires a parser registered for it.
+//
+// Besides bool, string, and numeric fields, Read fills pointer fields (which
+// are set to nil for values in Reader.NATokens), time.Time fields, and fields
+// whose pointer type implements encoding.TextUnmarshaler. Time values are
+// parsed using the layout given by the tag's layout option, e.g.,
+// `tsv:"date,layout=2006-01-02"`, or else by Reader.TimeLayouts. Custom
+// parsers may be set for individual columns using RegisterParser.
+//
+// If Reader.SkipBadRows is set, rows that fail to parse are recorded in
+// Reader.BadRows and skipped.
 func (r *Reader) Read(v interface{}) error {
 	if r.nRow == 0 && r.HasHeaderRow {
 		headerRow, err := r.Reader.Read()
@@ -398,11 +546,6 @@ func (r *Reader) Read(v interface{}) error {
 			r.columnIndex[colName] = i
 		}
 	}
-	row, err := r.Reader.Read()
-	if err != nil {
-		return err
-	}
-	r.nRow++
 	typ := reflect.TypeOf(v)
 	if typ != r.cachedRowType {
 		format, err := parseRowFormat(typ)
@@ -418,5 +561,32 @@ func (r *Reader) Read(v interface{}) error {
 		r.cachedRowType = typ
 		r.cachedRowFormat = format
 	}
-	return r.fillRow(v, row)
+	for {
+		row, err := r.Reader.Read()
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok && r.SkipBadRows {
+				r.nRow++
+				r.addBadRow(row, err)
+				continue
+			}
+			return err
+		}
+		r.nRow++
+		if err := r.fillRow(v, row); err != nil {
+			if r.SkipBadRows {
+				r.addBadRow(row, err)
+				continue
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+func (r *Reader) addBadRow(row []string, err error) {
+	var rowCopy []string
+	if row != nil {
+		rowCopy = append([]string{}, row...)
+	}
+	r.BadRows = append(r.BadRows, BadRow{Line: r.nRow, Row: rowCopy, Err: err})
 }
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/grailbio/base/tsv"
 	"github.com/grailbio/testutil/assert"
@@ -211,6 +213,44 @@ key1	1.5	2	0.789
 	assert.EQ(t, r.Read(&v), io.EOF)
 }
 
+func TestReadNestedStruct(t *testing.T) {
+	type pos struct {
+		Chr   string
+		Start int `tsv:"start"`
+	}
+	// Without the flatten option, a struct field is read from a single
+	// column, by its registered parser.
+	type row struct {
+		Key string
+		Pos pos
+	}
+	r := tsv.NewReader(bytes.NewReader([]byte(`Key	Pos
+key0	chr1:10
+`)))
+	r.HasHeaderRow = true
+	r.UseHeaderNames = true
+	r.RegisterParser("Pos", func(value string, dest interface{}) error {
+		_, err := fmt.Sscanf(value, "%4s:%d", &dest.(*pos).Chr, &dest.(*pos).Start)
+		return err
+	})
+	var v row
+	assert.NoError(t, r.Read(&v))
+	expect.EQ(t, v, row{"key0", pos{"chr1", 10}})
+
+	type flatRow struct {
+		Key string
+		Pos pos `tsv:"pos,flatten"`
+	}
+	r = tsv.NewReader(bytes.NewReader([]byte(`Key	pos.Chr	pos.start
+key0	chr1	10
+`)))
+	r.HasHeaderRow = true
+	r.UseHeaderNames = true
+	var fv flatRow
+	assert.NoError(t, r.Read(&fv))
+	expect.EQ(t, fv, flatRow{"key0", pos{"chr1", 10}})
+}
+
 func TestReadExtraColumns(t *testing.T) {
 	type row struct {
 		ColA string
@@ -359,6 +399,114 @@ func TestReadMultipleRowTypes(t *testing.T) {
 	}
 }
 
+func TestReadNA(t *testing.T) {
+	type row struct {
+		ColA *int
+		ColB float64
+		ColC *string
+	}
+	r := tsv.NewReader(bytes.NewReader([]byte(`1	0.5	x
+NA		NA
+`)))
+	r.NATokens = []string{"NA", ""}
+	var v row
+	assert.NoError(t, r.Read(&v))
+	expect.EQ(t, *v.ColA, 1)
+	expect.EQ(t, v.ColB, 0.5)
+	expect.EQ(t, *v.ColC, "x")
+	assert.NoError(t, r.Read(&v))
+	expect.EQ(t, v, row{})
+	assert.EQ(t, r.Read(&v), io.EOF)
+}
+
+func TestReadPointerError(t *testing.T) {
+	type row struct {
+		ColA *int
+	}
+	r := tsv.NewReader(bytes.NewReader([]byte(`x
+`)))
+	var v row
+	expect.Regexp(t, r.Read(&v), "invalid syntax")
+	// The field is not allocated for a value that cannot be parsed.
+	expect.True(t, v.ColA == nil)
+}
+
+func TestReadTime(t *testing.T) {
+	type row struct {
+		Date  time.Time `tsv:"date,layout=2006-01-02"`
+		Stamp time.Time `tsv:"stamp"`
+		Opt   *time.Time
+		IP    net.IP
+	}
+	r := tsv.NewReader(bytes.NewReader([]byte(`date	stamp	Opt	IP
+2020-01-02	2020-01-02T03:04:05Z	NA	10.0.0.1
+2020-01-03	20200103	2020-01-03T00:00:00Z	::1
+`)))
+	r.HasHeaderRow = true
+	r.UseHeaderNames = true
+	r.NATokens = []string{"NA"}
+	r.TimeLayouts = []string{time.RFC3339, "20060102"}
+	var v row
+	assert.NoError(t, r.Read(&v))
+	expect.EQ(t, v.Date, time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+	expect.EQ(t, v.Stamp, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	expect.True(t, v.Opt == nil)
+	expect.EQ(t, v.IP.String(), "10.0.0.1")
+	assert.NoError(t, r.Read(&v))
+	expect.EQ(t, v.Stamp, time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC))
+	expect.EQ(t, *v.Opt, time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC))
+	expect.EQ(t, v.IP.String(), "::1")
+
+	r = tsv.NewReader(bytes.NewReader([]byte("2020/01/02\n")))
+	expect.Regexp(t, r.Read(&v), `line 1, column 0, 'date' \(Go field 'Date'\)`)
+}
+
+func TestReadCustomParser(t *testing.T) {
+	type row struct {
+		Flag bool
+		Name string
+	}
+	r := tsv.NewReader(bytes.NewReader([]byte(`+	a
+-	b
+`)))
+	r.RegisterParser("Flag", func(value string, dest interface{}) error {
+		*dest.(*bool) = value == "+"
+		return nil
+	})
+	rows, err := tsv.ReadAll[row](r)
+	assert.NoError(t, err)
+	expect.EQ(t, rows, []row{{true, "a"}, {false, "b"}})
+}
+
+func TestReadSkipBadRows(t *testing.T) {
+	type row struct {
+		Key   string
+		Value int
+	}
+	r := tsv.NewReader(bytes.NewReader([]byte(`Key	Value
+a	1
+b	x
+c	3
+d	4	extra
+e	5
+`)))
+	r.HasHeaderRow = true
+	r.SkipBadRows = true
+	var got []row
+	sc := tsv.NewScanner[row](r)
+	for sc.Scan() {
+		got = append(got, sc.Row())
+	}
+	assert.NoError(t, sc.Err())
+	expect.EQ(t, got, []row{{"a", 1}, {"c", 3}, {"e", 5}})
+	assert.EQ(t, len(r.BadRows), 2)
+	expect.EQ(t, r.BadRows[0].Line, 3)
+	expect.EQ(t, r.BadRows[0].Row, []string{"b", "x"})
+	expect.Regexp(t, r.BadRows[0].Err, "invalid syntax")
+	expect.EQ(t, r.BadRows[1].Line, 5)
+	expect.Regexp(t, r.BadRows[1].Err, "wrong number of fields")
+}
+
 func ExampleReader() {
 	type row struct {
 		Key  string
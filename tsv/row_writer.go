@@ -5,23 +5,61 @@
 package tsv
 
 import (
+	"context"
+	"encoding"
 	"fmt"
 	"io"
 	"reflect"
+	"strings"
+	"time"
 	"unsafe"
+
+	"github.com/grailbio/base/compress"
+	"github.com/grailbio/base/errors"
 )
 
 // RowWriter writes structs to TSV files using field names or "tsv" tags
-// as TSV column headers.
-//
-// TODO: Consider letting the caller filter or reorder columns.
+// as TSV column headers. It accepts the same struct types, tags, and
+// options as Reader, so that the output of RowWriter can be read back by
+// Reader; and, conversely, rows read by Reader are written back
+// byte-for-byte when the input is in RowWriter's canonical form.
 type RowWriter struct {
+	// SkipHeader suppresses the header row. It must be set before the first
+	// Write.
+	SkipHeader bool
+
+	// Columns, if non-empty, lists the names of the columns to write, in
+	// order. Other columns are omitted. It must be set before the first
+	// Write.
+	Columns []string
+
+	// NAToken is written for nil pointer fields. It should be one of the
+	// reader's NATokens.
+	NAToken string
+
+	// TimeLayout is the layout used to write time.Time fields that do not
+	// have a layout option in their tag. If empty, time.RFC3339Nano is used.
+	TimeLayout string
+
+	// FloatFmt and FloatPrec are the strconv.AppendFloat format and precision
+	// used for floating point fields without an fmt option. If FloatFmt is
+	// zero, floats are written in the shortest representation that parses
+	// back to the same value.
+	FloatFmt  byte
+	FloatPrec int
+
 	w               Writer
+	closer          io.Closer
 	headerDone      bool
 	cachedRowType   reflect.Type
 	cachedRowFormat rowFormat
+	formatters      map[string]FormatFunc
 }
 
+// FormatFunc formats a column value. Src is a pointer to the struct field to
+// be written.
+type FormatFunc func(src interface{}) (string, error)
+
 // NewRowWriter constructs a writer.
 //
 // User must call Flush() after last Write().
@@ -29,6 +67,37 @@ func NewRowWriter(w io.Writer) *RowWriter {
 	return &RowWriter{w: *NewWriter(w)}
 }
 
+// NewRowWriterPath constructs a writer that compresses its output according
+// to the extension of path (".gz" for gzip, ".zst" for zstd; see
+// compress.NewWriterPath). The output is written to w.
+//
+// User must call Close() after last Write(). Close does not close w.
+func NewRowWriterPath(w io.Writer, path string) *RowWriter {
+	cw, _ := compress.NewWriterPath(w, path)
+	return &RowWriter{w: *NewWriter(cw), closer: cw}
+}
+
+// CreateRowWriter creates the file at path and returns a writer to it. The
+// output is compressed according to the extension of path, as in
+// NewRowWriterPath.
+//
+// User must call Close() after last Write().
+func CreateRowWriter(ctx context.Context, path string) *RowWriter {
+	cw, _ := compress.Create(ctx, path)
+	return &RowWriter{w: *NewWriter(cw), closer: cw}
+}
+
+// RegisterFormatter registers a custom formatter for the named column. The
+// column name is the name of the struct field or the name set in its `tsv`
+// tag. A custom formatter takes precedence over all other formatting, except
+// for NAToken. It must be called before the first Write.
+func (w *RowWriter) RegisterFormatter(column string, format FormatFunc) {
+	if w.formatters == nil {
+		w.formatters = map[string]FormatFunc{}
+	}
+	w.formatters[column] = format
+}
+
 // Write writes a TSV row containing the values of v's exported fields.
 // v must be a pointer to a struct.
 //
@@ -45,7 +114,15 @@ func NewRowWriter(w io.Writer) *RowWriter {
 // for each type. Using the fmt option may lead to slower performance.
 //
 // Embedded structs are supported, and the default column name for nested
-// fields will be the unqualified name of the field.
+// fields will be the unqualified name of the field. Fields of other struct
+// types that have the flatten tag option are flattened as described in
+// Reader.Read.
+//
+// Pointer fields are written as the value they point to, or NAToken if nil.
+// Time.Time fields are written using the tag's layout option, or else
+// TimeLayout. Fields whose type implements encoding.TextMarshaler are written
+// using MarshalText. Values containing tabs, newlines, or double quotes are
+// quoted as in encoding/csv.
 func (w *RowWriter) Write(v interface{}) error {
 	typ := reflect.TypeOf(v)
 	if typ != w.cachedRowType {
@@ -53,12 +130,19 @@ func (w *RowWriter) Write(v interface{}) error {
 		if err != nil {
 			return err
 		}
+		if len(w.Columns) > 0 {
+			if rowFormat, err = selectColumns(rowFormat, w.Columns); err != nil {
+				return err
+			}
+		}
 		w.cachedRowType = typ
 		w.cachedRowFormat = rowFormat
 	}
 	if !w.headerDone {
-		if err := w.writeHeader(); err != nil {
-			return err
+		if !w.SkipHeader {
+			if err := w.writeHeader(); err != nil {
+				return err
+			}
 		}
 		w.headerDone = true
 	}
@@ -70,75 +154,173 @@ func (w *RowWriter) Flush() error {
 	return w.w.Flush()
 }
 
+// Close flushes all previously-written rows, and closes the compressor and
+// file created by NewRowWriterPath or CreateRowWriter, if any.
+func (w *RowWriter) Close() error {
+	err := w.Flush()
+	if w.closer != nil {
+		errors.CleanUp(w.closer.Close, &err)
+	}
+	return err
+}
+
+// selectColumns returns the columns of format with the provided names, in
+// order.
+func selectColumns(format rowFormat, names []string) (rowFormat, error) {
+	var selected rowFormat
+	for _, name := range names {
+		i := 0
+		for ; i < len(format); i++ {
+			if format[i].columnName == name {
+				break
+			}
+		}
+		if i == len(format) {
+			return nil, fmt.Errorf("column %s does not appear in the row format %v", name, format)
+		}
+		col := format[i]
+		col.index = len(selected)
+		selected = append(selected, col)
+	}
+	return selected, nil
+}
+
 func (w *RowWriter) writeHeader() error {
 	for _, col := range w.cachedRowFormat {
-		w.w.WriteString(col.columnName)
+		w.writeField(col.columnName)
+	}
+	return w.endLine()
+}
+
+// writeField writes a string field, quoting it if needed to be read back by
+// encoding/csv.
+func (w *RowWriter) writeField(s string) {
+	if s == "" && len(w.cachedRowFormat) == 1 {
+		// An empty line would be skipped by the reader.
+		w.w.WriteString(`""`)
+		return
+	}
+	if !strings.ContainsAny(s, "\t\n\r\"") {
+		w.w.WriteString(s)
+		return
+	}
+	w.w.WriteString(`"` + strings.Replace(s, `"`, `""`, -1) + `"`)
+}
+
+func (w *RowWriter) endLine() error {
+	if len(w.cachedRowFormat) == 0 {
+		return nil
 	}
 	return w.w.EndLine()
 }
 
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
 func (w *RowWriter) writeRow(v interface{}) error {
 	p := unsafe.Pointer(reflect.ValueOf(v).Pointer())
-	for _, col := range w.cachedRowFormat {
-		if col.fmt != "" {
-			var (
-				typ1 = col.typ
-				p1   = unsafe.Pointer(uintptr(p) + col.offset)
-				v    = reflect.Indirect(reflect.NewAt(typ1, p1))
-			)
-			w.w.WriteString(fmt.Sprintf("%"+col.fmt, v))
+	for i := range w.cachedRowFormat {
+		col := &w.cachedRowFormat[i]
+		fp := unsafe.Pointer(uintptr(p) + col.offset)
+		if format, ok := w.formatters[col.columnName]; ok && !w.isNil(col, fp) {
+			s, err := format(reflect.NewAt(col.typ, fp).Interface())
+			if err != nil {
+				return errors.E(err, fmt.Sprintf("column %s", col.columnName))
+			}
+			w.writeField(s)
 			continue
 		}
-		switch col.kind {
-		case reflect.Bool:
-			v := *(*bool)(unsafe.Pointer(uintptr(p) + col.offset))
-			if v {
-				w.w.WriteString("true")
-			} else {
-				w.w.WriteString("false")
+		typ := col.typ
+		if col.kind == reflect.Ptr {
+			if w.isNil(col, fp) {
+				w.writeField(w.NAToken)
+				continue
 			}
-		case reflect.String:
-			v := *(*string)(unsafe.Pointer(uintptr(p) + col.offset))
-			w.w.WriteString(v)
-		case reflect.Int8:
-			v := *(*int8)(unsafe.Pointer(uintptr(p) + col.offset))
-			w.w.WriteInt64(int64(v))
-		case reflect.Int16:
-			v := *(*int16)(unsafe.Pointer(uintptr(p) + col.offset))
-			w.w.WriteInt64(int64(v))
-		case reflect.Int32:
-			v := *(*int32)(unsafe.Pointer(uintptr(p) + col.offset))
-			w.w.WriteInt64(int64(v))
-		case reflect.Int64:
-			v := *(*int64)(unsafe.Pointer(uintptr(p) + col.offset))
-			w.w.WriteInt64(int64(v))
-		case reflect.Int:
-			v := *(*int)(unsafe.Pointer(uintptr(p) + col.offset))
-			w.w.WriteInt64(int64(v))
-		case reflect.Uint8:
-			v := *(*uint8)(unsafe.Pointer(uintptr(p) + col.offset))
-			w.w.WriteUint64(uint64(v))
-		case reflect.Uint16:
-			v := *(*uint16)(unsafe.Pointer(uintptr(p) + col.offset))
-			w.w.WriteUint64(uint64(v))
-		case reflect.Uint32:
-			v := *(*uint32)(unsafe.Pointer(uintptr(p) + col.offset))
-			w.w.WriteUint64(uint64(v))
-		case reflect.Uint64:
-			v := *(*uint64)(unsafe.Pointer(uintptr(p) + col.offset))
-			w.w.WriteUint64(uint64(v))
-		case reflect.Uint:
-			v := *(*uint)(unsafe.Pointer(uintptr(p) + col.offset))
-			w.w.WriteUint64(uint64(v))
-		case reflect.Float32:
-			v := *(*float32)(unsafe.Pointer(uintptr(p) + col.offset))
-			w.w.WriteFloat64(float64(v), 'g', -1)
-		case reflect.Float64:
-			v := *(*float64)(unsafe.Pointer(uintptr(p) + col.offset))
-			w.w.WriteFloat64(v, 'g', -1)
-		default:
-			return fmt.Errorf("unsupported type %v", col.kind)
+			typ = typ.Elem()
+			fp = unsafe.Pointer(reflect.NewAt(col.typ, fp).Elem().Pointer())
+		}
+		if err := w.writeValue(col, typ, fp); err != nil {
+			return errors.E(err, fmt.Sprintf("column %s", col.columnName))
 		}
 	}
-	return w.w.EndLine()
+	return w.endLine()
+}
+
+// isNil reports whether the field at fp is a nil pointer.
+func (w *RowWriter) isNil(col *columnFormat, fp unsafe.Pointer) bool {
+	return col.kind == reflect.Ptr && *(*unsafe.Pointer)(fp) == nil
+}
+
+// writeValue writes the value of type typ at fp.
+func (w *RowWriter) writeValue(col *columnFormat, typ reflect.Type, fp unsafe.Pointer) error {
+	if col.fmt != "" {
+		v := reflect.NewAt(typ, fp).Elem()
+		w.writeField(fmt.Sprintf("%"+col.fmt, v))
+		return nil
+	}
+	if typ == timeType {
+		layout := col.layout
+		if layout == "" {
+			layout = w.TimeLayout
+		}
+		if layout == "" {
+			layout = time.RFC3339Nano
+		}
+		w.writeField((*time.Time)(fp).Format(layout))
+		return nil
+	}
+	if reflect.PtrTo(typ).Implements(textMarshalerType) {
+		text, err := reflect.NewAt(typ, fp).Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		w.writeField(string(text))
+		return nil
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		if *(*bool)(fp) {
+			w.w.WriteString("true")
+		} else {
+			w.w.WriteString("false")
+		}
+	case reflect.String:
+		w.writeField(*(*string)(fp))
+	case reflect.Int8:
+		w.w.WriteInt64(int64(*(*int8)(fp)))
+	case reflect.Int16:
+		w.w.WriteInt64(int64(*(*int16)(fp)))
+	case reflect.Int32:
+		w.w.WriteInt64(int64(*(*int32)(fp)))
+	case reflect.Int64:
+		w.w.WriteInt64(*(*int64)(fp))
+	case reflect.Int:
+		w.w.WriteInt64(int64(*(*int)(fp)))
+	case reflect.Uint8:
+		w.w.WriteUint64(uint64(*(*uint8)(fp)))
+	case reflect.Uint16:
+		w.w.WriteUint64(uint64(*(*uint16)(fp)))
+	case reflect.Uint32:
+		w.w.WriteUint64(uint64(*(*uint32)(fp)))
+	case reflect.Uint64:
+		w.w.WriteUint64(*(*uint64)(fp))
+	case reflect.Uint:
+		w.w.WriteUint64(uint64(*(*uint)(fp)))
+	case reflect.Float32:
+		w.writeFloat(float64(*(*float32)(fp)), 32)
+	case reflect.Float64:
+		w.writeFloat(*(*float64)(fp), 64)
+	default:
+		return fmt.Errorf("unsupported type %v", typ.Kind())
+	}
+	return nil
+}
+
+// writeFloat writes v, which was converted from a float of the given bit
+// size, so that the shortest representation is that of the original value.
+func (w *RowWriter) writeFloat(v float64, bitSize int) {
+	if w.FloatFmt != 0 {
+		w.w.writeFloat(v, w.FloatFmt, w.FloatPrec, bitSize)
+		return
+	}
+	w.w.writeFloat(v, 'g', -1, bitSize)
 }
@@ -0,0 +1,70 @@
+// Copyright 2018 GRAIL, Inc.  All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package tsv
+
+import "io"
+
+// Scanner reads rows of type T, which must be a struct type, from a Reader.
+//
+// Example:
+//   sc := tsv.NewScanner[row](r)
+//   for sc.Scan() {
+//     v := sc.Row()
+//     ...
+//   }
+//   if err := sc.Err(); err != nil {
+//     ...
+//   }
+type Scanner[T any] struct {
+	r   *Reader
+	row T
+	err error
+}
+
+// NewScanner creates a scanner that reads rows of type T from r.
+func NewScanner[T any](r *Reader) *Scanner[T] {
+	return &Scanner[T]{r: r}
+}
+
+// Scan reads the next row, returning true on success. When Scan returns
+// false, the caller should inspect Err to distinguish between the end of
+// input and an error.
+func (s *Scanner[T]) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	var v T
+	if err := s.r.Read(&v); err != nil {
+		s.err = err
+		return false
+	}
+	s.row = v
+	return true
+}
+
+// Row returns the row read by the last call to Scan.
+func (s *Scanner[T]) Row() T { return s.row }
+
+// Err returns the error encountered while scanning, if any. It returns nil
+// at the end of input.
+func (s *Scanner[T]) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// ReadAll reads all remaining rows of type T, which must be a struct type,
+// from r.
+func ReadAll[T any](r *Reader) ([]T, error) {
+	var (
+		rows []T
+		sc   = NewScanner[T](r)
+	)
+	for sc.Scan() {
+		rows = append(rows, sc.Row())
+	}
+	return rows, sc.Err()
+}
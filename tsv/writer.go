@@ -68,7 +68,12 @@ func (w *Writer) WriteUint64(ui uint64) {
 // strconv.AppendFloat parameters, and appends that and a tab to the current
 // line.
 func (w *Writer) WriteFloat64(f float64, fmt byte, prec int) {
-	w.line = strconv.AppendFloat(w.line, f, fmt, prec, 64)
+	w.writeFloat(f, fmt, prec, 64)
+}
+
+// writeFloat is WriteFloat64 for a float of the given bit size.
+func (w *Writer) writeFloat(f float64, fmt byte, prec, bitSize int) {
+	w.line = strconv.AppendFloat(w.line, f, fmt, prec, bitSize)
 	w.line = append(w.line, '\t')
 }
 
@@ -0,0 +1,12 @@
+// Copyright 2018 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package ttlcache
+
+import "time"
+
+// Only for use in unit tests.
+func SweepTyped[K comparable, V any](c *TypedCache[K, V]) {
+	c.c.sweepExpired(time.Now())
+}
@@ -4,57 +4,370 @@
 //
 // Package ttlcache implements a cache with a fixed TTL. The keys and values
 // are interface{} and the TTL for an item starts decreasing each time the item
-// is added to the cache.
+// is added to the cache. TypedCache is a variant with typed keys and values.
 //
-// There is no active garbage collection but expired items are deleted
-// from the cache upon new 'Get' calls. This is a lazy strategy that
-// does not prevent memory leaks.
+// By default there is no active garbage collection: expired items are deleted
+// from the cache upon new 'Get' calls. This is a lazy strategy that does not
+// prevent memory leaks. Caches created with NewWithOpts may instead sweep
+// expired items periodically, and may bound the number of items.
+//
+// Caches are guarded by a single read-write mutex. Get takes only the read
+// lock, unless the cache is bounded by MaxEntries, in which case it updates
+// the order in which items were used. BenchmarkCache compares this with
+// sharding the cache by key, which callers with heavy write contention across
+// many cores may do themselves.
 
 package ttlcache
 
 import (
+	"container/list"
 	"sync"
 	"time"
 )
 
-type cacheValue struct {
-	value      interface{}
-	expiration time.Time
+// EvictReason is the reason an item was removed from a cache.
+type EvictReason int
+
+const (
+	// Expired means that the item's TTL elapsed.
+	Expired EvictReason = iota
+	// Capacity means that the item was evicted to satisfy Opts.MaxEntries.
+	Capacity
+)
+
+// String implements fmt.Stringer.
+func (r EvictReason) String() string {
+	switch r {
+	case Expired:
+		return "expired"
+	case Capacity:
+		return "capacity"
+	default:
+		return "unknown"
+	}
 }
 
+// Opts configures a Cache.
+type Opts struct {
+	// SweepInterval, if > 0, is the interval at which a background goroutine
+	// removes expired items. The goroutine runs until Close is called.
+	SweepInterval time.Duration
+	// MaxEntries, if > 0, is the maximum number of items. When it is
+	// exceeded, the least recently used item is evicted. Tracking use makes
+	// Get take the cache's write lock.
+	MaxEntries int
+	// OnEvict, if not nil, is called for each item that is removed from the
+	// cache because it expired or was evicted. It is not called for items
+	// that are replaced by Set or removed by Delete. It is called without any
+	// of the cache's locks held, so it may call into the cache.
+	OnEvict func(key, value interface{}, reason EvictReason)
+}
+
+// Cache is a cache with interface{} keys and values. Caches are
+// concurrency-safe.
 type Cache struct {
-	cache map[interface{}]cacheValue
-	mu    sync.Mutex
-	ttl   time.Duration
+	c *cache[interface{}]
 }
 
+// New returns a cache whose items expire ttl after they are set. Expired
+// items are removed lazily.
 func New(ttl time.Duration) *Cache {
-	return &Cache{
-		cache: map[interface{}]cacheValue{},
-		ttl:   ttl,
-	}
+	return NewWithOpts(ttl, Opts{})
+}
+
+// NewWithOpts returns a cache whose items expire ttl after they are set,
+// configured by opts. If opts.SweepInterval > 0, Close must be called to
+// release the cache's resources.
+func NewWithOpts(ttl time.Duration, opts Opts) *Cache {
+	return &Cache{newCache(ttl, opts.SweepInterval, opts.MaxEntries, opts.OnEvict)}
 }
 
+// Get returns the value for key, if it is present and not expired.
 func (c *Cache) Get(key interface{}) (interface{}, bool) {
-	c.mu.Lock()
-	v, ok := c.cache[key]
+	return c.c.get(key)
+}
 
-	if ok && v.expiration.After(time.Now()) {
-		c.mu.Unlock()
-		return v.value, true
+// Set sets the value for key, with the cache's TTL.
+func (c *Cache) Set(key interface{}, value interface{}) {
+	c.c.set(key, value, c.c.ttl)
+}
+
+// SetWithTTL sets the value for key, which expires after ttl instead of the
+// cache's TTL.
+func (c *Cache) SetWithTTL(key interface{}, value interface{}, ttl time.Duration) {
+	c.c.set(key, value, ttl)
+}
+
+// Delete removes the item for key, if any.
+func (c *Cache) Delete(key interface{}) {
+	c.c.delete(key)
+}
+
+// Len returns the number of items in the cache, including expired items that
+// have not yet been removed.
+func (c *Cache) Len() int {
+	return c.c.len()
+}
+
+// Close stops the cache's background sweeping, if any. The cache remains
+// usable after Close, but expired items are again removed lazily.
+func (c *Cache) Close() {
+	c.c.close()
+}
+
+// TypedOpts configures a TypedCache. Its fields are as in Opts.
+type TypedOpts[K comparable, V any] struct {
+	SweepInterval time.Duration
+	MaxEntries    int
+	OnEvict       func(key K, value V, reason EvictReason)
+}
+
+// TypedCache is a cache with typed keys and values. It behaves as Cache.
+type TypedCache[K comparable, V any] struct {
+	c *cache[V]
+}
+
+// NewTyped returns a cache whose items expire ttl after they are set,
+// configured by opts. If opts.SweepInterval > 0, Close must be called to
+// release the cache's resources.
+func NewTyped[K comparable, V any](ttl time.Duration, opts TypedOpts[K, V]) *TypedCache[K, V] {
+	var onEvict func(interface{}, V, EvictReason)
+	if opts.OnEvict != nil {
+		onEvict = func(key interface{}, value V, reason EvictReason) {
+			opts.OnEvict(key.(K), value, reason)
+		}
+	}
+	return &TypedCache[K, V]{newCache(ttl, opts.SweepInterval, opts.MaxEntries, onEvict)}
+}
+
+// Get returns the value for key, if it is present and not expired.
+func (c *TypedCache[K, V]) Get(key K) (V, bool) {
+	return c.c.get(key)
+}
+
+// Set sets the value for key, with the cache's TTL.
+func (c *TypedCache[K, V]) Set(key K, value V) {
+	c.c.set(key, value, c.c.ttl)
+}
+
+// SetWithTTL sets the value for key, which expires after ttl instead of the
+// cache's TTL.
+func (c *TypedCache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	c.c.set(key, value, ttl)
+}
+
+// Delete removes the item for key, if any.
+func (c *TypedCache[K, V]) Delete(key K) {
+	c.c.delete(key)
+}
+
+// Len returns the number of items in the cache, including expired items that
+// have not yet been removed.
+func (c *TypedCache[K, V]) Len() int {
+	return c.c.len()
+}
+
+// Close stops the cache's background sweeping, if any.
+func (c *TypedCache[K, V]) Close() {
+	c.c.close()
+}
+
+// cache implements Cache and TypedCache. Keys are stored as interface{} so
+// that Cache, whose keys are not necessarily comparable types, can share it.
+type cache[V any] struct {
+	ttl        time.Duration
+	maxEntries int
+	onEvict    func(key interface{}, value V, reason EvictReason)
+
+	mu    sync.RWMutex
+	cache map[interface{}]*list.Element
+	// lru orders the items from most to least recently used if maxEntries
+	// > 0, and otherwise from most to least recently set. Its values are
+	// *cacheValue[V].
+	lru list.List
+
+	stop      chan struct{}
+	closeOnce sync.Once
+	sweepDone chan struct{}
+}
+
+type cacheValue[V any] struct {
+	key        interface{}
+	value      V
+	expiration time.Time
+}
+
+// evicted is an item removed from the cache, whose OnEvict callback is
+// pending.
+type evicted[V any] struct {
+	*cacheValue[V]
+	reason EvictReason
+}
+
+func newCache[V any](ttl, sweepInterval time.Duration, maxEntries int, onEvict func(interface{}, V, EvictReason)) *cache[V] {
+	c := &cache[V]{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		onEvict:    onEvict,
+		cache:      map[interface{}]*list.Element{},
+	}
+	if sweepInterval > 0 {
+		c.stop = make(chan struct{})
+		c.sweepDone = make(chan struct{})
+		go c.sweep(sweepInterval)
 	}
+	return c
+}
+
+func (c *cache[V]) get(key interface{}) (V, bool) {
+	var zero V
+	if c.maxEntries == 0 {
+		// There is no use order to update, so unexpired items are read
+		// under the read lock.
+		c.mu.RLock()
+		elem, ok := c.cache[key]
+		var v *cacheValue[V]
+		if ok {
+			v = elem.Value.(*cacheValue[V])
+		}
+		c.mu.RUnlock()
+		if !ok {
+			return zero, false
+		}
+		if v.expiration.After(time.Now()) {
+			return v.value, true
+		}
+	}
+	var evict []evicted[V]
+	c.mu.Lock()
+	elem, ok := c.cache[key]
 	if ok {
-		delete(c.cache, key) // key is expired - delete it.
+		v := elem.Value.(*cacheValue[V])
+		if v.expiration.After(time.Now()) {
+			if c.maxEntries > 0 {
+				c.lru.MoveToFront(elem)
+			}
+			c.mu.Unlock()
+			return v.value, true
+		}
+		// key is expired - delete it.
+		evict = c.removeLocked(elem, Expired, evict)
 	}
 	c.mu.Unlock()
-	return nil, false
+	c.notify(evict)
+	return zero, false
 }
 
-func (c *Cache) Set(key interface{}, value interface{}) {
+func (c *cache[V]) set(key interface{}, value V, ttl time.Duration) {
+	var evict []evicted[V]
+	c.mu.Lock()
+	v := &cacheValue[V]{key: key, value: value, expiration: time.Now().Add(ttl)}
+	if elem, ok := c.cache[key]; ok {
+		elem.Value = v
+		c.lru.MoveToFront(elem)
+	} else {
+		c.cache[key] = c.lru.PushFront(v)
+	}
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		evict = c.removeLocked(c.lru.Back(), Capacity, evict)
+	}
+	c.mu.Unlock()
+	c.notify(evict)
+}
+
+func (c *cache[V]) delete(key interface{}) {
 	c.mu.Lock()
-	c.cache[key] = cacheValue{
-		value:      value,
-		expiration: time.Now().Add(c.ttl),
+	if elem, ok := c.cache[key]; ok {
+		c.lru.Remove(elem)
+		delete(c.cache, key)
 	}
 	c.mu.Unlock()
 }
+
+func (c *cache[V]) len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lru.Len()
+}
+
+// removeLocked removes elem from the cache, and appends it to evict if there
+// is an OnEvict callback.
+func (c *cache[V]) removeLocked(elem *list.Element, reason EvictReason, evict []evicted[V]) []evicted[V] {
+	v := c.lru.Remove(elem).(*cacheValue[V])
+	delete(c.cache, v.key)
+	if c.onEvict != nil {
+		evict = append(evict, evicted[V]{v, reason})
+	}
+	return evict
+}
+
+func (c *cache[V]) notify(evict []evicted[V]) {
+	for _, e := range evict {
+		c.onEvict(e.key, e.value, e.reason)
+	}
+}
+
+// sweepChunk is the number of items examined by a sweep each time it
+// takes the cache's lock.
+const sweepChunk = 1024
+
+// sweep removes expired items every interval, until the cache is closed.
+func (c *cache[V]) sweep(interval time.Duration) {
+	defer close(c.sweepDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+		c.sweepExpired(time.Now())
+	}
+}
+
+// sweepExpired removes the items that expired as of now.
+func (c *cache[V]) sweepExpired(now time.Time) {
+	c.mu.Lock()
+	elem := c.lru.Back()
+	for elem != nil {
+		// Examine a chunk of items, from least to most recently used,
+		// and then release the lock so that other operations are not
+		// blocked for the duration of the sweep.
+		var evict []evicted[V]
+		for i := 0; i < sweepChunk && elem != nil; i++ {
+			prev := elem.Prev()
+			if !elem.Value.(*cacheValue[V]).expiration.After(now) {
+				evict = c.removeLocked(elem, Expired, evict)
+			}
+			elem = prev
+		}
+		var (
+			value interface{}
+			next  *list.Element
+		)
+		if elem != nil {
+			value, next = elem.Value, elem.Next()
+		}
+		c.mu.Unlock()
+		c.notify(evict)
+		c.mu.Lock()
+		if elem != nil && (c.cache[value.(*cacheValue[V]).key] != elem || elem.Value != value || elem.Next() != next) {
+			// The item at which to resume was removed, replaced, or moved
+			// to the front while the lock was released, or the item after
+			// it was. Restart from the back, so that no item is missed.
+			elem = c.lru.Back()
+		}
+	}
+	c.mu.Unlock()
+}
+
+func (c *cache[V]) close() {
+	if c.stop == nil {
+		return
+	}
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		<-c.sweepDone
+	})
+}
@@ -5,6 +5,7 @@
 package ttlcache_test
 
 import (
+	"fmt"
 	"math/rand"
 	"sync"
 	"testing"
@@ -75,3 +76,162 @@ func TestCacheConcurrent(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestCacheSweep(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		evicted []interface{}
+	)
+	c := ttlcache.NewWithOpts(time.Millisecond, ttlcache.Opts{
+		SweepInterval: time.Millisecond,
+		OnEvict: func(key, value interface{}, reason ttlcache.EvictReason) {
+			if reason != ttlcache.Expired {
+				t.Errorf("unexpected reason %v for %v", reason, key)
+			}
+			mu.Lock()
+			evicted = append(evicted, key)
+			mu.Unlock()
+		},
+	})
+	defer c.Close()
+	c.Set(1, "1")
+	c.SetWithTTL(2, "2", time.Hour)
+	deadline := time.Now().Add(10 * time.Second)
+	for c.Len() > 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expired item was not swept")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := c.Get(2); !ok {
+		t.Error("item with longer TTL was swept")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(evicted) != 1 || evicted[0] != 1 {
+		t.Errorf("got evicted %v, want [1]", evicted)
+	}
+}
+
+func TestCacheSweepMany(t *testing.T) {
+	const n = 5000
+	c := ttlcache.NewTyped(time.Millisecond, ttlcache.TypedOpts[int, int]{SweepInterval: time.Millisecond})
+	defer c.Close()
+	for i := 0; i < n; i++ {
+		if i%1000 == 0 {
+			c.SetWithTTL(i, i, time.Hour)
+		} else {
+			c.Set(i, i)
+		}
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for c.Len() > n/1000 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expired items were not swept: %d items remain", c.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < n; i += 1000 {
+		if _, ok := c.Get(i); !ok {
+			t.Errorf("item %d with longer TTL was swept", i)
+		}
+	}
+}
+
+func TestCacheSweepMoved(t *testing.T) {
+	const n = 3000
+	var c *ttlcache.TypedCache[int, int]
+	c = ttlcache.NewTyped(time.Hour, ttlcache.TypedOpts[int, int]{
+		MaxEntries: n,
+		OnEvict: func(key, value int, reason ttlcache.EvictReason) {
+			if key == 0 {
+				// Move the item at which the sweep resumes to the front.
+				c.Get(1024)
+			}
+		},
+	})
+	for i := 0; i < n; i++ {
+		if i == 1024 {
+			c.Set(i, i)
+		} else {
+			c.SetWithTTL(i, i, 0)
+		}
+	}
+	ttlcache.SweepTyped(c)
+	if got, want := c.Len(), 1; got != want {
+		t.Errorf("got %d items after sweep, want %d", got, want)
+	}
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	var evicted []int
+	c := ttlcache.NewTyped(time.Minute, ttlcache.TypedOpts[int, string]{
+		MaxEntries: 2,
+		OnEvict: func(key int, value string, reason ttlcache.EvictReason) {
+			if reason != ttlcache.Capacity {
+				t.Errorf("unexpected reason %v for %v", reason, key)
+			}
+			evicted = append(evicted, key)
+		},
+	})
+	c.Set(1, "1")
+	c.Set(2, "2")
+	c.Get(1)
+	c.Set(3, "3")
+	if _, ok := c.Get(2); ok {
+		t.Error("least recently used item was not evicted")
+	}
+	for _, key := range []int{1, 3} {
+		if v, ok := c.Get(key); !ok || v != fmt.Sprint(key) {
+			t.Errorf("got (%v, %v) for %v", v, ok, key)
+		}
+	}
+	c.Delete(1)
+	if got, want := c.Len(), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if len(evicted) != 1 || evicted[0] != 2 {
+		t.Errorf("got evicted %v, want [2]", evicted)
+	}
+}
+
+// shardedCache is a cache sharded by key, for comparison with the single
+// mutex in BenchmarkCache.
+type shardedCache []*ttlcache.TypedCache[int, int]
+
+func (s shardedCache) Get(key int) (int, bool) { return s[key%len(s)].Get(key) }
+func (s shardedCache) Set(key, value int)      { s[key%len(s)].Set(key, value) }
+
+func BenchmarkCache(b *testing.B) {
+	const nkeys = 1 << 12
+	for _, maxEntries := range []int{0, nkeys} {
+		for _, shards := range []int{1, 16} {
+			for _, writePct := range []int{1, 50} {
+				benchmarkCache(b, nkeys, maxEntries, shards, writePct)
+			}
+		}
+	}
+}
+
+func benchmarkCache(b *testing.B, nkeys, maxEntries, shards, writePct int) {
+	b.Run(fmt.Sprintf("max=%d,shards=%d,write=%d%%", maxEntries, shards, writePct), func(b *testing.B) {
+		c := make(shardedCache, shards)
+		for i := range c {
+			c[i] = ttlcache.NewTyped(time.Minute, ttlcache.TypedOpts[int, int]{MaxEntries: maxEntries})
+		}
+		for i := 0; i < nkeys; i++ {
+			c.Set(i, i)
+		}
+		b.RunParallel(func(pb *testing.PB) {
+			r := rand.New(rand.NewSource(rand.Int63()))
+			for pb.Next() {
+				key := r.Intn(nkeys)
+				if r.Intn(100) < writePct {
+					c.Set(key, key)
+				} else {
+					c.Get(key)
+				}
+			}
+		})
+	})
+}